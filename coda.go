@@ -15,6 +15,8 @@ import (
 //go:embed .version
 var VERSION string
 
+// fns holds the function definitions used to build the schema, every coda
+// instance creates its own set bound to the state of its run
 var fns = fn.New(VERSION)
 
 // CodaSettings contains the settings for the coda engine
@@ -27,6 +29,18 @@ type CodaSettings struct {
 
 	// Return the coda settings and operations
 	Extended bool `json:"extended" yaml:"extended"` // optional
+
+	// Fail operations on missing variable paths, unknown filters and filter errors
+	Strict bool `json:"strict" yaml:"strict"` // optional
+
+	// Configure the blob store holding binary values of the run
+	Blobs *fn.BlobOptions `json:"blobs,omitempty" yaml:"blobs,omitempty"` // optional
+
+	// Named HTTP sessions available to all http actions of the run
+	HttpSessions map[string]fn.HttpSessionParams `json:"http_sessions,omitempty" yaml:"http_sessions,omitempty"` // optional
+
+	// Named S3, HTTP, SMTP and AI connections referenced by operations with `connection`
+	Connections map[string]fn.Connection `json:"connections,omitempty" yaml:"connections,omitempty"` // optional
 }
 
 // Operation is a single operation to be executed
@@ -60,6 +74,14 @@ type Coda struct {
 	source    source
 	mutex     sync.RWMutex
 	blacklist []fn.FnCategory `json:"-" yaml:"-"`
+	strict    bool
+	sandboxed bool
+	sandbox   []fn.SandboxRoot
+	policy    *Policy
+
+	templates     *templateCache
+	snapshots     map[string][]byte
+	snapshotMutex sync.Mutex
 }
 
 type codaDTO struct {
@@ -118,6 +140,26 @@ func (c *Coda) Blacklist(category fn.FnCategory) {
 	c.blacklist = append(c.blacklist, category)
 }
 
+// Sandbox restricts the local paths of file, archive, s3, ai and os actions
+// to the given roots for this run. Calling it without roots denies all paths.
+func (c *Coda) Sandbox(roots ...fn.SandboxRoot) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.sandboxed = true
+	c.sandbox = append(c.sandbox, roots...)
+}
+
+// Strict enables strict variable resolution for this run, regardless of the coda settings
+func (c *Coda) Strict() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.strict = true
+}
+
+func (c *Coda) isStrict() bool {
+	return c.strict || (c.Coda != nil && c.Coda.Strict)
+}
+
 // Create a new Coda instance from a JSON string
 func (c *Coda) FromJson(j string) (*Coda, error) {
 	err := c.validateSchema(j)
@@ -131,6 +173,7 @@ func (c *Coda) FromJson(j string) (*Coda, error) {
 		return nil, err
 	}
 
+	c.compileTemplates()
 	c.debug("initialized new coda instance from json")
 	return c, nil
 }
@@ -148,10 +191,18 @@ func (c *Coda) FromYaml(y string) (*Coda, error) {
 		return nil, err
 	}
 
+	c.compileTemplates()
 	c.debug("initialized new coda instance from yaml")
 	return c, nil
 }
 
+// compileTemplates precompiles the variable expressions of all operations
+func (c *Coda) compileTemplates() {
+	for _, op := range c.Operations {
+		c.templates.compile(op.Params)
+	}
+}
+
 // new creates a new Coda instance with default settings
 func new() *Coda {
 	c := &Coda{
@@ -159,15 +210,18 @@ func new() *Coda {
 			Logs:     false,
 			Stats:    false,
 			Extended: false,
+			Strict:   false,
 		},
 		Logs:       []string{},
 		Store:      make(map[string]json.RawMessage),
 		Operations: make(map[string]Operation),
 
-		Fn:        fns,
+		Fn:        fn.New(VERSION),
 		blacklist: []fn.FnCategory{},
 		mutex:     sync.RWMutex{},
+		templates: newTemplateCache(),
 	}
 	c.Stats = c.newStats()
+	c.Fn.SetExecutor(c.executeAction)
 	return c
 }
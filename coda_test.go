@@ -1,9 +1,24 @@
 package coda
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/tidwall/gjson"
+	"github.com/yosev/coda/pkg/fn"
+
 	_ "embed"
 )
 
@@ -34,3 +49,359 @@ func TestNew(t *testing.T) {
 
 	fmt.Println(string(result))
 }
+
+func TestBlobs(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source.bin")
+	content := bytes.Repeat([]byte{0, 1, 2, 255}, 1024)
+	if err := os.WriteFile(source, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	doc := fmt.Sprintf(`{
+		"coda": {"blobs": {"backend": "file", "dir": %q, "max_size": 8192}},
+		"store": {"source": %q, "destination": %q},
+		"operations": {
+			"read": {"entrypoint": true, "action": "file.read", "params": {"source": "${store.source}", "blob": true}, "store": "blob", "onSuccess": "hash"},
+			"hash": {"action": "hash.sha256", "params": {"value": "${store.blob}"}, "store": "hash", "onSuccess": "write"},
+			"write": {"action": "file.write", "params": {"destination": "${store.destination}", "value": "${store.blob}"}}
+		}
+	}`, dir, source, filepath.Join(dir, "destination.bin"))
+
+	c, err := New().FromJson(doc)
+	if err != nil {
+		t.Fatalf("failed to load coda from JSON: %v", err)
+	}
+	if err := c.Run(); err != nil {
+		t.Fatalf("failed to run coda: %v", err)
+	}
+
+	if ref := gjson.GetBytes(c.Store["blob"], "@this").String(); !fn.IsBlobRef(ref) {
+		t.Errorf("expected a blob reference, got %q", ref)
+	}
+	if got, want := gjson.GetBytes(c.Store["hash"], "@this").String(), fmt.Sprintf("%x", sha256.Sum256(content)); got != want {
+		t.Errorf("hash = %s, want %s", got, want)
+	}
+	written, err := os.ReadFile(filepath.Join(dir, "destination.bin"))
+	if err != nil || !bytes.Equal(written, content) {
+		t.Errorf("written blob does not match the source: %v", err)
+	}
+
+	// the blob directory is removed at the end of the run
+	entries, _ := filepath.Glob(filepath.Join(dir, "coda-blobs-*"))
+	if len(entries) != 0 {
+		t.Errorf("expected blobs to be cleaned up, found %v", entries)
+	}
+}
+
+func TestBlobsSizeLimit(t *testing.T) {
+	store, err := fn.NewBlobStore(fn.BlobOptions{MaxSize: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.Put(strings.NewReader("1234")); err != nil {
+		t.Errorf("expected blob within the limit to be stored: %v", err)
+	}
+	if _, err := store.Put(strings.NewReader("12345")); err == nil {
+		t.Errorf("expected blob exceeding the limit to fail")
+	}
+}
+
+func TestBlobsTotalSizeLimit(t *testing.T) {
+	store, err := fn.NewBlobStore(fn.BlobOptions{MaxTotalSize: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.Put(strings.NewReader("12345678")); err != nil {
+		t.Fatalf("expected blob within the total limit to be stored: %v", err)
+	}
+	// the total is used up exactly, further blobs must not be unlimited
+	for _, content := range []string{"1", ""} {
+		if _, err := store.Put(strings.NewReader(content)); err == nil {
+			t.Errorf("expected blob %q beyond the exhausted total to fail", content)
+		}
+	}
+
+	store, _ = fn.NewBlobStore(fn.BlobOptions{MaxTotalSize: 100})
+	defer store.Close()
+	var wg sync.WaitGroup
+	var stored atomic.Int32
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := store.Put(strings.NewReader("0123456789")); err == nil {
+				stored.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if stored.Load() != 10 {
+		t.Errorf("expected concurrent puts to fill the total exactly, stored %d", stored.Load())
+	}
+}
+
+func TestHttpSessions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer s3cr3t" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"path": %q}`, r.URL.Path)
+	}))
+	defer server.Close()
+
+	doc := fmt.Sprintf(`{
+		"coda": {"http_sessions": {"api": {"base_url": %q, "bearer_token": "${secrets.token}"}}},
+		"secrets": {"token": "s3cr3t"},
+		"operations": {
+			"get": {"entrypoint": true, "action": "http.request", "params": {"url": "/items", "method": "GET", "session": "api"}, "store": "response"}
+		}
+	}`, server.URL)
+
+	c, err := New().FromJson(doc)
+	if err != nil {
+		t.Fatalf("failed to load coda from JSON: %v", err)
+	}
+	if err := c.Run(); err != nil {
+		t.Fatalf("failed to run coda: %v", err)
+	}
+	if got := gjson.GetBytes(c.Store["response"], "body.path").String(); got != "/items" {
+		t.Errorf("expected the session request to reach /items, got %s", c.Store["response"])
+	}
+}
+
+func TestConnections(t *testing.T) {
+	backend := s3mem.New()
+	backend.CreateBucket("backups")
+	s3Server := httptest.NewServer(gofakes3.New(backend).Server())
+	defer s3Server.Close()
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"auth": %q}`, r.Header.Get("Authorization"))
+	}))
+	defer httpServer.Close()
+	file := filepath.Join(t.TempDir(), "file.txt")
+	os.WriteFile(file, []byte("backup"), 0644)
+
+	doc := fmt.Sprintf(`{
+		"coda": {"connections": {
+			"backups": {"type": "s3", "params": {"endpoint": %q, "bucket": "backups", "region": "us-east-1", "key_id": "key", "key_secret": "${secrets.s3_secret}"}},
+			"api": {"type": "http", "params": {"base_url": %q, "bearer_token": "${secrets.token}"}}
+		}},
+		"secrets": {"s3_secret": "secret", "token": "s3cr3t"},
+		"operations": {
+			"upload": {"entrypoint": true, "action": "s3.upload", "params": {"connection": "backups", "local_path": %q, "remote_path": "file.txt"}, "onSuccess": "list"},
+			"list": {"action": "s3.list", "params": {"connection": "backups"}, "store": "objects", "onSuccess": "get"},
+			"get": {"action": "http.request", "params": {"connection": "api", "url": "/", "method": "GET"}, "store": "response", "onSuccess": "misuse"},
+			"misuse": {"action": "http.request", "params": {"connection": "backups", "url": "/", "method": "GET"}}
+		}
+	}`, s3Server.URL, httpServer.URL, file)
+
+	c, err := New().FromJson(doc)
+	if err != nil {
+		t.Fatalf("failed to load coda from JSON: %v", err)
+	}
+	err = c.Run()
+	if err == nil || !strings.Contains(err.Error(), "s3 connection 'backups' cannot be used by http.request") {
+		t.Fatalf("expected the s3 connection to be rejected for http, got %v", err)
+	}
+	if got := gjson.GetBytes(c.Store["objects"], "objects.0.key").String(); got != "file.txt" {
+		t.Errorf("expected the uploaded object to be listed, got %s", c.Store["objects"])
+	}
+	if got := gjson.GetBytes(c.Store["response"], "body.auth").String(); got != "Bearer s3cr3t" {
+		t.Errorf("expected the connection token to be sent, got %s", c.Store["response"])
+	}
+}
+
+func TestGraphqlQuery(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Query     string         `json:"query"`
+			Variables map[string]any `json:"variables"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+		if req.Variables["id"] == "1" {
+			fmt.Fprint(w, `{"data": {"user": {"name": "coda"}}}`)
+			return
+		}
+		fmt.Fprint(w, `{"data": {"user": null}, "errors": [{"message": "user not found", "path": ["user"]}]}`)
+	}))
+	defer server.Close()
+
+	doc := fmt.Sprintf(`{
+		"store": {"endpoint": %q},
+		"operations": {
+			"found": {"entrypoint": true, "action": "graphql.query", "params": {"endpoint": "${store.endpoint}", "query": "query($id: ID!) { user(id: $id) { name } }", "variables": {"id": "1"}}, "store": "found", "onSuccess": "missing"},
+			"missing": {"action": "graphql.query", "params": {"endpoint": "${store.endpoint}", "query": "query($id: ID!) { user(id: $id) { name } }", "variables": {"id": "2"}}, "store": "missing", "onFail": "handle"},
+			"handle": {"action": "string", "params": {"value": "${store.missing.errors.0.message}"}, "store": "error"}
+		}
+	}`, server.URL)
+
+	c, err := New().FromJson(doc)
+	if err != nil {
+		t.Fatalf("failed to load coda from JSON: %v", err)
+	}
+	if err := c.Run(); err != nil {
+		t.Fatalf("failed to run coda: %v", err)
+	}
+	if got := gjson.GetBytes(c.Store["found"], "data.user.name").String(); got != "coda" {
+		t.Errorf("expected the query data to be stored, got %s", c.Store["found"])
+	}
+	if got := gjson.GetBytes(c.Store["error"], "@this").String(); got != "user not found" {
+		t.Errorf("expected the GraphQL errors to reach the fail branch, got %s", c.Store["error"])
+	}
+}
+
+func TestBlacklistHttp(t *testing.T) {
+	for _, action := range []string{"http.request", "graphql.query"} {
+		c, err := New().FromJson(fmt.Sprintf(`{"operations": {"op": {"entrypoint": true, "action": %q, "params": {"url": "http://127.0.0.1:1", "endpoint": "http://127.0.0.1:1", "query": "{ x }"}}}}`, action))
+		if err != nil {
+			t.Fatalf("failed to load coda from JSON: %v", err)
+		}
+		c.Blacklist(fn.FnCategoryHTTP)
+		if err := c.Run(); err == nil || !strings.Contains(err.Error(), "disabled (HTTP)") {
+			t.Errorf("expected %s to be disabled with the HTTP category, got %v", action, err)
+		}
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	dir := t.TempDir()
+	doc := fmt.Sprintf(`{
+		"store": {"inside": %q, "outside": %q},
+		"operations": {
+			"inside": {"entrypoint": true, "action": "file.write", "params": {"destination": "${store.inside}", "value": "ok"}, "onSuccess": "outside"},
+			"outside": {"action": "file.write", "params": {"destination": "${store.outside}", "value": "nope"}}
+		}
+	}`, filepath.Join(dir, "allowed", "file.txt"), filepath.Join(dir, "file.txt"))
+	os.MkdirAll(filepath.Join(dir, "allowed"), 0755)
+
+	c, err := New().FromJson(doc)
+	if err != nil {
+		t.Fatalf("failed to load coda from JSON: %v", err)
+	}
+	c.Sandbox(fn.SandboxRoot{Path: filepath.Join(dir, "allowed"), Writable: true})
+	if err := c.Run(); err == nil || !strings.Contains(err.Error(), "outside of the allowed roots") {
+		t.Fatalf("expected the sandbox to fail the run, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "allowed", "file.txt")); err != nil {
+		t.Errorf("expected the file inside the sandbox to be written: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "file.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected the file outside of the sandbox not to be written")
+	}
+	if c.Stats.OperationsSandboxedTotal != 1 {
+		t.Errorf("expected one sandboxed operation, got %v", c.Stats.OperationsSandboxedTotal)
+	}
+}
+
+func TestAiAgent(t *testing.T) {
+	requests := []gjson.Result{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body json.RawMessage
+		json.NewDecoder(r.Body).Decode(&body)
+		requests = append(requests, gjson.ParseBytes(body))
+		w.Header().Set("Content-Type", "application/json")
+		if len(requests) == 1 {
+			fmt.Fprint(w, `{"choices": [{"index": 0, "finish_reason": "tool_calls", "message": {"role": "assistant", "tool_calls": [
+				{"id": "call_1", "type": "function", "function": {"name": "string_upper", "arguments": "{\"value\": \"coda\"}"}},
+				{"id": "call_2", "type": "function", "function": {"name": "file_read", "arguments": "{\"file\": \"/etc/passwd\"}"}}
+			]}}], "usage": {"prompt_tokens": 10, "completion_tokens": 5, "total_tokens": 15}}`)
+			return
+		}
+		fmt.Fprint(w, `{"choices": [{"index": 0, "finish_reason": "stop", "message": {"role": "assistant", "content": "CODA"}}], "usage": {"prompt_tokens": 20, "completion_tokens": 1, "total_tokens": 21}}`)
+	}))
+	defer server.Close()
+
+	doc := fmt.Sprintf(`{
+		"operations": {
+			"agent": {"entrypoint": true, "action": "ai.agent", "params": {"base_url": %q, "api_key": "key", "model": "stub", "prompt": "Shout coda", "tools": ["string.upper", "file.read"]}, "store": "agent"}
+		}
+	}`, server.URL)
+	c, err := New().FromJson(doc)
+	if err != nil {
+		t.Fatalf("failed to load coda from JSON: %v", err)
+	}
+	c.Blacklist(fn.FnCategoryFile)
+	if err := c.Run(); err != nil {
+		t.Fatalf("failed to run coda: %v", err)
+	}
+
+	result := gjson.ParseBytes(c.Store["agent"])
+	if result.Get("text").String() != "CODA" || result.Get("iterations").Int() != 2 || result.Get("usage.total_tokens").Int() != 36 {
+		t.Errorf("unexpected agent result: %s", result.Raw)
+	}
+	if call := result.Get("tool_calls.0"); call.Get("action").String() != "string.upper" || call.Get("result").String() != "CODA" {
+		t.Errorf("expected the string tool to be executed: %s", call.Raw)
+	}
+	if call := result.Get("tool_calls.1"); !strings.Contains(call.Get("error").String(), "disabled") {
+		t.Errorf("expected the blacklisted tool to fail: %s", call.Raw)
+	}
+	if c.Stats.OperationsBlacklistedTotal != 1 {
+		t.Errorf("expected one blacklisted tool call, got %v", c.Stats.OperationsBlacklistedTotal)
+	}
+	if tools := requests[0].Get("tools.#.function.name").String(); tools != `["file_read","string_upper"]` {
+		t.Errorf("unexpected tools: %s", tools)
+	}
+	if reply := requests[1].Get(`messages.#(tool_call_id=="call_1").content`).String(); reply != `"CODA"` {
+		t.Errorf("expected the tool result to be sent back, got %s", requests[1].Raw)
+	}
+}
+
+func TestAiAgentConnections(t *testing.T) {
+	requests := []gjson.Result{}
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header.Get("Authorization"))
+	}))
+	defer api.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body json.RawMessage
+		json.NewDecoder(r.Body).Decode(&body)
+		requests = append(requests, gjson.ParseBytes(body))
+		w.Header().Set("Content-Type", "application/json")
+		if len(requests) == 1 {
+			fmt.Fprint(w, `{"choices": [{"index": 0, "finish_reason": "tool_calls", "message": {"role": "assistant", "tool_calls": [
+				{"id": "call_1", "type": "function", "function": {"name": "http_request", "arguments": "{\"url\": \"/\", \"method\": \"GET\", \"connection\": \"admin\"}"}},
+				{"id": "call_2", "type": "function", "function": {"name": "http_request", "arguments": "{\"url\": \"/\", \"method\": \"GET\", \"session\": \"admin\"}"}},
+				{"id": "call_3", "type": "function", "function": {"name": "http_request", "arguments": "{\"url\": \"/\", \"method\": \"GET\", \"connection\": \"public\"}"}}
+			]}}], "usage": {"prompt_tokens": 10, "completion_tokens": 5, "total_tokens": 15}}`)
+			return
+		}
+		fmt.Fprint(w, `{"choices": [{"index": 0, "finish_reason": "stop", "message": {"role": "assistant", "content": "done"}}], "usage": {"prompt_tokens": 20, "completion_tokens": 1, "total_tokens": 21}}`)
+	}))
+	defer server.Close()
+
+	doc := fmt.Sprintf(`{
+		"coda": {"connections": {
+			"admin": {"type": "http", "params": {"base_url": %q, "bearer_token": "admin"}},
+			"public": {"type": "http", "params": {"base_url": %q, "bearer_token": "public"}}
+		}},
+		"operations": {
+			"agent": {"entrypoint": true, "action": "ai.agent", "params": {"base_url": %q, "api_key": "key", "model": "stub", "prompt": "Call the api", "tools": ["http.request"], "connections": ["public"]}, "store": "agent"}
+		}
+	}`, api.URL, api.URL, server.URL)
+	c, err := New().FromJson(doc)
+	if err != nil {
+		t.Fatalf("failed to load coda from JSON: %v", err)
+	}
+	if err := c.Run(); err != nil {
+		t.Fatalf("failed to run coda: %v", err)
+	}
+
+	result := gjson.ParseBytes(c.Store["agent"])
+	for i, want := range []string{"connection 'admin' is not available to the agent", "session 'admin' is not available to the agent"} {
+		if got := result.Get(fmt.Sprintf("tool_calls.%d.error", i)).String(); got != want {
+			t.Errorf("tool call %d: expected error %q, got %s", i, want, result.Get(fmt.Sprintf("tool_calls.%d", i)).Raw)
+		}
+	}
+	if got := result.Get("tool_calls.2.result.body").String(); got != "Bearer public" {
+		t.Errorf("expected the listed connection to be used, got %s", result.Get("tool_calls.2").Raw)
+	}
+}
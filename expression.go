@@ -0,0 +1,678 @@
+package coda
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// expression is a single parsed `${...}` placeholder: an expression tree
+// followed by an optional chain of legacy pipe filters.
+type expression struct {
+	src     string
+	root    exprNode
+	filters []Filter
+}
+
+// exprNode is a node of the expression tree
+type exprNode interface {
+	eval(r *resolver) (any, error)
+}
+
+type literalNode struct {
+	value any
+}
+
+type pathNode struct {
+	path string
+}
+
+type unaryNode struct {
+	op      string
+	operand exprNode
+}
+
+type binaryNode struct {
+	op          string
+	left, right exprNode
+}
+
+type ternaryNode struct {
+	cond, then, otherwise exprNode
+}
+
+type callNode struct {
+	def  *FilterDefinition
+	args []exprNode
+}
+
+// parseExpression parses the body of a `${...}` placeholder
+func parseExpression(src string) (*expression, error) {
+	segments, err := splitPipes(src)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := tokenize(segments[0])
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %s at position %d", tok, tok.pos)
+	}
+
+	return &expression{
+		src:     src,
+		root:    root,
+		filters: parseFilters(segments[1:]),
+	}, nil
+}
+
+// evaluate the expression using the given resolver
+func (e *expression) evaluate(r *resolver) (any, error) {
+	val, err := e.root.eval(r)
+	if err != nil {
+		return nil, err
+	}
+	if path, ok := e.root.(*pathNode); ok && val == nil {
+		if _, exists := r.lookup(path.path); !exists {
+			val = "" // missing paths have always resolved to an empty string
+		}
+	}
+	for _, filter := range e.filters {
+		if val, err = r.applyFilter(val, filter); err != nil {
+			return nil, err
+		}
+	}
+	return val, nil
+}
+
+// splitPipes splits the placeholder body on top level pipes, ignoring `||`
+// as well as pipes inside quotes or parentheses.
+func splitPipes(src string) ([]string, error) {
+	var segments []string
+	var quote byte
+	depth, start := 0, 0
+	for i := 0; i < len(src); i++ {
+		ch := src[i]
+		switch {
+		case quote != 0:
+			if ch == '\\' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+		case ch == '"' || ch == '\'' || ch == '`':
+			quote = ch
+		case ch == '(':
+			depth++
+		case ch == ')':
+			depth--
+		case ch == '|' && depth == 0:
+			if i+1 < len(src) && src[i+1] == '|' && len(segments) == 0 {
+				i++
+				continue
+			}
+			segments = append(segments, src[start:i])
+			start = i + 1
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated string literal")
+	}
+	segments = append(segments, src[start:])
+	if strings.TrimSpace(segments[0]) == "" {
+		return nil, fmt.Errorf("empty expression")
+	}
+	return segments, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokPath // a backtick quoted gjson path
+	tokOperator
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value any
+	pos   int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+var operators = []string{"??", "&&", "||", "==", "!=", "<=", ">=", "<", ">", "+", "-", "*", "/", "%", "!", "?", ":", "(", ")", ","}
+
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(src) {
+		ch := src[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			i++
+		case isDigit(ch):
+			start := i
+			for i < len(src) && (isDigit(src[i]) || src[i] == '.') {
+				i++
+			}
+			n, err := strconv.ParseFloat(src[start:i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at position %d", src[start:i], start)
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: src[start:i], value: n, pos: start})
+		case ch == '"' || ch == '\'':
+			start := i
+			var sb strings.Builder
+			i++
+			for ; i < len(src) && src[i] != ch; i++ {
+				if src[i] == '\\' && i+1 < len(src) {
+					i++
+				}
+				sb.WriteByte(src[i])
+			}
+			if i >= len(src) {
+				return nil, fmt.Errorf("unterminated string literal at position %d", start)
+			}
+			i++
+			tokens = append(tokens, token{kind: tokString, text: src[start:i], value: sb.String(), pos: start})
+		case ch == '`':
+			start := i
+			for i++; i < len(src) && src[i] != '`'; i++ {
+				if src[i] == '\\' {
+					i++ // gjson escapes are kept as they are
+				}
+			}
+			if i >= len(src) {
+				return nil, fmt.Errorf("unterminated path at position %d", start)
+			}
+			i++
+			if i-start == 2 {
+				return nil, fmt.Errorf("empty path at position %d", start)
+			}
+			tokens = append(tokens, token{kind: tokPath, text: src[start:i], value: src[start+1 : i-1], pos: start})
+		case isIdentStart(ch):
+			start := i
+			end, err := scanPath(src, i)
+			if err != nil {
+				return nil, err
+			}
+			i = end
+			tokens = append(tokens, token{kind: tokIdent, text: src[start:i], pos: start})
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(src[i:], op) {
+					tokens = append(tokens, token{kind: tokOperator, text: op, pos: i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at position %d", ch, i)
+			}
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(src)}), nil
+}
+
+// scanPath consumes a gjson path such as `store.items.#(name=="x").id`.
+// Unquoted paths consist of letters, digits, `_`, `.`, `@`, `#`, `#(...)`
+// queries and backslash escapes. A `-` without whitespace around it belongs
+// to the path, so `store.item-1` is a path while `store.count - 1` is a
+// subtraction. Paths using other characters, such as `*` and `?` wildcards
+// or keys with spaces, are quoted in backticks, e.g. ${`store.items.?a`}.
+func scanPath(src string, i int) (int, error) {
+	for i < len(src) {
+		ch := src[i]
+		switch {
+		case isIdentStart(ch) || isDigit(ch) || ch == '.' || ch == '@':
+			i++
+		case ch == '\\' && i+1 < len(src):
+			i += 2
+		case ch == '-' && i+1 < len(src) && (isIdentStart(src[i+1]) || isDigit(src[i+1])):
+			i++
+		case ch == '#':
+			i++
+			if i < len(src) && src[i] == '(' {
+				depth := 0
+				start := i
+				for ; i < len(src); i++ {
+					if src[i] == '(' {
+						depth++
+					} else if src[i] == ')' {
+						depth--
+						if depth == 0 {
+							break
+						}
+					}
+				}
+				if depth != 0 {
+					return 0, fmt.Errorf("unterminated path query at position %d", start)
+				}
+				i++
+			}
+		default:
+			return i, nil
+		}
+	}
+	return i, nil
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+func isIdentStart(ch byte) bool {
+	return ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) accept(ops ...string) (string, bool) {
+	tok := p.peek()
+	if tok.kind != tokOperator {
+		return "", false
+	}
+	for _, op := range ops {
+		if tok.text == op {
+			p.pos++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) expect(op string) error {
+	if _, ok := p.accept(op); !ok {
+		tok := p.peek()
+		return fmt.Errorf("expected %q but found %s at position %d", op, tok, tok.pos)
+	}
+	return nil
+}
+
+func (p *parser) parseTernary() (exprNode, error) {
+	cond, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := p.accept("?"); !ok {
+		return cond, nil
+	}
+	then, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	otherwise, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	return &ternaryNode{cond: cond, then: then, otherwise: otherwise}, nil
+}
+
+// binary operators ordered by precedence, lowest first
+var precedence = [][]string{
+	{"??"},
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *parser) parseBinary(level int) (exprNode, error) {
+	if level == len(precedence) {
+		return p.parseUnary()
+	}
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept(precedence[level]...)
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) parseUnary() (exprNode, error) {
+	if op, ok := p.accept("!", "-"); ok {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: op, operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (exprNode, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokNumber, tokString:
+		return &literalNode{value: tok.value}, nil
+	case tokPath:
+		return &pathNode{path: tok.value.(string)}, nil
+	case tokIdent:
+		switch tok.text {
+		case "true":
+			return &literalNode{value: true}, nil
+		case "false":
+			return &literalNode{value: false}, nil
+		case "null":
+			return &literalNode{value: nil}, nil
+		}
+		if _, ok := p.accept("("); ok {
+			return p.parseCall(tok)
+		}
+		return &pathNode{path: tok.text}, nil
+	case tokOperator:
+		if tok.text == "(" {
+			node, err := p.parseTernary()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return node, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected %s at position %d", tok, tok.pos)
+}
+
+func (p *parser) parseCall(name token) (exprNode, error) {
+	def, ok := lookupFilter(name.text)
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q at position %d", name.text, name.pos)
+	}
+	call := &callNode{def: def}
+	if _, ok := p.accept(")"); ok {
+		return nil, fmt.Errorf("function %q requires at least one argument", def.Name)
+	}
+	for {
+		arg, err := p.parseTernary()
+		if err != nil {
+			return nil, err
+		}
+		call.args = append(call.args, arg)
+		if _, ok := p.accept(","); !ok {
+			break
+		}
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	return call, nil
+}
+
+func (n *literalNode) eval(r *resolver) (any, error) {
+	return n.value, nil
+}
+
+func (n *pathNode) eval(r *resolver) (any, error) {
+	val, ok := r.lookup(n.path)
+	if !ok && r.strict {
+		return nil, fmt.Errorf("path '%s' does not exist", n.path)
+	}
+	return val, nil
+}
+
+func (n *unaryNode) eval(r *resolver) (any, error) {
+	val, err := n.operand.eval(r)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "!" {
+		return !truthy(val), nil
+	}
+	f, ok := val.(float64)
+	if !ok {
+		return nil, fmt.Errorf("cannot negate %s", typeName(val))
+	}
+	return -f, nil
+}
+
+func (n *ternaryNode) eval(r *resolver) (any, error) {
+	cond, err := n.cond.eval(r)
+	if err != nil {
+		return nil, err
+	}
+	if truthy(cond) {
+		return n.then.eval(r)
+	}
+	return n.otherwise.eval(r)
+}
+
+func (n *callNode) eval(r *resolver) (any, error) {
+	val, err := n.args[0].eval(r)
+	if err != nil {
+		return nil, err
+	}
+	args := make([]any, 0, len(n.args)-1)
+	for _, node := range n.args[1:] {
+		arg, err := node.eval(r)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return r.callFilter(n.def, val, args)
+}
+
+func (n *binaryNode) eval(r *resolver) (any, error) {
+	// missing paths are allowed on the left of `??`, even in strict mode
+	if path, ok := n.left.(*pathNode); ok && n.op == "??" {
+		if val, ok := r.lookup(path.path); ok && val != nil {
+			return val, nil
+		}
+		return n.right.eval(r)
+	}
+
+	left, err := n.left.eval(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// short-circuiting operators
+	switch n.op {
+	case "??":
+		if left != nil {
+			return left, nil
+		}
+		return n.right.eval(r)
+	case "&&":
+		if !truthy(left) {
+			return false, nil
+		}
+		right, err := n.right.eval(r)
+		if err != nil {
+			return nil, err
+		}
+		return truthy(right), nil
+	case "||":
+		if truthy(left) {
+			return true, nil
+		}
+		right, err := n.right.eval(r)
+		if err != nil {
+			return nil, err
+		}
+		return truthy(right), nil
+	}
+
+	right, err := n.right.eval(r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return reflect.DeepEqual(left, right), nil
+	case "!=":
+		return !reflect.DeepEqual(left, right), nil
+	case "<", "<=", ">", ">=":
+		cmp, err := compareValues(left, right)
+		if err != nil {
+			return nil, err
+		}
+		switch n.op {
+		case "<":
+			return cmp < 0, nil
+		case "<=":
+			return cmp <= 0, nil
+		case ">":
+			return cmp > 0, nil
+		default:
+			return cmp >= 0, nil
+		}
+	case "+":
+		_, ls := left.(string)
+		_, rs := right.(string)
+		if ls || rs {
+			return stringify(left) + stringify(right), nil
+		}
+	}
+
+	return arithmetic(n.op, left, right)
+}
+
+func arithmetic(op string, left, right any) (any, error) {
+	l, lok := left.(float64)
+	r, rok := right.(float64)
+	if !lok || !rok {
+		return nil, fmt.Errorf("operator %q is not defined for %s and %s", op, typeName(left), typeName(right))
+	}
+	switch op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		if r == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return l / r, nil
+	default:
+		if r == 0 {
+			return nil, fmt.Errorf("modulo by zero")
+		}
+		return math.Mod(l, r), nil
+	}
+}
+
+func compareValues(left, right any) (int, error) {
+	switch l := left.(type) {
+	case float64:
+		if r, ok := right.(float64); ok {
+			switch {
+			case l < r:
+				return -1, nil
+			case l > r:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	case string:
+		if r, ok := right.(string); ok {
+			return strings.Compare(l, r), nil
+		}
+	}
+	return 0, fmt.Errorf("cannot compare %s with %s", typeName(left), typeName(right))
+}
+
+// truthy reports whether a value counts as true in a boolean context
+func truthy(val any) bool {
+	switch v := val.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	case []any:
+		return len(v) > 0
+	case map[string]any:
+		return len(v) > 0
+	}
+	return true
+}
+
+// stringify converts a value for interpolation into a larger string
+func stringify(val any) string {
+	switch v := val.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprintf("%v", val)
+}
+
+func typeName(val any) string {
+	switch val.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", val)
+}
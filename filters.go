@@ -0,0 +1,538 @@
+package coda
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// FilterHandler transforms a value using the already parsed and typed arguments
+type FilterHandler func(val any, args []any) (any, error)
+
+// FilterArgument describes a single argument of a filter
+type FilterArgument struct {
+	Name        string `json:"name" yaml:"name"`
+	Description string `json:"description" yaml:"description"`
+	Mandatory   bool   `json:"mandatory" yaml:"mandatory"`
+	Type        string `json:"type" yaml:"type"` // string, integer, number, boolean or any
+	Default     any    `json:"default,omitempty" yaml:"default,omitempty"`
+}
+
+// FilterDefinition describes a filter usable as `${value | name:arg1:arg2}` or `${name(value, arg1, arg2)}`
+type FilterDefinition struct {
+	Name        string           `json:"name" yaml:"name"`
+	Description string           `json:"description" yaml:"description"`
+	Arguments   []FilterArgument `json:"arguments,omitempty" yaml:"arguments,omitempty"`
+	Handler     FilterHandler    `json:"-" yaml:"-"`
+}
+
+type filterRegistry struct {
+	mutex sync.RWMutex
+	defs  map[string]*FilterDefinition
+}
+
+var filters = &filterRegistry{defs: make(map[string]*FilterDefinition)}
+
+// RegisterFilter makes a filter available to all coda instances
+func RegisterFilter(def FilterDefinition) error {
+	if def.Name == "" || def.Handler == nil {
+		return fmt.Errorf("filter requires a name and a handler")
+	}
+	for _, arg := range def.Arguments {
+		switch arg.Type {
+		case "", "string", "integer", "number", "boolean", "any":
+		default:
+			return fmt.Errorf("filter '%s': unsupported type '%s' for argument '%s'", def.Name, arg.Type, arg.Name)
+		}
+	}
+
+	filters.mutex.Lock()
+	if _, exists := filters.defs[def.Name]; exists {
+		filters.mutex.Unlock()
+		return fmt.Errorf("filter already registered: %s", def.Name)
+	}
+	filters.defs[def.Name] = &def
+	filters.mutex.Unlock()
+
+	// regenerate the schema to include the new filter. The schema lock is
+	// taken after releasing the filters, as the schema generation reads them.
+	resetSchema()
+	return nil
+}
+
+// Filters returns all registered filters sorted by name
+func Filters() []FilterDefinition {
+	filters.mutex.RLock()
+	defer filters.mutex.RUnlock()
+	out := make([]FilterDefinition, 0, len(filters.defs))
+	for _, def := range filters.defs {
+		out = append(out, *def)
+	}
+	slices.SortFunc(out, func(a, b FilterDefinition) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return out
+}
+
+func lookupFilter(name string) (*FilterDefinition, bool) {
+	filters.mutex.RLock()
+	defer filters.mutex.RUnlock()
+	def, ok := filters.defs[name]
+	return def, ok
+}
+
+func mustRegisterFilter(def FilterDefinition) {
+	if err := RegisterFilter(def); err != nil {
+		panic(err)
+	}
+}
+
+// call converts the arguments according to the definition and runs the handler
+func (def *FilterDefinition) call(val any, args []any) (any, error) {
+	if len(args) > len(def.Arguments) {
+		return nil, fmt.Errorf("expected at most %d arguments, got %d", len(def.Arguments), len(args))
+	}
+	typed := make([]any, len(def.Arguments))
+	for i, arg := range def.Arguments {
+		// empty optional arguments fall back to their default
+		if i >= len(args) || (args[i] == "" && !arg.Mandatory) {
+			if arg.Mandatory {
+				return nil, fmt.Errorf("missing argument '%s'", arg.Name)
+			}
+			typed[i] = arg.Default
+			continue
+		}
+		v, err := convertFilterArg(args[i], arg.Type)
+		if err != nil {
+			return nil, fmt.Errorf("argument '%s': %w", arg.Name, err)
+		}
+		typed[i] = v
+	}
+	return def.Handler(val, typed)
+}
+
+func convertFilterArg(val any, typ string) (any, error) {
+	switch typ {
+	case "", "string":
+		return stringify(val), nil
+	case "integer":
+		switch v := val.(type) {
+		case float64:
+			if v == float64(int(v)) {
+				return int(v), nil
+			}
+		case string:
+			if i, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+				return i, nil
+			}
+		}
+		return nil, fmt.Errorf("expected integer but got '%v'", val)
+	case "number":
+		switch v := val.(type) {
+		case float64:
+			return v, nil
+		case string:
+			if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				return f, nil
+			}
+		}
+		return nil, fmt.Errorf("expected number but got '%v'", val)
+	case "boolean":
+		switch v := val.(type) {
+		case bool:
+			return v, nil
+		case string:
+			if b, err := strconv.ParseBool(strings.TrimSpace(v)); err == nil {
+				return b, nil
+			}
+		}
+		return nil, fmt.Errorf("expected boolean but got '%v'", val)
+	}
+	return val, nil
+}
+
+// splitFilterArgs splits the raw argument string of a pipe filter on `:`,
+// honouring quoted arguments. The last expected argument receives the
+// remainder of the string, so `join::` joins on ":".
+func splitFilterArgs(raw string, n int) ([]any, error) {
+	var args []any
+	if raw == "" || n == 0 {
+		if raw != "" {
+			return nil, fmt.Errorf("expected no arguments, got '%s'", raw)
+		}
+		return args, nil
+	}
+	for {
+		if len(args) == n-1 {
+			if s, rest, ok := unquote(raw); ok && rest == "" {
+				return append(args, s), nil
+			}
+			return append(args, raw), nil
+		}
+		if s, rest, ok := unquote(raw); ok {
+			args = append(args, s)
+			if rest == "" {
+				return args, nil
+			}
+			if rest[0] != ':' {
+				return nil, fmt.Errorf("unexpected '%s' after quoted argument", rest)
+			}
+			raw = rest[1:]
+			continue
+		} else if raw != "" && (raw[0] == '"' || raw[0] == '\'') {
+			return nil, fmt.Errorf("unterminated quoted argument: %s", raw)
+		}
+		idx := strings.IndexByte(raw, ':')
+		if idx < 0 {
+			return append(args, raw), nil
+		}
+		args = append(args, raw[:idx])
+		raw = raw[idx+1:]
+	}
+}
+
+// unquote reads a leading quoted string, returning its content and the remainder
+func unquote(raw string) (string, string, bool) {
+	if raw == "" || (raw[0] != '"' && raw[0] != '\'') {
+		return "", "", false
+	}
+	quote := raw[0]
+	var sb strings.Builder
+	for i := 1; i < len(raw); i++ {
+		switch raw[i] {
+		case '\\':
+			if i+1 < len(raw) {
+				i++
+				sb.WriteByte(raw[i])
+			}
+		case quote:
+			return sb.String(), raw[i+1:], true
+		default:
+			sb.WriteByte(raw[i])
+		}
+	}
+	return "", "", false
+}
+
+func errUnsupportedType(val any, expected string) error {
+	return fmt.Errorf("expected %s but got %s", expected, typeName(val))
+}
+
+// stringFilter wraps a string transformation into a filter handler
+func stringFilter(transform func(s string) (any, error)) FilterHandler {
+	return func(val any, args []any) (any, error) {
+		s, ok := val.(string)
+		if !ok {
+			return nil, errUnsupportedType(val, "string")
+		}
+		return transform(s)
+	}
+}
+
+func init() {
+	mustRegisterFilter(FilterDefinition{
+		Name:        "string",
+		Description: "Converts the value to its string representation",
+		Handler: func(val any, args []any) (any, error) {
+			return fmt.Sprintf("%v", val), nil
+		},
+	})
+	mustRegisterFilter(FilterDefinition{
+		Name:        "substring",
+		Description: "Returns the part of a string or array between start and end",
+		Arguments: []FilterArgument{
+			{Name: "start", Description: "The start index", Type: "integer", Mandatory: true},
+			{Name: "end", Description: "The end index (exclusive), defaults to the length", Type: "integer", Default: -1},
+		},
+		Handler: func(val any, args []any) (any, error) {
+			start, end := args[0].(int), args[1].(int)
+			bounds := func(length int) (int, int) {
+				if start < 0 {
+					start = 0
+				}
+				if start > length {
+					start = length
+				}
+				if end == -1 || end > length {
+					end = length
+				}
+				if end < start {
+					end = start
+				}
+				return start, end
+			}
+			switch v := val.(type) {
+			case string:
+				s, e := bounds(len(v))
+				return v[s:e], nil
+			case []any:
+				s, e := bounds(len(v))
+				return v[s:e], nil
+			}
+			return nil, errUnsupportedType(val, "string or array")
+		},
+	})
+	mustRegisterFilter(FilterDefinition{
+		Name:        "join",
+		Description: "Joins the elements of an array into a string",
+		Arguments: []FilterArgument{
+			{Name: "delimiter", Description: "The delimiter to join with", Type: "string", Default: ","},
+		},
+		Handler: func(val any, args []any) (any, error) {
+			// Use reflection to support slices of any type
+			rVal := reflect.ValueOf(val)
+			if rVal.Kind() != reflect.Slice {
+				return nil, errUnsupportedType(val, "array")
+			}
+			parts := make([]string, rVal.Len())
+			for i := 0; i < rVal.Len(); i++ {
+				parts[i] = fmt.Sprintf("%v", rVal.Index(i).Interface())
+			}
+			return strings.Join(parts, args[0].(string)), nil
+		},
+	})
+	mustRegisterFilter(FilterDefinition{
+		Name:        "replace",
+		Description: "Replaces all occurrences of a substring in a string or array of strings",
+		Arguments: []FilterArgument{
+			{Name: "old", Description: "The substring to replace", Type: "string", Mandatory: true},
+			{Name: "new", Description: "The replacement", Type: "string", Default: ""},
+		},
+		Handler: func(val any, args []any) (any, error) {
+			old, new := args[0].(string), args[1].(string)
+			switch v := val.(type) {
+			case string:
+				return strings.ReplaceAll(v, old, new), nil
+			case []any:
+				for i, item := range v {
+					if strItem, ok := item.(string); ok {
+						v[i] = strings.ReplaceAll(strItem, old, new)
+					}
+				}
+				return v, nil
+			}
+			return nil, errUnsupportedType(val, "string or array")
+		},
+	})
+	mustRegisterFilter(FilterDefinition{
+		Name:        "upper",
+		Description: "Converts a string to upper case",
+		Handler: stringFilter(func(s string) (any, error) {
+			return strings.ToUpper(s), nil
+		}),
+	})
+	mustRegisterFilter(FilterDefinition{
+		Name:        "lower",
+		Description: "Converts a string to lower case",
+		Handler: stringFilter(func(s string) (any, error) {
+			return strings.ToLower(s), nil
+		}),
+	})
+	mustRegisterFilter(FilterDefinition{
+		Name:        "trim",
+		Description: "Removes leading and trailing whitespace",
+		Handler: stringFilter(func(s string) (any, error) {
+			return strings.TrimSpace(s), nil
+		}),
+	})
+	mustRegisterFilter(FilterDefinition{
+		Name:        "split",
+		Description: "Splits a string into an array",
+		Arguments: []FilterArgument{
+			{Name: "delimiter", Description: "The delimiter to split on", Type: "string", Default: "."},
+		},
+		Handler: func(val any, args []any) (any, error) {
+			s, ok := val.(string)
+			if !ok {
+				return nil, errUnsupportedType(val, "string")
+			}
+			return strings.Split(s, args[0].(string)), nil
+		},
+	})
+	mustRegisterFilter(FilterDefinition{
+		Name:        "md5",
+		Description: "Calculates the MD5 hash of a string",
+		Handler: stringFilter(func(s string) (any, error) {
+			return fmt.Sprintf("%x", md5.Sum([]byte(s))), nil
+		}),
+	})
+	mustRegisterFilter(FilterDefinition{
+		Name:        "sha1",
+		Description: "Calculates the SHA1 hash of a string",
+		Handler: stringFilter(func(s string) (any, error) {
+			return fmt.Sprintf("%x", sha1.Sum([]byte(s))), nil
+		}),
+	})
+	mustRegisterFilter(FilterDefinition{
+		Name:        "sha256",
+		Description: "Calculates the SHA256 hash of a string",
+		Handler: stringFilter(func(s string) (any, error) {
+			return fmt.Sprintf("%x", sha256.Sum256([]byte(s))), nil
+		}),
+	})
+	mustRegisterFilter(FilterDefinition{
+		Name:        "sha512",
+		Description: "Calculates the SHA512 hash of a string",
+		Handler: stringFilter(func(s string) (any, error) {
+			return fmt.Sprintf("%x", sha512.Sum512([]byte(s))), nil
+		}),
+	})
+	mustRegisterFilter(FilterDefinition{
+		Name:        "jsonDecode",
+		Description: "Decodes a JSON object string",
+		Handler: stringFilter(func(s string) (any, error) {
+			var b = make(map[string]interface{})
+			if err := json.Unmarshal([]byte(s), &b); err != nil {
+				return nil, fmt.Errorf("invalid JSON: %v", err)
+			}
+			return b, nil
+		}),
+	})
+	mustRegisterFilter(FilterDefinition{
+		Name:        "jsonEncode",
+		Description: "Encodes the value as JSON string",
+		Handler: func(val any, args []any) (any, error) {
+			b, err := json.Marshal(val)
+			if err != nil {
+				return nil, err
+			}
+			return string(b), nil
+		},
+	})
+	mustRegisterFilter(FilterDefinition{
+		Name:        "base64Decode",
+		Description: "Decodes a base64 string",
+		Handler: stringFilter(func(s string) (any, error) {
+			b, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid base64: %v", err)
+			}
+			return string(b), nil
+		}),
+	})
+	mustRegisterFilter(FilterDefinition{
+		Name:        "base64DecodeAsByteArray",
+		Description: "Decodes a base64 string into a byte array",
+		Handler: stringFilter(func(s string) (any, error) {
+			b, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid base64: %v", err)
+			}
+			return b, nil
+		}),
+	})
+	mustRegisterFilter(FilterDefinition{
+		Name:        "base64Encode",
+		Description: "Encodes a string as base64",
+		Handler: stringFilter(func(s string) (any, error) {
+			return base64.StdEncoding.EncodeToString([]byte(s)), nil
+		}),
+	})
+	mustRegisterFilter(FilterDefinition{
+		Name:        "urlEncode",
+		Description: "Escapes a string for use in a URL query",
+		Handler: stringFilter(func(s string) (any, error) {
+			return url.QueryEscape(s), nil
+		}),
+	})
+	mustRegisterFilter(FilterDefinition{
+		Name:        "urlDecode",
+		Description: "Unescapes a URL query encoded string",
+		Handler: stringFilter(func(s string) (any, error) {
+			return url.QueryUnescape(s)
+		}),
+	})
+	mustRegisterFilter(FilterDefinition{
+		Name:        "default",
+		Description: "Returns the fallback if the value is null or an empty string",
+		Arguments: []FilterArgument{
+			{Name: "fallback", Description: "The fallback value", Type: "any", Mandatory: true},
+		},
+		Handler: func(val any, args []any) (any, error) {
+			if val == nil || val == "" {
+				return args[0], nil
+			}
+			return val, nil
+		},
+	})
+	mustRegisterFilter(FilterDefinition{
+		Name:        "length",
+		Description: "Returns the number of characters of a string or elements of an array or object",
+		Handler: func(val any, args []any) (any, error) {
+			switch v := val.(type) {
+			case string:
+				return float64(utf8.RuneCountInString(v)), nil
+			case []any:
+				return float64(len(v)), nil
+			case map[string]any:
+				return float64(len(v)), nil
+			}
+			return nil, errUnsupportedType(val, "string, array or object")
+		},
+	})
+	mustRegisterFilter(FilterDefinition{
+		Name:        "keys",
+		Description: "Returns the sorted keys of an object",
+		Handler: func(val any, args []any) (any, error) {
+			m, ok := val.(map[string]any)
+			if !ok {
+				return nil, errUnsupportedType(val, "object")
+			}
+			keys := make([]any, 0, len(m))
+			for k := range m {
+				keys = append(keys, k)
+			}
+			slices.SortFunc(keys, func(a, b any) int {
+				return strings.Compare(a.(string), b.(string))
+			})
+			return keys, nil
+		},
+	})
+	mustRegisterFilter(FilterDefinition{
+		Name:        "toNumber",
+		Description: "Parses a string into a number",
+		Handler: func(val any, args []any) (any, error) {
+			return convertFilterArg(val, "number")
+		},
+	})
+	mustRegisterFilter(FilterDefinition{
+		Name:        "date",
+		Description: "Formats a unix timestamp in seconds or an RFC3339 string using a Go time layout",
+		Arguments: []FilterArgument{
+			{Name: "layout", Description: "The Go time layout (e.g., '2006-01-02')", Type: "string", Default: time.RFC3339},
+			{Name: "timezone", Description: "The IANA time zone, defaults to UTC", Type: "string", Default: "UTC"},
+		},
+		Handler: func(val any, args []any) (any, error) {
+			var t time.Time
+			switch v := val.(type) {
+			case float64:
+				t = time.Unix(0, int64(v*float64(time.Second)))
+			case string:
+				parsed, err := time.Parse(time.RFC3339, v)
+				if err != nil {
+					return nil, fmt.Errorf("invalid RFC3339 date: %v", err)
+				}
+				t = parsed
+			default:
+				return nil, errUnsupportedType(val, "number or string")
+			}
+			loc, err := time.LoadLocation(args[1].(string))
+			if err != nil {
+				return nil, err
+			}
+			return t.In(loc).Format(args[0].(string)), nil
+		},
+	})
+}
@@ -1,102 +1,491 @@
 package fn
 
 import (
+	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"slices"
+	"strings"
 
 	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/llms/anthropic"
+	"github.com/tmc/langchaingo/llms/ollama"
 	"github.com/tmc/langchaingo/llms/openai"
+	"github.com/xeipuuv/gojsonschema"
 	"github.com/yosev/coda/internal/utils"
 )
 
 type fnAi struct {
+	*Fn
 	category FnCategory
 }
 
+// aiModelParameters select the provider and model of ai actions
+var aiModelParameters = []FnParameter{
+	connectionParameter,
+	{Name: "provider", Description: "The provider of the model (default openai, which includes OpenAI compatible APIs)", Enum: []string{"openai", "anthropic", "ollama"}, Mandatory: false},
+	{Name: "model", Description: "The model to use (required unless provided by the connection)", Mandatory: false},
+	{Name: "api_key", Description: "The key to use, required by openai and anthropic", Mandatory: false},
+	{Name: "base_url", Description: "The url of the API, e.g. of an OpenAI compatible server", Mandatory: false},
+}
+
+// aiStructuredParameters request a JSON reply, which replaces the text result
+var aiStructuredParameters = []FnParameter{
+	{Name: "json", Description: "If true, the model replies with JSON which is returned parsed", Type: "boolean", Mandatory: false},
+	{Name: "schema", Description: "The JSON Schema the reply must match, implies json", Type: "object", Mandatory: false},
+	{Name: "retries", Description: "The number of retries if the reply is no valid JSON or does not match the schema (default 2)", Type: "integer", Mandatory: false},
+}
+
 func (f *fnAi) init(fn *Fn) {
+	f.Fn = fn
+
 	fn.register("ai.openai", &FnEntry{
 		Handler:     f.openAI,
 		Name:        "OpenAI",
 		Description: "Performs an AI request",
 		Category:    f.category,
-		Parameters: []FnParameter{
+		Parameters: append([]FnParameter{
+			connectionParameter,
 			{Name: "prompt", Description: "The actual prompt", Mandatory: true},
-			{Name: "model", Description: "The modal to use", Mandatory: true},
-			{Name: "api_key", Description: "The key to use", Mandatory: true},
+			{Name: "model", Description: "The modal to use (required unless provided by the connection)", Mandatory: false},
+			{Name: "api_key", Description: "The key to use (required unless provided by the connection)", Mandatory: false},
+			{Name: "base_url", Description: "The url of an OpenAI compatible API", Mandatory: false},
 			{Name: "system", Description: "The system query", Mandatory: false},
 			{Name: "attachments", Description: "The attachments to include", Type: "array", Mandatory: false},
-		},
+		}, aiStructuredParameters...),
+	})
+
+	fn.register("ai.chat", &FnEntry{
+		Handler:     f.chat,
+		Name:        "AI Chat",
+		Description: "Generates a reply of the model as {text, stop_reason, usage: {prompt_tokens, completion_tokens, total_tokens}}, or the parsed JSON reply if json or schema is set",
+		Category:    f.category,
+		Parameters: append(append(slices.Clone(aiModelParameters),
+			FnParameter{Name: "prompt", Description: "The user prompt appended to the messages", Mandatory: false},
+			FnParameter{Name: "system", Description: "The system prompt", Mandatory: false},
+			FnParameter{Name: "messages", Description: "Previous messages as [{role, content}] with the roles system, user and assistant", Type: "array", Mandatory: false},
+			FnParameter{Name: "attachments", Description: "Urls, files or blob references attached to the prompt", Type: "array", Mandatory: false},
+			FnParameter{Name: "temperature", Description: "The sampling temperature", Type: "number", Mandatory: false},
+			FnParameter{Name: "max_tokens", Description: "The maximum number of tokens to generate", Type: "integer", Mandatory: false},
+			FnParameter{Name: "stop", Description: "Sequences stopping the generation", Type: "array", Mandatory: false},
+		), aiStructuredParameters...),
+	})
+
+	fn.register("ai.embed", &FnEntry{
+		Handler:     f.embed,
+		Name:        "AI Embeddings",
+		Description: "Converts a text to a vector, or an array of texts to an array of vectors",
+		Category:    f.category,
+		Parameters: append(slices.Clone(aiModelParameters),
+			FnParameter{Name: "input", Description: "The text or array of texts to embed", Type: "string,array", Mandatory: true},
+		),
 	})
+
+	fn.register("ai.agent", &FnEntry{
+		Handler:     f.agent,
+		Name:        "AI Agent",
+		Description: "Lets the model call the allowed actions as tools until it replies, returns {text, iterations, tool_calls: [{action, arguments, result, error}], usage}",
+		Category:    f.category,
+		Parameters: append(slices.Clone(aiModelParameters),
+			FnParameter{Name: "prompt", Description: "The task of the agent", Mandatory: true},
+			FnParameter{Name: "system", Description: "The system prompt", Mandatory: false},
+			FnParameter{Name: "tools", Description: "The actions the model may call, as names or glob patterns (e.g. string.*)", Type: "array", Mandatory: true},
+			FnParameter{Name: "connections", Description: "The connections and HTTP sessions the tools may use, the model cannot reference others", Type: "array", Mandatory: false},
+			FnParameter{Name: "max_iterations", Description: "The maximum number of model requests (default 10)", Type: "integer", Mandatory: false},
+			FnParameter{Name: "temperature", Description: "The sampling temperature", Type: "number", Mandatory: false},
+			FnParameter{Name: "max_tokens", Description: "The maximum number of tokens to generate per request", Type: "integer", Mandatory: false},
+		),
+	})
+}
+
+// aiModelParams identify a model, they are comparable to key the client cache
+type aiModelParams struct {
+	Provider string `json:"provider,omitempty" yaml:"provider,omitempty"`
+	ApiKey   string `json:"api_key" yaml:"api_key"`
+	Model    string `json:"model" yaml:"model"`
+	BaseUrl  string `json:"base_url,omitempty" yaml:"base_url,omitempty"`
+
+	embedding bool // the model creates embeddings
+}
+
+type aiChatParams struct {
+	aiModelParams
+	Prompt      string      `json:"prompt" yaml:"prompt"`
+	System      string      `json:"system" yaml:"system"`
+	Messages    []aiMessage `json:"messages,omitempty" yaml:"messages,omitempty"`
+	Attachments []string    `json:"attachments" yaml:"attachments"`
+	Temperature *float64    `json:"temperature,omitempty" yaml:"temperature,omitempty"`
+	MaxTokens   int         `json:"max_tokens,omitempty" yaml:"max_tokens,omitempty"`
+	Stop        []string    `json:"stop,omitempty" yaml:"stop,omitempty"`
+
+	JSON    bool            `json:"json,omitempty" yaml:"json,omitempty"`
+	Schema  json.RawMessage `json:"schema,omitempty" yaml:"schema,omitempty"`
+	Retries *int            `json:"retries,omitempty" yaml:"retries,omitempty"`
+}
+
+type aiMessage struct {
+	Role    string `json:"role" yaml:"role"`
+	Content string `json:"content" yaml:"content"`
+}
+
+type aiChatResult struct {
+	Text       string  `json:"text"`
+	StopReason string  `json:"stop_reason,omitempty"`
+	Usage      aiUsage `json:"usage"`
+
+	data json.RawMessage // the parsed reply of structured requests
 }
 
-type openAIStruct struct {
-	ApiKey      string   `json:"api_key" yaml:"api_key"`
-	Model       string   `json:"model" yaml:"model"`
-	Prompt      string   `json:"prompt" yaml:"prompt"`
-	System      string   `json:"system" yaml:"system"`
-	Attachments []string `json:"attachments" yaml:"attachments"`
+type aiUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
 }
 
 func (f *fnAi) openAI(j json.RawMessage) (json.RawMessage, error) {
-	return utils.HandleJSON(j, func(params *openAIStruct) (json.RawMessage, error) {
-		apiKey := params.ApiKey
-		modelName := params.Model
+	return utils.HandleJSON(j, func(params *aiChatParams) (json.RawMessage, error) {
+		if params.ApiKey == "" || params.Model == "" {
+			return nil, fmt.Errorf("api_key and model are required")
+		}
+		params.Provider = "openai"
+		result, err := f.generate(params)
+		if err != nil {
+			return nil, err
+		}
+		if result.data != nil {
+			return result.data, nil
+		}
+		return utils.ReturnRaw(result.Text), nil
+	})
+}
 
-		llm, err := openai.New(
-			openai.WithToken(apiKey),
-			openai.WithModel(modelName),
-		)
+func (f *fnAi) chat(j json.RawMessage) (json.RawMessage, error) {
+	return utils.HandleJSON(j, func(params *aiChatParams) (json.RawMessage, error) {
+		if params.Prompt == "" && len(params.Messages) == 0 {
+			return nil, fmt.Errorf("prompt or messages are required")
+		}
+		result, err := f.generate(params)
 		if err != nil {
-			return nil, fmt.Errorf("failed to initialize LLM: %v\n", err)
+			return nil, err
+		}
+		if result.data != nil {
+			return result.data, nil
+		}
+		return utils.ReturnRaw(result), nil
+	})
+}
+
+type aiEmbedParams struct {
+	aiModelParams
+	Input json.RawMessage `json:"input" yaml:"input"`
+}
+
+// embedder is implemented by the clients of providers supporting embeddings
+type embedder interface {
+	CreateEmbedding(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+func (f *fnAi) embed(j json.RawMessage) (json.RawMessage, error) {
+	return utils.HandleJSON(j, func(params *aiEmbedParams) (json.RawMessage, error) {
+		var texts []string
+		single := false
+		if err := json.Unmarshal(params.Input, &texts); err != nil {
+			var text string
+			if err := json.Unmarshal(params.Input, &text); err != nil {
+				return nil, fmt.Errorf("input must be a string or an array of strings")
+			}
+			texts, single = []string{text}, true
+		}
+		if len(texts) == 0 {
+			return utils.ReturnRaw([][]float32{}), nil
 		}
 
-		messages := []llms.MessageContent{}
+		params.embedding = true
+		llm, err := f.aiClient(params.aiModelParams)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize LLM: %w", err)
+		}
+		e, ok := llm.(embedder)
+		if !ok {
+			return nil, fmt.Errorf("provider %s does not support embeddings", cmp.Or(params.Provider, "openai"))
+		}
+		vectors, err := e.CreateEmbedding(context.Background(), texts)
+		if err != nil {
+			return nil, fmt.Errorf("error creating embeddings: %w", err)
+		}
+		if len(vectors) != len(texts) {
+			return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(vectors))
+		}
+		if single {
+			return utils.ReturnRaw(vectors[0]), nil
+		}
+		return utils.ReturnRaw(vectors), nil
+	})
+}
 
-		if params.System != "" {
-			messages = append(messages, llms.MessageContent{
-				Role:  llms.ChatMessageTypeSystem,
-				Parts: []llms.ContentPart{llms.TextPart(params.System)},
-			})
+// generate sends the messages of the params to the model and returns the
+// first choice. Structured requests are retried with the validation error
+// until the reply is valid JSON matching the schema.
+func (f *fnAi) generate(params *aiChatParams) (*aiChatResult, error) {
+	llm, err := f.aiClient(params.aiModelParams)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize LLM: %w", err)
+	}
+	structured := params.JSON || len(params.Schema) > 0
+	var schema *gojsonschema.Schema
+	if len(params.Schema) > 0 {
+		if schema, err = gojsonschema.NewSchema(gojsonschema.NewBytesLoader(params.Schema)); err != nil {
+			return nil, fmt.Errorf("invalid schema: %w", err)
 		}
+		params.System = strings.TrimSpace(params.System + "\n\nReply with JSON only, matching this JSON Schema:\n" + string(params.Schema))
+	} else if params.JSON {
+		params.System = strings.TrimSpace(params.System + "\n\nReply with JSON only.")
+	}
+	messages, err := f.chatMessages(params)
+	if err != nil {
+		return nil, err
+	}
 
-		userParts := []llms.ContentPart{
-			llms.TextPart(params.Prompt),
+	options := params.callOptions()
+	if structured {
+		options = append(options, llms.WithJSONMode())
+	}
+
+	retries := 2
+	if params.Retries != nil {
+		retries = *params.Retries
+	}
+	for attempt := 0; ; attempt++ {
+		response, err := llm.GenerateContent(context.Background(), messages, options...)
+		if err != nil {
+			return nil, fmt.Errorf("error during LLM call: %w", err)
+		}
+		if len(response.Choices) == 0 {
+			return nil, fmt.Errorf("LLM returned no choices")
+		}
+		choice := response.Choices[0]
+		result := &aiChatResult{
+			Text:       choice.Content,
+			StopReason: choice.StopReason,
+			Usage:      usageOf(choice.GenerationInfo),
+		}
+		if !structured {
+			return result, nil
 		}
 
-		for _, attachment := range params.Attachments {
-			if len(attachment) > 4 && attachment[:4] == "http" {
-				userParts = append(userParts, llms.ImageURLPart(attachment))
-			} else {
-				fileContent, err := os.ReadFile(attachment)
-				if err != nil {
-					return nil, fmt.Errorf("failed to read attachment: %v\n", err)
-				}
-				userParts = append(userParts, llms.BinaryPart("application/octet-stream", fileContent))
+		data, problem := parseReply(choice.Content, schema)
+		if problem == "" {
+			result.data = data
+			return result, nil
+		}
+		if attempt >= retries {
+			return nil, &ResultError{
+				Err:    fmt.Errorf("invalid JSON reply after %d attempts: %s", attempt+1, problem),
+				Result: utils.ReturnRaw(map[string]any{"text": choice.Content, "error": problem}),
 			}
 		}
+		messages = append(messages,
+			llms.TextParts(llms.ChatMessageTypeAI, choice.Content),
+			llms.TextParts(llms.ChatMessageTypeHuman, fmt.Sprintf("Your reply is invalid: %s. Reply with the corrected JSON only.", problem)),
+		)
+	}
+}
+
+// callOptions returns the sampling options of the params
+func (p *aiChatParams) callOptions() []llms.CallOption {
+	var options []llms.CallOption
+	if p.Temperature != nil {
+		options = append(options, llms.WithTemperature(*p.Temperature))
+	}
+	if p.MaxTokens > 0 {
+		options = append(options, llms.WithMaxTokens(p.MaxTokens))
+	}
+	if len(p.Stop) > 0 {
+		options = append(options, llms.WithStopWords(p.Stop))
+	}
+	return options
+}
+
+// parseReply extracts the JSON of a reply, which models may wrap in code
+// fences or prose, and validates it. It returns the problem of invalid replies.
+func parseReply(text string, schema *gojsonschema.Schema) (json.RawMessage, string) {
+	data := []byte(strings.TrimSpace(text))
+	if !json.Valid(data) {
+		start := strings.IndexAny(text, "{[")
+		end := strings.LastIndexAny(text, "}]")
+		if start < 0 || end < start || !json.Valid([]byte(text[start:end+1])) {
+			return nil, "the reply is not valid JSON"
+		}
+		data = []byte(text[start : end+1])
+	}
+	if schema == nil {
+		return data, ""
+	}
 
-		messages = append(messages, llms.MessageContent{
-			Role:  llms.ChatMessageTypeHuman,
-			Parts: userParts,
-		})
+	result, err := schema.Validate(gojsonschema.NewBytesLoader(data))
+	if err != nil {
+		return nil, err.Error()
+	}
+	if !result.Valid() {
+		problems := make([]string, len(result.Errors()))
+		for i, e := range result.Errors() {
+			problems[i] = e.String()
+		}
+		return nil, "the reply does not match the schema: " + strings.Join(problems, "; ")
+	}
+	return data, ""
+}
 
-		payload := map[string]interface{}{
-			"model":    modelName,
-			"messages": messages,
+// chatMessages builds the system prompt, previous messages and the user
+// prompt including its attachments
+func (f *fnAi) chatMessages(params *aiChatParams) ([]llms.MessageContent, error) {
+	messages := []llms.MessageContent{}
+	if params.System != "" {
+		messages = append(messages, llms.TextParts(llms.ChatMessageTypeSystem, params.System))
+	}
+	for _, message := range params.Messages {
+		var role llms.ChatMessageType
+		switch message.Role {
+		case "system":
+			role = llms.ChatMessageTypeSystem
+		case "user":
+			role = llms.ChatMessageTypeHuman
+		case "assistant":
+			role = llms.ChatMessageTypeAI
+		default:
+			return nil, fmt.Errorf("invalid message role: %s", message.Role)
 		}
+		messages = append(messages, llms.TextParts(role, message.Content))
+	}
+	if params.Prompt == "" && len(params.Attachments) == 0 {
+		return messages, nil
+	}
 
-		payloadBytes, err := json.Marshal(payload)
+	userParts := []llms.ContentPart{}
+	if params.Prompt != "" {
+		userParts = append(userParts, llms.TextPart(params.Prompt))
+	}
+	for _, attachment := range params.Attachments {
+		part, err := f.attachmentPart(attachment)
 		if err != nil {
-			return nil, fmt.Errorf("failed to serialize payload: %v\n", err)
+			return nil, err
 		}
+		userParts = append(userParts, part)
+	}
+	return append(messages, llms.MessageContent{
+		Role:  llms.ChatMessageTypeHuman,
+		Parts: userParts,
+	}), nil
+}
+
+// attachmentPart references urls and embeds files and blobs with their content type
+func (f *fnAi) attachmentPart(attachment string) (llms.ContentPart, error) {
+	if strings.HasPrefix(attachment, "http://") || strings.HasPrefix(attachment, "https://") {
+		return llms.ImageURLPart(attachment), nil
+	}
 
-		response, err := llm.Call(context.Background(), string(payloadBytes))
+	var content []byte
+	if blob, _, ok, err := f.openBlob(attachment); ok {
 		if err != nil {
-			return nil, fmt.Errorf("error during LLM call: %v\n", err)
+			return nil, err
 		}
+		defer blob.Close()
+		if content, err = io.ReadAll(blob); err != nil {
+			return nil, fmt.Errorf("failed to read attachment: %w", err)
+		}
+	} else {
+		if err := f.checkRead(attachment); err != nil {
+			return nil, err
+		}
+		if content, err = os.ReadFile(attachment); err != nil {
+			return nil, fmt.Errorf("failed to read attachment: %w", err)
+		}
+	}
+	contentType, err := detectContentType(attachment, bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	return llms.BinaryPart(contentType, content), nil
+}
 
-		return utils.ReturnRaw(response), nil
-	})
+// usageOf reads the token usage from the generation info, whose keys differ by provider
+func usageOf(info map[string]any) aiUsage {
+	count := func(keys ...string) int {
+		for _, key := range keys {
+			switch v := info[key].(type) {
+			case int:
+				return v
+			case int32:
+				return int(v)
+			case int64:
+				return int(v)
+			case float64:
+				return int(v)
+			}
+		}
+		return 0
+	}
+	usage := aiUsage{
+		PromptTokens:     count("PromptTokens", "InputTokens"),
+		CompletionTokens: count("CompletionTokens", "OutputTokens"),
+		TotalTokens:      count("TotalTokens"),
+	}
+	if usage.TotalTokens == 0 {
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+	return usage
+}
+
+// aiClient returns the cached client of the model, clients are shared by
+// all operations of the run
+func (f *fnAi) aiClient(params aiModelParams) (llms.Model, error) {
+	if params.Provider == "" {
+		params.Provider = "openai"
+	}
+	if params.Model == "" {
+		return nil, fmt.Errorf("model is required")
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if llm, ok := f.aiClients[params]; ok {
+		return llm, nil
+	}
+
+	var llm llms.Model
+	var err error
+	switch params.Provider {
+	case "openai":
+		options := []openai.Option{openai.WithToken(params.ApiKey), openai.WithModel(params.Model)}
+		if params.embedding {
+			options = append(options, openai.WithEmbeddingModel(params.Model))
+		}
+		if params.BaseUrl != "" {
+			options = append(options, openai.WithBaseURL(params.BaseUrl))
+		}
+		llm, err = openai.New(options...)
+	case "anthropic":
+		options := []anthropic.Option{anthropic.WithToken(params.ApiKey), anthropic.WithModel(params.Model)}
+		if params.BaseUrl != "" {
+			options = append(options, anthropic.WithBaseURL(params.BaseUrl))
+		}
+		llm, err = anthropic.New(options...)
+	case "ollama":
+		options := []ollama.Option{ollama.WithModel(params.Model)}
+		if params.BaseUrl != "" {
+			options = append(options, ollama.WithServerURL(params.BaseUrl))
+		}
+		llm, err = ollama.New(options...)
+	default:
+		return nil, fmt.Errorf("unsupported provider: %s", params.Provider)
+	}
+	if err != nil {
+		return nil, err
+	}
+	f.aiClients[params] = llm
+	return llm, nil
 }
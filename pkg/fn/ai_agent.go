@@ -0,0 +1,233 @@
+package fn
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"github.com/tmc/langchaingo/llms"
+	"github.com/yosev/coda/internal/utils"
+)
+
+type aiAgentParams struct {
+	aiChatParams
+	Tools         []string `json:"tools" yaml:"tools"`
+	Connections   []string `json:"connections,omitempty" yaml:"connections,omitempty"`
+	MaxIterations int      `json:"max_iterations,omitempty" yaml:"max_iterations,omitempty"`
+}
+
+// aiToolCall is a single tool call of the agent transcript
+type aiToolCall struct {
+	Action    string          `json:"action"`
+	Arguments json.RawMessage `json:"arguments"`
+	Result    json.RawMessage `json:"result,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+type aiAgentResult struct {
+	Text       string       `json:"text"`
+	Iterations int          `json:"iterations"`
+	ToolCalls  []aiToolCall `json:"tool_calls"`
+	Usage      aiUsage      `json:"usage"`
+}
+
+// agent runs the tool call loop, the tool calls are executed through the
+// executor so blacklists and policies of the run apply to them
+func (f *fnAi) agent(j json.RawMessage) (json.RawMessage, error) {
+	return utils.HandleJSON(j, func(params *aiAgentParams) (json.RawMessage, error) {
+		tools, actions, err := f.agentTools(params.Tools, params.Connections)
+		if err != nil {
+			return nil, err
+		}
+		llm, err := f.aiClient(params.aiModelParams)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize LLM: %w", err)
+		}
+		messages, err := f.chatMessages(&params.aiChatParams)
+		if err != nil {
+			return nil, err
+		}
+		maxIterations := params.MaxIterations
+		if maxIterations <= 0 {
+			maxIterations = 10
+		}
+		options := append(params.callOptions(), llms.WithTools(tools))
+
+		result := aiAgentResult{ToolCalls: []aiToolCall{}}
+		for result.Iterations < maxIterations {
+			result.Iterations++
+			response, err := llm.GenerateContent(context.Background(), messages, options...)
+			if err != nil {
+				return nil, fmt.Errorf("error during LLM call: %w", err)
+			}
+			if len(response.Choices) == 0 {
+				return nil, fmt.Errorf("LLM returned no choices")
+			}
+			choice := response.Choices[0]
+			usage := usageOf(choice.GenerationInfo)
+			result.Usage.PromptTokens += usage.PromptTokens
+			result.Usage.CompletionTokens += usage.CompletionTokens
+			result.Usage.TotalTokens += usage.TotalTokens
+
+			if len(choice.ToolCalls) == 0 {
+				result.Text = choice.Content
+				return utils.ReturnRaw(result), nil
+			}
+
+			request := llms.MessageContent{Role: llms.ChatMessageTypeAI}
+			if choice.Content != "" {
+				request.Parts = append(request.Parts, llms.TextPart(choice.Content))
+			}
+			for _, call := range choice.ToolCalls {
+				request.Parts = append(request.Parts, call)
+			}
+			messages = append(messages, request)
+
+			for _, call := range choice.ToolCalls {
+				toolCall := f.callTool(actions, params.Connections, call)
+				result.ToolCalls = append(result.ToolCalls, toolCall)
+
+				content := string(toolCall.Result)
+				if toolCall.Error != "" {
+					content = "error: " + toolCall.Error
+				}
+				messages = append(messages, llms.MessageContent{
+					Role: llms.ChatMessageTypeTool,
+					Parts: []llms.ContentPart{llms.ToolCallResponse{
+						ToolCallID: call.ID,
+						Name:       call.FunctionCall.Name,
+						Content:    content,
+					}},
+				})
+			}
+		}
+
+		return nil, &ResultError{
+			Err:    fmt.Errorf("agent did not finish within %d iterations", maxIterations),
+			Result: utils.ReturnRaw(result),
+		}
+	})
+}
+
+// callTool executes a tool call of the model, failures are reported back
+// to the model instead of failing the agent. The model may only reference
+// the connections and sessions listed by the agent.
+func (f *fnAi) callTool(actions map[string]string, connections []string, call llms.ToolCall) aiToolCall {
+	toolCall := aiToolCall{Action: call.FunctionCall.Name, Arguments: json.RawMessage(call.FunctionCall.Arguments)}
+	action, ok := actions[call.FunctionCall.Name]
+	if !ok {
+		toolCall.Arguments = utils.ReturnRaw(call.FunctionCall.Arguments)
+		toolCall.Error = fmt.Sprintf("unknown tool: %s", call.FunctionCall.Name)
+		return toolCall
+	}
+	toolCall.Action = action
+	if !json.Valid(toolCall.Arguments) {
+		toolCall.Arguments = utils.ReturnRaw(call.FunctionCall.Arguments)
+		toolCall.Error = "arguments are not valid JSON"
+		return toolCall
+	}
+	for _, key := range []string{"connection", "session"} {
+		name := gjson.GetBytes(toolCall.Arguments, key)
+		if name.Exists() && !slices.Contains(connections, name.String()) {
+			toolCall.Error = fmt.Sprintf("%s '%s' is not available to the agent", key, name.String())
+			return toolCall
+		}
+	}
+
+	result, err := f.execute(action, toolCall.Arguments)
+	var resultErr *ResultError
+	if errors.As(err, &resultErr) {
+		result = resultErr.Result
+	}
+	toolCall.Result = result
+	if err != nil {
+		toolCall.Error = err.Error()
+	}
+	return toolCall
+}
+
+// agentTools converts the actions matching the patterns into tool
+// definitions and maps the tool names back to the action names
+func (f *fnAi) agentTools(patterns []string, connections []string) ([]llms.Tool, map[string]string, error) {
+	names := []string{}
+	for name := range f.fns {
+		if name == "ai.agent" {
+			continue // agents cannot start agents
+		}
+		for _, pattern := range patterns {
+			if ok, err := path.Match(pattern, name); err != nil {
+				return nil, nil, fmt.Errorf("invalid tool pattern '%s': %w", pattern, err)
+			} else if ok {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	if len(names) == 0 {
+		return nil, nil, fmt.Errorf("no actions match the tools %v", patterns)
+	}
+	sort.Strings(names)
+
+	tools := make([]llms.Tool, len(names))
+	actions := map[string]string{}
+	for i, name := range names {
+		entry := f.fns[name]
+		parameters := entry.Parameters
+		if len(connections) == 0 {
+			parameters = withoutParameters(parameters, "connection", "session")
+		}
+		// tool names may only contain letters, digits, underscores and dashes
+		toolName := strings.ReplaceAll(name, ".", "_")
+		actions[toolName] = name
+		tools[i] = llms.Tool{
+			Type: "function",
+			Function: &llms.FunctionDefinition{
+				Name:        toolName,
+				Description: fmt.Sprintf("%s: %s", entry.Name, entry.Description),
+				Parameters:  parametersSchema(parameters),
+			},
+		}
+	}
+	return tools, actions, nil
+}
+
+// parametersSchema describes the parameters of an action as JSON Schema
+func parametersSchema(parameters []FnParameter) map[string]any {
+	properties := map[string]any{}
+	required := []string{}
+	for _, p := range parameters {
+		property := map[string]any{"description": p.Description}
+		switch {
+		case p.Type == "":
+			property["type"] = "string"
+		case p.Type != "any":
+			types := strings.Split(p.Type, ",")
+			if len(types) == 1 {
+				property["type"] = types[0]
+			} else {
+				property["type"] = types
+			}
+			if strings.Contains(p.Type, "array") {
+				property["items"] = map[string]any{}
+			}
+		}
+		if len(p.Enum) > 0 {
+			property["enum"] = p.Enum
+		}
+		properties[p.Name] = property
+		if p.Mandatory {
+			required = append(required, p.Name)
+		}
+	}
+	return map[string]any{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
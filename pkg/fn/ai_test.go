@@ -0,0 +1,173 @@
+package fn
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+// newOpenAIStub answers chat completions with the replies in order and
+// records the received requests
+func newOpenAIStub(t *testing.T, replies ...string) (*httptest.Server, *[]gjson.Result) {
+	t.Helper()
+	requests := []gjson.Result{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/chat/completions" || r.Header.Get("Authorization") != "Bearer test-key" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var body json.RawMessage
+		json.NewDecoder(r.Body).Decode(&body)
+		requests = append(requests, gjson.ParseBytes(body))
+		reply := replies[min(len(requests), len(replies))-1]
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{
+			"id": "chatcmpl-1", "object": "chat.completion", "model": "stub",
+			"choices": [{"index": 0, "finish_reason": "stop", "message": {"role": "assistant", "content": %q}}],
+			"usage": {"prompt_tokens": 12, "completion_tokens": 3, "total_tokens": 15}
+		}`, reply)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestAiChat(t *testing.T) {
+	f := New("test")
+	server, requests := newOpenAIStub(t, "Hello coda")
+
+	out, err := callFn(t, f, "ai.chat", map[string]any{
+		"base_url":    server.URL,
+		"api_key":     "test-key",
+		"model":       "stub",
+		"system":      "Be brief",
+		"messages":    []map[string]string{{"role": "user", "content": "Hi"}, {"role": "assistant", "content": "Hello"}},
+		"prompt":      "Greet coda",
+		"temperature": 0.2,
+		"max_tokens":  16,
+		"stop":        []string{"\n"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := gjson.ParseBytes(out)
+	if result.Get("text").String() != "Hello coda" || result.Get("stop_reason").String() != "stop" {
+		t.Errorf("unexpected result: %s", out)
+	}
+	if result.Get("usage.prompt_tokens").Int() != 12 || result.Get("usage.completion_tokens").Int() != 3 || result.Get("usage.total_tokens").Int() != 15 {
+		t.Errorf("unexpected usage: %s", result.Get("usage").Raw)
+	}
+
+	request := (*requests)[0]
+	roles := []string{}
+	for _, role := range request.Get("messages.#.role").Array() {
+		roles = append(roles, role.String())
+	}
+	if !reflect.DeepEqual(roles, []string{"system", "user", "assistant", "user"}) {
+		t.Errorf("unexpected roles: %v", roles)
+	}
+	if request.Get("temperature").Float() != 0.2 || request.Get("stop.0").String() != "\n" {
+		t.Errorf("unexpected options: %s", request.Raw)
+	}
+
+	// ai.openai shares the client and sends the system prompt as its own message
+	out, err = callFn(t, f, "ai.openai", map[string]any{"base_url": server.URL, "api_key": "test-key", "model": "stub", "system": "Be brief", "prompt": "Hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != `"Hello coda"` || (*requests)[1].Get("messages.0.role").String() != "system" {
+		t.Errorf("unexpected openai result %s for request %s", out, (*requests)[1].Raw)
+	}
+
+	if _, err := callFn(t, f, "ai.chat", map[string]any{"provider": "unknown", "model": "stub", "prompt": "Hi"}); err == nil {
+		t.Error("expected an unknown provider to fail")
+	}
+}
+
+func TestAiStructuredOutput(t *testing.T) {
+	f := New("test")
+	server, requests := newOpenAIStub(t, `Sure! {"label": 5}`, "```json\n{\"label\": \"spam\", \"score\": 0.9}\n```")
+	params := map[string]any{
+		"base_url": server.URL,
+		"api_key":  "test-key",
+		"model":    "stub",
+		"prompt":   "Classify: win a prize",
+		"schema": map[string]any{
+			"type":       "object",
+			"properties": map[string]any{"label": map[string]any{"type": "string"}, "score": map[string]any{"type": "number"}},
+			"required":   []string{"label"},
+		},
+	}
+
+	out, err := callFn(t, f, "ai.chat", params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gjson.GetBytes(out, "label").String() != "spam" || gjson.GetBytes(out, "score").Float() != 0.9 {
+		t.Errorf("expected the parsed reply, got %s", out)
+	}
+	if len(*requests) != 2 {
+		t.Fatalf("expected one retry, got %d requests", len(*requests))
+	}
+	first, retry := (*requests)[0], (*requests)[1]
+	if first.Get("response_format.type").String() != "json_object" {
+		t.Errorf("expected JSON mode, got %s", first.Raw)
+	}
+	if feedback := retry.Get("messages.@reverse.0.content").String(); !strings.Contains(feedback, "label") {
+		t.Errorf("expected the validation error in the retry, got %s", feedback)
+	}
+
+	params["retries"] = 0
+	*requests = (*requests)[:0]
+	_, err = callFn(t, f, "ai.chat", params)
+	var resultErr *ResultError
+	if !errors.As(err, &resultErr) || gjson.GetBytes(resultErr.Result, "text").String() != `Sure! {"label": 5}` {
+		t.Errorf("expected a result error with the invalid reply, got %v", err)
+	}
+}
+
+func TestAiEmbed(t *testing.T) {
+	f := New("test")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := gjson.ParseBytes(must(io.ReadAll(r.Body)))
+		if r.URL.Path != "/embeddings" || body.Get("model").String() != "embedder" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		data := []string{}
+		for i, input := range body.Get("input").Array() {
+			data = append(data, fmt.Sprintf(`{"object": "embedding", "index": %d, "embedding": [%d, 0.5]}`, i, len(input.String())))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"object": "list", "data": [%s], "model": "embedder", "usage": {"prompt_tokens": 2, "total_tokens": 2}}`, strings.Join(data, ","))
+	}))
+	defer server.Close()
+	params := map[string]any{"base_url": server.URL, "api_key": "key", "model": "embedder"}
+
+	out, err := callFn(t, f, "ai.embed", merge(params, map[string]any{"input": "abc"}))
+	if err != nil || string(out) != "[3,0.5]" {
+		t.Errorf("unexpected embedding %s: %v", out, err)
+	}
+	out, err = callFn(t, f, "ai.embed", merge(params, map[string]any{"input": []string{"a", "ab"}}))
+	if err != nil || string(out) != "[[1,0.5],[2,0.5]]" {
+		t.Errorf("unexpected embeddings %s: %v", out, err)
+	}
+	if _, err := callFn(t, f, "ai.embed", map[string]any{"provider": "anthropic", "api_key": "key", "model": "claude", "input": "abc"}); err == nil {
+		t.Error("expected a provider without embeddings to fail")
+	}
+}
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
@@ -0,0 +1,537 @@
+package fn
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/yosev/coda/internal/utils"
+)
+
+type fnArchive struct {
+	*Fn
+	category FnCategory
+}
+
+func (f *fnArchive) init(fn *Fn) {
+	f.Fn = fn
+
+	fn.register("archive.create", &FnEntry{
+		Handler:     f.create,
+		Name:        "Create archive",
+		Description: "Create a zip, tar, tar.gz or tar.zst archive of a file or directory and return a manifest of its entries",
+		Category:    f.category,
+		Parameters: []FnParameter{
+			{Name: "source", Description: "The path of the file or directory to archive", Mandatory: true},
+			{Name: "destination", Description: "The path of the archive to create", Mandatory: true},
+			{Name: "format", Description: "The archive format, detected from the destination extension by default", Enum: archiveFormats, Mandatory: false},
+			{Name: "include", Description: "Glob patterns of files to include, patterns without a slash match the name", Type: "array", Mandatory: false},
+			{Name: "exclude", Description: "Glob patterns of entries to exclude, patterns without a slash match the name", Type: "array", Mandatory: false},
+			{Name: "invisible_files", Description: "If true, entries starting with a dot are archived", Type: "boolean", Mandatory: false},
+		},
+	})
+	fn.register("archive.extract", &FnEntry{
+		Handler:     f.extract,
+		Name:        "Extract archive",
+		Description: "Extract a zip, tar, tar.gz or tar.zst archive and return a manifest of the extracted entries, entries escaping the destination are rejected",
+		Category:    f.category,
+		Parameters: []FnParameter{
+			{Name: "source", Description: "The path of the archive to extract", Mandatory: true},
+			{Name: "destination", Description: "The directory to extract into", Mandatory: true},
+			{Name: "format", Description: "The archive format, detected from the source extension by default", Enum: archiveFormats, Mandatory: false},
+			{Name: "include", Description: "Glob patterns of files to extract, patterns without a slash match the name", Type: "array", Mandatory: false},
+			{Name: "exclude", Description: "Glob patterns of entries to skip, patterns without a slash match the name", Type: "array", Mandatory: false},
+			{Name: "overwrite", Description: "If true, existing files are overwritten", Type: "boolean", Mandatory: false},
+		},
+	})
+}
+
+var archiveFormats = []string{"zip", "tar", "tar.gz", "tar.zst"}
+
+// detectArchiveFormat returns the given format or the one of the file extension
+func detectArchiveFormat(format, name string) (string, error) {
+	if format != "" {
+		for _, f := range archiveFormats {
+			if f == format {
+				return format, nil
+			}
+		}
+		return "", fmt.Errorf("unsupported archive format: %s", format)
+	}
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return "zip", nil
+	case strings.HasSuffix(name, ".tar"):
+		return "tar", nil
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "tar.gz", nil
+	case strings.HasSuffix(name, ".tar.zst"), strings.HasSuffix(name, ".tzst"):
+		return "tar.zst", nil
+	default:
+		return "", fmt.Errorf("cannot detect the archive format of %s, set format explicitly", name)
+	}
+}
+
+// archiveEntry is a single entry of an archive manifest
+type archiveEntry struct {
+	Name  string `json:"name"`
+	Size  int64  `json:"size"`
+	IsDir bool   `json:"is_dir"`
+	Link  string `json:"link,omitempty"`
+}
+
+type archiveManifest struct {
+	Path    string         `json:"path"`
+	Format  string         `json:"format"`
+	Entries []archiveEntry `json:"entries"`
+	Count   int            `json:"count"`
+	Size    int64          `json:"size"` // total size of the file entries
+}
+
+func (m *archiveManifest) add(entry archiveEntry) {
+	m.Entries = append(m.Entries, entry)
+	m.Count++
+	m.Size += entry.Size
+}
+
+type archiveCreateParams struct {
+	Source         string   `json:"source" yaml:"source"`
+	Destination    string   `json:"destination" yaml:"destination"`
+	Format         string   `json:"format,omitempty" yaml:"format,omitempty"`
+	Include        []string `json:"include,omitempty" yaml:"include,omitempty"`
+	Exclude        []string `json:"exclude,omitempty" yaml:"exclude,omitempty"`
+	InvisibleFiles bool     `json:"invisible_files,omitempty" yaml:"invisible_files,omitempty"` // Whether to include files starting with a dot (.)
+}
+
+func (f *fnArchive) create(j json.RawMessage) (json.RawMessage, error) {
+	return utils.HandleJSON(j, func(params *archiveCreateParams) (json.RawMessage, error) {
+		if err := f.checkRead(params.Source); err != nil {
+			return nil, err
+		}
+		if err := f.checkWrite(params.Destination); err != nil {
+			return nil, err
+		}
+		format, err := detectArchiveFormat(params.Format, params.Destination)
+		if err != nil {
+			return nil, err
+		}
+		info, err := os.Stat(params.Source)
+		if err != nil {
+			return nil, err
+		}
+
+		// collect the entries, a single file is archived by its name
+		var entries []fileEntry
+		if info.IsDir() {
+			opts := walkOptions{exclude: params.Exclude, invisibleFiles: params.InvisibleFiles}
+			if len(params.Include) > 0 {
+				opts.entryType = "file"
+				opts.match = func(rel string) bool {
+					return matchAny(params.Include, rel)
+				}
+			}
+			if entries, err = walkEntries(params.Source, opts); err != nil {
+				return nil, err
+			}
+			// never archive the archive itself
+			if destination, err := filepath.Abs(params.Destination); err == nil {
+				entries = slices.DeleteFunc(entries, func(e fileEntry) bool {
+					abs, _ := filepath.Abs(e.Path)
+					return abs == destination
+				})
+			}
+		} else {
+			entries = []fileEntry{{Path: params.Source, Name: info.Name(), Relative: info.Name(), Size: info.Size()}}
+		}
+
+		out, err := os.Create(params.Destination)
+		if err != nil {
+			return nil, err
+		}
+		defer out.Close()
+
+		manifest := &archiveManifest{Path: params.Destination, Format: format, Entries: []archiveEntry{}}
+		if format == "zip" {
+			err = writeZip(out, entries, manifest)
+		} else {
+			err = writeTar(out, format, entries, manifest)
+		}
+		if err == nil {
+			err = out.Close()
+		}
+		if err != nil {
+			os.Remove(params.Destination)
+			return nil, fmt.Errorf("failed to create archive: %w", err)
+		}
+		return utils.ReturnRaw(manifest), nil
+	})
+}
+
+func writeZip(out io.Writer, entries []fileEntry, manifest *archiveManifest) error {
+	zw := zip.NewWriter(out)
+	for _, entry := range entries {
+		info, err := os.Lstat(entry.Path)
+		if err != nil {
+			return err
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = entry.Relative
+		item := archiveEntry{Name: entry.Relative, IsDir: info.IsDir()}
+
+		switch {
+		case info.IsDir():
+			header.Name += "/"
+		case info.Mode()&fs.ModeSymlink != 0:
+			if item.Link, err = os.Readlink(entry.Path); err != nil {
+				return err
+			}
+		case info.Mode().IsRegular():
+			header.Method = zip.Deflate
+			item.Size = info.Size()
+		default:
+			continue // skip devices, sockets and pipes
+		}
+
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if item.Link != "" {
+			// zip stores the target of a symlink as its content
+			if _, err := io.WriteString(w, item.Link); err != nil {
+				return err
+			}
+		} else if info.Mode().IsRegular() {
+			if err := copyFileTo(w, entry.Path); err != nil {
+				return err
+			}
+		}
+		manifest.add(item)
+	}
+	return zw.Close()
+}
+
+func writeTar(out io.Writer, format string, entries []fileEntry, manifest *archiveManifest) error {
+	var compressor io.WriteCloser
+	switch format {
+	case "tar.gz":
+		compressor = gzip.NewWriter(out)
+	case "tar.zst":
+		zw, err := zstd.NewWriter(out)
+		if err != nil {
+			return err
+		}
+		compressor = zw
+	}
+	if compressor != nil {
+		out = compressor
+	}
+
+	tw := tar.NewWriter(out)
+	for _, entry := range entries {
+		info, err := os.Lstat(entry.Path)
+		if err != nil {
+			return err
+		}
+		item := archiveEntry{Name: entry.Relative, IsDir: info.IsDir()}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if item.Link, err = os.Readlink(entry.Path); err != nil {
+				return err
+			}
+		} else if !info.IsDir() && !info.Mode().IsRegular() {
+			continue // skip devices, sockets and pipes
+		}
+
+		header, err := tar.FileInfoHeader(info, item.Link)
+		if err != nil {
+			return err
+		}
+		header.Name = entry.Relative
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			item.Size = info.Size()
+			if err := copyFileTo(tw, entry.Path); err != nil {
+				return err
+			}
+		}
+		manifest.add(item)
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if compressor != nil {
+		return compressor.Close()
+	}
+	return nil
+}
+
+func copyFileTo(w io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(w, file)
+	return err
+}
+
+func matchAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		if matchPattern(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+type archiveExtractParams struct {
+	Source      string   `json:"source" yaml:"source"`
+	Destination string   `json:"destination" yaml:"destination"`
+	Format      string   `json:"format,omitempty" yaml:"format,omitempty"`
+	Include     []string `json:"include,omitempty" yaml:"include,omitempty"`
+	Exclude     []string `json:"exclude,omitempty" yaml:"exclude,omitempty"`
+	Overwrite   bool     `json:"overwrite,omitempty" yaml:"overwrite,omitempty"`
+}
+
+// extractor writes archive entries below the destination directory
+type extractor struct {
+	params   *archiveExtractParams
+	manifest *archiveManifest
+}
+
+func (f *fnArchive) extract(j json.RawMessage) (json.RawMessage, error) {
+	return utils.HandleJSON(j, func(params *archiveExtractParams) (json.RawMessage, error) {
+		if err := f.checkRead(params.Source); err != nil {
+			return nil, err
+		}
+		if err := f.checkWrite(params.Destination); err != nil {
+			return nil, err
+		}
+		format, err := detectArchiveFormat(params.Format, params.Source)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(params.Destination, 0755); err != nil {
+			return nil, err
+		}
+
+		x := &extractor{params: params, manifest: &archiveManifest{Path: params.Destination, Format: format, Entries: []archiveEntry{}}}
+		if format == "zip" {
+			err = x.extractZip()
+		} else {
+			err = x.extractTar(format)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract archive: %w", err)
+		}
+		return utils.ReturnRaw(x.manifest), nil
+	})
+}
+
+func (x *extractor) extractZip() error {
+	zr, err := zip.OpenReader(x.params.Source)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, file := range zr.File {
+		mode := file.Mode()
+		var link string
+		if mode&fs.ModeSymlink != 0 {
+			b, err := readZipFile(file, 4096)
+			if err != nil {
+				return err
+			}
+			link = string(b)
+		}
+		err := x.write(file.Name, mode, link, func() (io.ReadCloser, error) {
+			return file.Open()
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readZipFile(file *zip.File, limit int64) ([]byte, error) {
+	r, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(io.LimitReader(r, limit))
+}
+
+func (x *extractor) extractTar(format string) error {
+	file, err := os.Open(x.params.Source)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var in io.Reader = file
+	switch format {
+	case "tar.gz":
+		gr, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		in = gr
+	case "tar.zst":
+		zr, err := zstd.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		in = zr
+	}
+
+	tr := tar.NewReader(in)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		mode := header.FileInfo().Mode()
+		switch header.Typeflag {
+		case tar.TypeReg, tar.TypeDir, tar.TypeSymlink:
+		default:
+			continue // skip hard links, devices and other special entries
+		}
+		err = x.write(header.Name, mode, header.Linkname, func() (io.ReadCloser, error) {
+			return io.NopCloser(tr), nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// write extracts a single entry after checking that it stays within the destination
+func (x *extractor) write(name string, mode fs.FileMode, link string, open func() (io.ReadCloser, error)) error {
+	rel := path.Clean(strings.TrimPrefix(strings.ReplaceAll(name, "\\", "/"), "./"))
+	if rel == "." {
+		return nil
+	}
+	if !filepath.IsLocal(filepath.FromSlash(rel)) {
+		return fmt.Errorf("entry %s escapes the destination", name)
+	}
+	if len(x.params.Exclude) > 0 && matchAny(x.params.Exclude, rel) {
+		return nil
+	}
+	if len(x.params.Include) > 0 && !mode.IsDir() && !matchAny(x.params.Include, rel) {
+		return nil
+	}
+
+	target := filepath.Join(x.params.Destination, filepath.FromSlash(rel))
+	if err := x.checkParents(rel); err != nil {
+		return err
+	}
+	item := archiveEntry{Name: rel, IsDir: mode.IsDir()}
+
+	switch {
+	case mode.IsDir():
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return err
+		}
+	case mode&fs.ModeSymlink != 0:
+		// the link target must resolve within the destination
+		if filepath.IsAbs(link) || !filepath.IsLocal(filepath.Join(filepath.Dir(filepath.FromSlash(rel)), filepath.FromSlash(link))) {
+			return fmt.Errorf("symlink %s points outside of the destination: %s", name, link)
+		}
+		if err := x.prepare(target); err != nil {
+			return err
+		}
+		if err := os.Symlink(link, target); err != nil {
+			return err
+		}
+		item.Link = link
+	default:
+		if err := x.prepare(target); err != nil {
+			return err
+		}
+		r, err := open()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm()|0600)
+		if err != nil {
+			return err
+		}
+		n, err := io.Copy(file, r)
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		item.Size = n
+	}
+	x.manifest.add(item)
+	return nil
+}
+
+// checkParents rejects entries whose parent directories are symlinks, which
+// could redirect the write outside of the destination
+func (x *extractor) checkParents(rel string) error {
+	dir := x.params.Destination
+	parts := strings.Split(rel, "/")
+	for _, part := range parts[:len(parts)-1] {
+		dir = filepath.Join(dir, part)
+		info, err := os.Lstat(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("entry %s is written through the symlink %s", rel, dir)
+		}
+	}
+	return nil
+}
+
+// prepare creates the parent directories of a file and removes an existing
+// file if overwriting is enabled
+func (x *extractor) prepare(target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if _, err := os.Lstat(target); err == nil {
+		if !x.params.Overwrite {
+			return fmt.Errorf("%s already exists", target)
+		}
+		if err := os.Remove(target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
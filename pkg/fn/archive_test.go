@@ -0,0 +1,105 @@
+package fn
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func manifestNames(t *testing.T, out []byte) []string {
+	t.Helper()
+	names := []string{}
+	for _, entry := range gjson.GetBytes(out, "entries").Array() {
+		names = append(names, entry.Get("name").String())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source")
+	for name, content := range map[string]string{"a.log": "aaa", "b.txt": "bb", "sub/c.log": "c", ".hidden": "h"} {
+		path := filepath.Join(source, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte(content), 0644)
+	}
+	os.Symlink("a.log", filepath.Join(source, "link"))
+	f := New("test")
+
+	for _, format := range []string{"zip", "tar", "tar.gz", "tar.zst"} {
+		archive := filepath.Join(dir, "archive."+format)
+		out, err := callFn(t, f, "archive.create", map[string]any{"source": source, "destination": archive, "exclude": []string{"b.txt"}})
+		if err != nil {
+			t.Fatalf("%s: create failed: %v", format, err)
+		}
+		want := []string{"a.log", "link", "sub", "sub/c.log"}
+		if got := manifestNames(t, out); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: created entries = %v, want %v", format, got, want)
+		}
+
+		destination := filepath.Join(dir, "extracted-"+format)
+		out, err = callFn(t, f, "archive.extract", map[string]any{"source": archive, "destination": destination})
+		if err != nil {
+			t.Fatalf("%s: extract failed: %v", format, err)
+		}
+		if got := manifestNames(t, out); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: extracted entries = %v, want %v", format, got, want)
+		}
+		if content, _ := os.ReadFile(filepath.Join(destination, "link")); string(content) != "aaa" {
+			t.Errorf("%s: expected the symlink to be restored, got %q", format, content)
+		}
+
+		if _, err := callFn(t, f, "archive.extract", map[string]any{"source": archive, "destination": destination}); err == nil {
+			t.Errorf("%s: expected existing files to fail without overwrite", format)
+		}
+		out, err = callFn(t, f, "archive.extract", map[string]any{"source": archive, "destination": destination, "overwrite": true, "include": []string{"*.log"}})
+		if err != nil {
+			t.Fatalf("%s: extract with overwrite failed: %v", format, err)
+		}
+		if got, want := manifestNames(t, out), []string{"a.log", "sub", "sub/c.log"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: included entries = %v, want %v", format, got, want)
+		}
+	}
+}
+
+func TestArchiveExtractTraversal(t *testing.T) {
+	tests := []struct {
+		name   string
+		header tar.Header
+	}{
+		{"parent", tar.Header{Name: "../evil.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 4}},
+		{"nested parent", tar.Header{Name: "a/../../evil.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 4}},
+		{"absolute symlink", tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"}},
+		{"relative symlink", tar.Header{Name: "a/link", Typeflag: tar.TypeSymlink, Linkname: "../../outside"}},
+	}
+
+	dir := t.TempDir()
+	f := New("test")
+	for _, tt := range tests {
+		archive := filepath.Join(dir, tt.name+".tar")
+		file, _ := os.Create(archive)
+		tw := tar.NewWriter(file)
+		tw.WriteHeader(&tt.header)
+		if tt.header.Size > 0 {
+			tw.Write([]byte("evil"))
+		}
+		tw.Close()
+		file.Close()
+
+		destination := filepath.Join(dir, "out", tt.name)
+		_, err := callFn(t, f, "archive.extract", map[string]any{"source": archive, "destination": destination})
+		if err == nil || !strings.Contains(err.Error(), "outside of the destination") && !strings.Contains(err.Error(), "escapes the destination") {
+			t.Errorf("%s: expected the entry to be rejected, got %v", tt.name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out", "evil.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected no file to be written outside of the destination")
+	}
+}
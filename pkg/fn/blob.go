@@ -0,0 +1,253 @@
+package fn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// BlobPrefix marks a string value as a reference into the blob store
+const BlobPrefix = "blob://"
+
+// BlobStore holds binary values of a run outside of the JSON store
+type BlobStore interface {
+	// Put stores the content of the reader and returns its reference
+	Put(r io.Reader) (string, error)
+	// Open returns a reader for the referenced blob and its size
+	Open(ref string) (io.ReadSeekCloser, int64, error)
+	// Close releases all blobs of the store
+	Close() error
+}
+
+// BlobOptions configures the blob store of a run
+type BlobOptions struct {
+	Backend      string `json:"backend,omitempty" yaml:"backend,omitempty"`               // memory (default) or file
+	Dir          string `json:"dir,omitempty" yaml:"dir,omitempty"`                       // parent directory for the file backend, defaults to the OS temp dir
+	MaxSize      int64  `json:"max_size,omitempty" yaml:"max_size,omitempty"`             // maximum size of a single blob in bytes, 0 is unlimited
+	MaxTotalSize int64  `json:"max_total_size,omitempty" yaml:"max_total_size,omitempty"` // maximum size of all blobs in bytes, 0 is unlimited
+}
+
+// NewBlobStore creates a blob store for the given options
+func NewBlobStore(opts BlobOptions) (BlobStore, error) {
+	switch opts.Backend {
+	case "", "memory":
+		s := &memoryBlobStore{blobs: make(map[string][]byte)}
+		s.maxSize, s.maxTotalSize = opts.MaxSize, opts.MaxTotalSize
+		return s, nil
+	case "file":
+		s := &fileBlobStore{parent: opts.Dir}
+		s.maxSize, s.maxTotalSize = opts.MaxSize, opts.MaxTotalSize
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unknown blob backend: %s", opts.Backend)
+	}
+}
+
+// IsBlobRef reports whether a value references a blob. Only the generated
+// format, the prefix followed by 32 hex characters, is a reference, other
+// strings starting with the prefix are plain values.
+func IsBlobRef(value string) bool {
+	id, ok := strings.CutPrefix(value, BlobPrefix)
+	if !ok || len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+func newBlobRef() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return BlobPrefix + hex.EncodeToString(b)
+}
+
+func blobId(ref string) (string, error) {
+	if !IsBlobRef(ref) {
+		return "", fmt.Errorf("invalid blob reference: %s", ref)
+	}
+	return strings.TrimPrefix(ref, BlobPrefix), nil
+}
+
+type blobLimits struct {
+	mutex        sync.Mutex
+	maxSize      int64
+	maxTotalSize int64
+	totalSize    int64
+}
+
+// copy writes the reader into w while enforcing the size limits. The
+// written bytes are reserved against the total size under the mutex, so
+// concurrent puts cannot overshoot it together.
+func (l *blobLimits) copy(w io.Writer, r io.Reader) (int64, error) {
+	l.mutex.Lock()
+	if l.maxTotalSize > 0 && l.maxTotalSize-l.totalSize <= 0 {
+		l.mutex.Unlock()
+		return 0, fmt.Errorf("blob store exceeds the total size limit of %d bytes", l.maxTotalSize)
+	}
+	l.mutex.Unlock()
+
+	if l.maxSize > 0 {
+		r = io.LimitReader(r, l.maxSize+1)
+	}
+	n, err := io.Copy(&reservingWriter{w: w, limits: l}, r)
+	if err == nil && l.maxSize > 0 && n > l.maxSize {
+		err = fmt.Errorf("blob exceeds the size limit of %d bytes", l.maxSize)
+	}
+	if err != nil {
+		l.release(n)
+		return n, err
+	}
+	return n, nil
+}
+
+func (l *blobLimits) release(n int64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.totalSize -= n
+}
+
+// reservingWriter adds each write to the total size before passing it on
+type reservingWriter struct {
+	w      io.Writer
+	limits *blobLimits
+}
+
+func (rw *reservingWriter) Write(p []byte) (int, error) {
+	l := rw.limits
+	l.mutex.Lock()
+	if l.maxTotalSize > 0 && l.totalSize+int64(len(p)) > l.maxTotalSize {
+		l.mutex.Unlock()
+		return 0, fmt.Errorf("blob store exceeds the total size limit of %d bytes", l.maxTotalSize)
+	}
+	l.totalSize += int64(len(p))
+	l.mutex.Unlock()
+
+	n, err := rw.w.Write(p)
+	if n < len(p) {
+		l.release(int64(len(p) - n))
+	}
+	return n, err
+}
+
+type memoryBlobStore struct {
+	blobLimits
+	blobs map[string][]byte
+}
+
+func (s *memoryBlobStore) Put(r io.Reader) (string, error) {
+	var buf bytes.Buffer
+	if _, err := s.copy(&buf, r); err != nil {
+		return "", err
+	}
+	ref := newBlobRef()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.blobs[strings.TrimPrefix(ref, BlobPrefix)] = buf.Bytes()
+	return ref, nil
+}
+
+func (s *memoryBlobStore) Open(ref string) (io.ReadSeekCloser, int64, error) {
+	id, err := blobId(ref)
+	if err != nil {
+		return nil, 0, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	b, ok := s.blobs[id]
+	if !ok {
+		return nil, 0, fmt.Errorf("blob not found: %s", ref)
+	}
+	return nopSeekCloser{bytes.NewReader(b)}, int64(len(b)), nil
+}
+
+func (s *memoryBlobStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.blobs = make(map[string][]byte)
+	s.totalSize = 0
+	return nil
+}
+
+type nopSeekCloser struct {
+	io.ReadSeeker
+}
+
+func (nopSeekCloser) Close() error {
+	return nil
+}
+
+type fileBlobStore struct {
+	blobLimits
+	parent string
+	dir    string
+}
+
+func (s *fileBlobStore) Put(r io.Reader) (string, error) {
+	s.mutex.Lock()
+	if s.dir == "" {
+		dir, err := os.MkdirTemp(s.parent, "coda-blobs-")
+		if err != nil {
+			s.mutex.Unlock()
+			return "", fmt.Errorf("failed to create blob directory: %w", err)
+		}
+		s.dir = dir
+	}
+	dir := s.dir
+	s.mutex.Unlock()
+
+	ref := newBlobRef()
+	path := filepath.Join(dir, strings.TrimPrefix(ref, BlobPrefix))
+	file, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if _, err := s.copy(file, r); err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return ref, nil
+}
+
+func (s *fileBlobStore) Open(ref string) (io.ReadSeekCloser, int64, error) {
+	id, err := blobId(ref)
+	if err != nil {
+		return nil, 0, err
+	}
+	s.mutex.Lock()
+	dir := s.dir
+	s.mutex.Unlock()
+	if dir == "" {
+		return nil, 0, fmt.Errorf("blob not found: %s", ref)
+	}
+
+	file, err := os.Open(filepath.Join(dir, id))
+	if err != nil {
+		return nil, 0, fmt.Errorf("blob not found: %s", ref)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, info.Size(), nil
+}
+
+func (s *fileBlobStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.dir == "" {
+		return nil
+	}
+	err := os.RemoveAll(s.dir)
+	s.dir = ""
+	s.totalSize = 0
+	return err
+}
@@ -0,0 +1,99 @@
+package fn
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	ConnectionS3    = "s3"
+	ConnectionHTTP  = "http"
+	ConnectionSMTP  = "smtp"
+	ConnectionAI    = "ai"
+	ConnectionQueue = "queue"
+)
+
+// connectionParameter references a connection of the coda settings
+var connectionParameter = FnParameter{Name: "connection", Description: "The name of the connection providing defaults for the params", Mandatory: false}
+
+// connectionActions are the action prefixes allowed to reference a connection type
+var connectionActions = map[string][]string{
+	ConnectionS3:    {"s3."},
+	ConnectionHTTP:  {"http.", "graphql."},
+	ConnectionSMTP:  {"message.email"},
+	ConnectionAI:    {"ai."},
+	ConnectionQueue: {"queue."},
+}
+
+// Connection is a named set of params shared by all operations referencing
+// it with `connection`. Params of the operation take precedence.
+type Connection struct {
+	Type   string          `json:"type" yaml:"type"`                         // s3, http, smtp, ai or queue
+	Params json.RawMessage `json:"params,omitempty" yaml:"params,omitempty"` // the params of the referencing actions
+}
+
+// SetConnection creates or replaces a named connection for the run. HTTP
+// connections are registered as HTTP session of the same name.
+func (f *Fn) SetConnection(name string, conn Connection) error {
+	if _, ok := connectionActions[conn.Type]; !ok {
+		return fmt.Errorf("connection '%s' has an invalid type: %s", name, conn.Type)
+	}
+	if len(conn.Params) == 0 {
+		conn.Params = json.RawMessage("{}")
+	}
+	if !gjson.ValidBytes(conn.Params) || !gjson.ParseBytes(conn.Params).IsObject() {
+		return fmt.Errorf("connection '%s' params must be an object", name)
+	}
+	if conn.Type == ConnectionHTTP {
+		var params HttpSessionParams
+		if err := json.Unmarshal(conn.Params, &params); err != nil {
+			return fmt.Errorf("invalid params of connection '%s': %w", name, err)
+		}
+		if err := f.SetHttpSession(name, params); err != nil {
+			return err
+		}
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.connections[name] = conn
+	return nil
+}
+
+// ApplyConnection merges the params of the connection referenced by the
+// operation params into them. Params without a connection are returned as is.
+func (f *Fn) ApplyConnection(action string, params json.RawMessage) (json.RawMessage, error) {
+	name := gjson.GetBytes(params, "connection")
+	if !name.Exists() {
+		return params, nil
+	}
+	f.mutex.Lock()
+	conn, ok := f.connections[name.String()]
+	f.mutex.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown connection: %s", name.String())
+	}
+	allowed := false
+	for _, prefix := range connectionActions[conn.Type] {
+		allowed = allowed || strings.HasPrefix(action, prefix)
+	}
+	if !allowed {
+		return nil, fmt.Errorf("%s connection '%s' cannot be used by %s", conn.Type, name.String(), action)
+	}
+
+	merged := map[string]json.RawMessage{}
+	if conn.Type == ConnectionHTTP {
+		// the session holds the params of http connections
+		merged["session"] = json.RawMessage(fmt.Sprintf("%q", name.String()))
+	} else if err := json.Unmarshal(conn.Params, &merged); err != nil {
+		return nil, fmt.Errorf("invalid params of connection '%s': %w", name.String(), err)
+	}
+	if err := json.Unmarshal(params, &merged); err != nil {
+		return nil, fmt.Errorf("invalid params: %w", err)
+	}
+	delete(merged, "connection")
+	return json.Marshal(merged)
+}
@@ -2,18 +2,29 @@ package fn
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/yosev/coda/internal/utils"
 )
 
 type fnFile struct {
+	*Fn
 	category FnCategory
 }
 
 func (f *fnFile) init(fn *Fn) {
+	f.Fn = fn
+
 	fn.register("file.size", &FnEntry{
 		Handler:     f.size,
 		Name:        "File size",
@@ -68,6 +79,7 @@ func (f *fnFile) init(fn *Fn) {
 		Category:    f.category,
 		Parameters: []FnParameter{
 			{Name: "source", Description: "The path of the file to read", Mandatory: true},
+			{Name: "blob", Description: "If true, the content is kept in the blob store and a blob reference is returned", Type: "boolean", Mandatory: false},
 		},
 	})
 	fn.register("file.write", &FnEntry{
@@ -77,7 +89,102 @@ func (f *fnFile) init(fn *Fn) {
 		Category:    f.category,
 		Parameters: []FnParameter{
 			{Name: "destination", Description: "The path of the file to write to", Mandatory: true},
-			{Name: "value", Description: "The value to write", Mandatory: true},
+			{Name: "value", Description: "The value or blob reference to write", Mandatory: true},
+		},
+	})
+	fn.register("file.append", &FnEntry{
+		Handler:     f.append,
+		Name:        "Append file",
+		Description: "Append content to a file, creating it if it does not exist",
+		Category:    f.category,
+		Parameters: []FnParameter{
+			{Name: "destination", Description: "The path of the file to append to", Mandatory: true},
+			{Name: "value", Description: "The value or blob reference to append", Mandatory: true},
+		},
+	})
+	fn.register("file.exists", &FnEntry{
+		Handler:     f.exists,
+		Name:        "File exists",
+		Description: "Check if a file or directory exists",
+		Category:    f.category,
+		Parameters: []FnParameter{
+			{Name: "source", Description: "The path to check", Mandatory: true},
+		},
+	})
+	fn.register("file.stat", &FnEntry{
+		Handler:     f.stat,
+		Name:        "File stat",
+		Description: "Get the mode, owner, times, type and symlink target of a file or directory",
+		Category:    f.category,
+		Parameters: []FnParameter{
+			{Name: "source", Description: "The path of the source", Mandatory: true},
+		},
+	})
+	fn.register("file.list", &FnEntry{
+		Handler:     f.list,
+		Name:        "List files",
+		Description: "List the entries of a directory as an array of {path, name, relative, size, is_dir, modified}",
+		Category:    f.category,
+		Parameters: []FnParameter{
+			{Name: "source", Description: "The path of the directory to list", Mandatory: true},
+			{Name: "recursive", Description: "If true, subdirectories are listed recursively", Type: "boolean", Mandatory: false},
+			{Name: "include", Description: "Glob patterns of entries to include, patterns without a slash match the name", Type: "array", Mandatory: false},
+			{Name: "exclude", Description: "Glob patterns of entries to exclude, patterns without a slash match the name", Type: "array", Mandatory: false},
+			{Name: "invisible_files", Description: "If true, entries starting with a dot are listed", Type: "boolean", Mandatory: false},
+			{Name: "type", Description: "The type of entries to list (default all)", Enum: []string{"all", "file", "dir"}, Mandatory: false},
+		},
+	})
+	fn.register("file.glob", &FnEntry{
+		Handler:     f.glob,
+		Name:        "Glob files",
+		Description: "Find files matching a glob pattern, ** matches any number of directories",
+		Category:    f.category,
+		Parameters: []FnParameter{
+			{Name: "pattern", Description: "The glob pattern (e.g. src/**/*.go)", Mandatory: true},
+			{Name: "exclude", Description: "Glob patterns of entries to exclude, patterns without a slash match the name", Type: "array", Mandatory: false},
+			{Name: "invisible_files", Description: "If true, entries starting with a dot are matched", Type: "boolean", Mandatory: false},
+			{Name: "type", Description: "The type of entries to match (default file)", Enum: []string{"all", "file", "dir"}, Mandatory: false},
+		},
+	})
+	fn.register("file.chmod", &FnEntry{
+		Handler:     f.chmod,
+		Name:        "Change file mode",
+		Description: "Change the permissions of a file or directory",
+		Category:    f.category,
+		Parameters: []FnParameter{
+			{Name: "source", Description: "The path of the file", Mandatory: true},
+			{Name: "mode", Description: "The permissions in octal notation (e.g. 0644)", Mandatory: true},
+		},
+	})
+	fn.register("file.touch", &FnEntry{
+		Handler:     f.touch,
+		Name:        "Touch file",
+		Description: "Create an empty file if it does not exist and update its modify date",
+		Category:    f.category,
+		Parameters: []FnParameter{
+			{Name: "destination", Description: "The path of the file", Mandatory: true},
+			{Name: "modified", Description: "The modify date as unix timestamp in milliseconds (default now)", Type: "integer", Mandatory: false},
+		},
+	})
+	fn.register("dir.create", &FnEntry{
+		Handler:     f.dirCreate,
+		Name:        "Create directory",
+		Description: "Create a directory",
+		Category:    f.category,
+		Parameters: []FnParameter{
+			{Name: "destination", Description: "The path of the directory to create", Mandatory: true},
+			{Name: "recursive", Description: "If true, missing parent directories are created and an existing directory is no error", Type: "boolean", Mandatory: false},
+			{Name: "mode", Description: "The permissions in octal notation (default 0755)", Mandatory: false},
+		},
+	})
+	fn.register("dir.delete", &FnEntry{
+		Handler:     f.dirDelete,
+		Name:        "Delete directory",
+		Description: "Delete a directory",
+		Category:    f.category,
+		Parameters: []FnParameter{
+			{Name: "source", Description: "The path of the directory to delete", Mandatory: true},
+			{Name: "recursive", Description: "If true, the directory is deleted with all of its content, otherwise it must be empty", Type: "boolean", Mandatory: false},
 		},
 	})
 }
@@ -88,6 +195,9 @@ type sourceFileParams struct {
 
 func (f *fnFile) size(j json.RawMessage) (json.RawMessage, error) {
 	return utils.HandleJSON(j, func(params *sourceFileParams) (json.RawMessage, error) {
+		if err := f.checkRead(params.Source); err != nil {
+			return nil, err
+		}
 		fileInfo, err := os.Stat(params.Source)
 		if err != nil {
 			return nil, err
@@ -99,6 +209,9 @@ func (f *fnFile) size(j json.RawMessage) (json.RawMessage, error) {
 
 func (f *fnFile) modified(j json.RawMessage) (json.RawMessage, error) {
 	return utils.HandleJSON(j, func(params *sourceFileParams) (json.RawMessage, error) {
+		if err := f.checkRead(params.Source); err != nil {
+			return nil, err
+		}
 		fileInfo, err := os.Stat(params.Source)
 		if err != nil {
 			return nil, err
@@ -110,6 +223,9 @@ func (f *fnFile) modified(j json.RawMessage) (json.RawMessage, error) {
 
 func (f *fnFile) delete(j json.RawMessage) (json.RawMessage, error) {
 	return utils.HandleJSON(j, func(params *sourceFileParams) (json.RawMessage, error) {
+		if err := f.checkWrite(params.Source); err != nil {
+			return nil, err
+		}
 		os.Remove(params.Source)
 
 		return nil, nil
@@ -123,6 +239,12 @@ type copyMoveParams struct {
 
 func (f *fnFile) copy(j json.RawMessage) (json.RawMessage, error) {
 	return utils.HandleJSON(j, func(params *copyMoveParams) (json.RawMessage, error) {
+		if err := f.checkRead(params.Source); err != nil {
+			return nil, err
+		}
+		if err := f.checkWrite(params.Destination); err != nil {
+			return nil, err
+		}
 		sourceFileStat, err := os.Stat(params.Source)
 		if err != nil {
 			return nil, err
@@ -154,6 +276,9 @@ func (f *fnFile) copy(j json.RawMessage) (json.RawMessage, error) {
 
 func (f *fnFile) move(j json.RawMessage) (json.RawMessage, error) {
 	return utils.HandleJSON(j, func(params *copyMoveParams) (json.RawMessage, error) {
+		if err := f.checkWrite(params.Source, params.Destination); err != nil {
+			return nil, err
+		}
 		err := os.Rename(params.Source, params.Destination)
 		if err != nil {
 			return nil, err
@@ -162,8 +287,30 @@ func (f *fnFile) move(j json.RawMessage) (json.RawMessage, error) {
 	})
 }
 
+type readFileParams struct {
+	Source string `json:"source" yaml:"source"`
+	Blob   bool   `json:"blob,omitempty" yaml:"blob,omitempty"`
+}
+
 func (f *fnFile) read(j json.RawMessage) (json.RawMessage, error) {
-	return utils.HandleJSON(j, func(params *sourceFileParams) (json.RawMessage, error) {
+	return utils.HandleJSON(j, func(params *readFileParams) (json.RawMessage, error) {
+		if err := f.checkRead(params.Source); err != nil {
+			return nil, err
+		}
+		if params.Blob {
+			file, err := os.Open(params.Source)
+			if err != nil {
+				return nil, err
+			}
+			defer file.Close()
+
+			ref, err := f.blobs.Put(file)
+			if err != nil {
+				return nil, err
+			}
+			return utils.ReturnRaw(ref), nil
+		}
+
 		file, err := os.ReadFile(params.Source)
 		if err != nil {
 			return nil, err
@@ -180,10 +327,452 @@ type writeFileParams struct {
 
 func (f *fnFile) write(j json.RawMessage) (json.RawMessage, error) {
 	return utils.HandleJSON(j, func(params *writeFileParams) (json.RawMessage, error) {
-		err := os.WriteFile(params.Destination, []byte(params.Value), 0644)
+		if err := f.checkWrite(params.Destination); err != nil {
+			return nil, err
+		}
+		blob, _, ok, err := f.openBlob(params.Value)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			defer blob.Close()
+			file, err := os.OpenFile(params.Destination, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := io.Copy(file, blob); err != nil {
+				file.Close()
+				return nil, err
+			}
+			// a failed flush is only reported by close
+			if err := file.Close(); err != nil {
+				return nil, err
+			}
+			return utils.ReturnRaw(params.Destination), nil
+		}
+
+		err = os.WriteFile(params.Destination, []byte(params.Value), 0644)
+		if err != nil {
+			return nil, err
+		}
+		return utils.ReturnRaw(params.Destination), nil
+	})
+}
+
+func (f *fnFile) append(j json.RawMessage) (json.RawMessage, error) {
+	return utils.HandleJSON(j, func(params *writeFileParams) (json.RawMessage, error) {
+		if err := f.checkWrite(params.Destination); err != nil {
+			return nil, err
+		}
+		file, err := os.OpenFile(params.Destination, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		blob, _, ok, err := f.openBlob(params.Value)
 		if err != nil {
 			return nil, err
 		}
+		if ok {
+			defer blob.Close()
+			if _, err := io.Copy(file, blob); err != nil {
+				return nil, err
+			}
+		} else if _, err := file.WriteString(params.Value); err != nil {
+			return nil, err
+		}
+		if err := file.Close(); err != nil {
+			return nil, err
+		}
 		return utils.ReturnRaw(params.Destination), nil
 	})
 }
+
+func (f *fnFile) exists(j json.RawMessage) (json.RawMessage, error) {
+	return utils.HandleJSON(j, func(params *sourceFileParams) (json.RawMessage, error) {
+		if err := f.checkRead(params.Source); err != nil {
+			return nil, err
+		}
+		_, err := os.Lstat(params.Source)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		return utils.ReturnRaw(err == nil), nil
+	})
+}
+
+// fileStat describes a file, times are unix timestamps in milliseconds
+type fileStat struct {
+	Path          string `json:"path"`
+	Name          string `json:"name"`
+	Size          int64  `json:"size"`
+	Mode          string `json:"mode"`
+	Perm          string `json:"perm"`
+	IsDir         bool   `json:"is_dir"`
+	IsSymlink     bool   `json:"is_symlink"`
+	SymlinkTarget string `json:"symlink_target,omitempty"`
+	Modified      int64  `json:"modified"`
+	Accessed      int64  `json:"accessed,omitempty"`
+	Changed       int64  `json:"changed,omitempty"`
+	Uid           *int   `json:"uid,omitempty"`
+	Gid           *int   `json:"gid,omitempty"`
+	Owner         string `json:"owner,omitempty"`
+	Group         string `json:"group,omitempty"`
+}
+
+func (f *fnFile) stat(j json.RawMessage) (json.RawMessage, error) {
+	return utils.HandleJSON(j, func(params *sourceFileParams) (json.RawMessage, error) {
+		if err := f.checkRead(params.Source); err != nil {
+			return nil, err
+		}
+		info, err := os.Lstat(params.Source)
+		if err != nil {
+			return nil, err
+		}
+
+		stat := fileStat{
+			Path:      params.Source,
+			Name:      info.Name(),
+			Size:      info.Size(),
+			Mode:      info.Mode().String(),
+			Perm:      fmt.Sprintf("%04o", info.Mode().Perm()),
+			IsDir:     info.IsDir(),
+			IsSymlink: info.Mode()&fs.ModeSymlink != 0,
+			Modified:  info.ModTime().UnixMilli(),
+		}
+		if stat.IsSymlink {
+			if stat.SymlinkTarget, err = os.Readlink(params.Source); err != nil {
+				return nil, err
+			}
+		}
+		statDetails(info, &stat)
+
+		return utils.ReturnRaw(stat), nil
+	})
+}
+
+// fileEntry is a single entry returned by file.list and file.glob
+type fileEntry struct {
+	Path     string `json:"path"`
+	Name     string `json:"name"`
+	Relative string `json:"relative"`
+	Size     int64  `json:"size"`
+	IsDir    bool   `json:"is_dir"`
+	Modified int64  `json:"modified"`
+}
+
+// walkOptions filter the entries collected by walkEntries
+type walkOptions struct {
+	depth          int // maximum depth below the root, 0 is unlimited
+	match          func(relative string) bool
+	exclude        []string
+	invisibleFiles bool
+	entryType      string
+}
+
+// walkEntries collects the entries below root in lexical order
+func walkEntries(root string, opts walkOptions) ([]fileEntry, error) {
+	entries := []fileEntry{}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		skip := func() error {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !opts.invisibleFiles && strings.HasPrefix(d.Name(), ".") {
+			return skip() // Skip invisible files
+		}
+		for _, pattern := range opts.exclude {
+			if matchPattern(pattern, rel) {
+				return skip()
+			}
+		}
+		depth := strings.Count(rel, "/") + 1
+		if opts.depth > 0 && depth > opts.depth {
+			return skip()
+		}
+
+		if (opts.entryType == "file" && d.IsDir()) || (opts.entryType == "dir" && !d.IsDir()) {
+			return nil
+		}
+		if opts.match != nil && !opts.match(rel) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, fileEntry{
+			Path:     path,
+			Name:     d.Name(),
+			Relative: rel,
+			Size:     info.Size(),
+			IsDir:    d.IsDir(),
+			Modified: info.ModTime().UnixMilli(),
+		})
+		return nil
+	})
+	return entries, err
+}
+
+type listParams struct {
+	Source         string   `json:"source" yaml:"source"`
+	Recursive      bool     `json:"recursive,omitempty" yaml:"recursive,omitempty"`
+	Include        []string `json:"include,omitempty" yaml:"include,omitempty"`
+	Exclude        []string `json:"exclude,omitempty" yaml:"exclude,omitempty"`
+	InvisibleFiles bool     `json:"invisible_files,omitempty" yaml:"invisible_files,omitempty"` // Whether to include files starting with a dot (.)
+	Type           string   `json:"type,omitempty" yaml:"type,omitempty"`
+}
+
+func (f *fnFile) list(j json.RawMessage) (json.RawMessage, error) {
+	return utils.HandleJSON(j, func(params *listParams) (json.RawMessage, error) {
+		if err := f.checkRead(params.Source); err != nil {
+			return nil, err
+		}
+		info, err := os.Stat(params.Source)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("%s is not a directory", params.Source)
+		}
+
+		opts := walkOptions{depth: 1, exclude: params.Exclude, invisibleFiles: params.InvisibleFiles, entryType: params.Type}
+		if params.Recursive {
+			opts.depth = 0
+		}
+		if len(params.Include) > 0 {
+			opts.match = func(rel string) bool {
+				for _, pattern := range params.Include {
+					if matchPattern(pattern, rel) {
+						return true
+					}
+				}
+				return false
+			}
+		}
+
+		entries, err := walkEntries(params.Source, opts)
+		if err != nil {
+			return nil, err
+		}
+		return utils.ReturnRaw(entries), nil
+	})
+}
+
+type globParams struct {
+	Pattern        string   `json:"pattern" yaml:"pattern"`
+	Exclude        []string `json:"exclude,omitempty" yaml:"exclude,omitempty"`
+	InvisibleFiles bool     `json:"invisible_files,omitempty" yaml:"invisible_files,omitempty"` // Whether to include files starting with a dot (.)
+	Type           string   `json:"type,omitempty" yaml:"type,omitempty"`
+}
+
+func (f *fnFile) glob(j json.RawMessage) (json.RawMessage, error) {
+	return utils.HandleJSON(j, func(params *globParams) (json.RawMessage, error) {
+		if params.Pattern == "" {
+			return nil, fmt.Errorf("pattern cannot be empty")
+		}
+		if params.Type == "" {
+			params.Type = "file"
+		}
+
+		// walk from the longest directory prefix without wildcards
+		segments := strings.Split(filepath.ToSlash(params.Pattern), "/")
+		static := 0
+		for static < len(segments)-1 && !strings.ContainsAny(segments[static], "*?[\\") {
+			static++
+		}
+		root := strings.Join(segments[:static], "/")
+		if root == "" && static > 0 {
+			root = "/"
+		} else if root == "" {
+			root = "."
+		}
+		pattern := segments[static:]
+
+		opts := walkOptions{exclude: params.Exclude, invisibleFiles: params.InvisibleFiles, entryType: params.Type}
+		if !slices.Contains(pattern, "**") {
+			opts.depth = len(pattern)
+		}
+		opts.match = func(rel string) bool {
+			return matchSegments(pattern, strings.Split(rel, "/"))
+		}
+
+		if err := f.checkRead(root); err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(root); errors.Is(err, fs.ErrNotExist) {
+			return utils.ReturnRaw([]fileEntry{}), nil
+		}
+		entries, err := walkEntries(filepath.FromSlash(root), opts)
+		if err != nil {
+			return nil, err
+		}
+		return utils.ReturnRaw(entries), nil
+	})
+}
+
+// matchPattern matches a glob pattern against a slash separated relative
+// path. Patterns without a slash are matched against the name only.
+func matchPattern(pattern, rel string) bool {
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(rel))
+		return ok
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(rel, "/"))
+}
+
+// matchSegments matches path segments, a ** segment matches any number of segments
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// parseFileMode parses permissions in octal notation
+func parseFileMode(mode string, fallback fs.FileMode) (fs.FileMode, error) {
+	if mode == "" {
+		return fallback, nil
+	}
+	m, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || m > 0o7777 {
+		return 0, fmt.Errorf("invalid file mode: %s", mode)
+	}
+	return fs.FileMode(m), nil
+}
+
+type chmodParams struct {
+	Source string `json:"source" yaml:"source"`
+	Mode   string `json:"mode" yaml:"mode"`
+}
+
+func (f *fnFile) chmod(j json.RawMessage) (json.RawMessage, error) {
+	return utils.HandleJSON(j, func(params *chmodParams) (json.RawMessage, error) {
+		if err := f.checkWrite(params.Source); err != nil {
+			return nil, err
+		}
+		if params.Mode == "" {
+			return nil, fmt.Errorf("mode cannot be empty")
+		}
+		mode, err := parseFileMode(params.Mode, 0)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Chmod(params.Source, mode); err != nil {
+			return nil, err
+		}
+		return utils.ReturnRaw(params.Source), nil
+	})
+}
+
+type touchParams struct {
+	Destination string `json:"destination" yaml:"destination"`
+	Modified    int64  `json:"modified,omitempty" yaml:"modified,omitempty"`
+}
+
+func (f *fnFile) touch(j json.RawMessage) (json.RawMessage, error) {
+	return utils.HandleJSON(j, func(params *touchParams) (json.RawMessage, error) {
+		if err := f.checkWrite(params.Destination); err != nil {
+			return nil, err
+		}
+		file, err := os.OpenFile(params.Destination, os.O_WRONLY|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		file.Close()
+
+		modified := time.Now()
+		if params.Modified > 0 {
+			modified = time.UnixMilli(params.Modified)
+		}
+		if err := os.Chtimes(params.Destination, modified, modified); err != nil {
+			return nil, err
+		}
+		return utils.ReturnRaw(params.Destination), nil
+	})
+}
+
+type dirCreateParams struct {
+	Destination string `json:"destination" yaml:"destination"`
+	Recursive   bool   `json:"recursive,omitempty" yaml:"recursive,omitempty"`
+	Mode        string `json:"mode,omitempty" yaml:"mode,omitempty"`
+}
+
+func (f *fnFile) dirCreate(j json.RawMessage) (json.RawMessage, error) {
+	return utils.HandleJSON(j, func(params *dirCreateParams) (json.RawMessage, error) {
+		if err := f.checkWrite(params.Destination); err != nil {
+			return nil, err
+		}
+		mode, err := parseFileMode(params.Mode, 0o755)
+		if err != nil {
+			return nil, err
+		}
+		if params.Recursive {
+			err = os.MkdirAll(params.Destination, mode)
+		} else {
+			err = os.Mkdir(params.Destination, mode)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return utils.ReturnRaw(params.Destination), nil
+	})
+}
+
+type dirDeleteParams struct {
+	Source    string `json:"source" yaml:"source"`
+	Recursive bool   `json:"recursive,omitempty" yaml:"recursive,omitempty"`
+}
+
+func (f *fnFile) dirDelete(j json.RawMessage) (json.RawMessage, error) {
+	return utils.HandleJSON(j, func(params *dirDeleteParams) (json.RawMessage, error) {
+		if err := f.checkWrite(params.Source); err != nil {
+			return nil, err
+		}
+		info, err := os.Lstat(params.Source)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("%s is not a directory", params.Source)
+		}
+		if params.Recursive {
+			err = os.RemoveAll(params.Source)
+		} else {
+			err = os.Remove(params.Source)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return utils.ReturnRaw(params.Source), nil
+	})
+}
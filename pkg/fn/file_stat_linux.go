@@ -0,0 +1,28 @@
+package fn
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// statDetails adds owner and access/change times to the stat
+func statDetails(info os.FileInfo, stat *fileStat) {
+	sys, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+	stat.Accessed = time.Unix(sys.Atim.Unix()).UnixMilli()
+	stat.Changed = time.Unix(sys.Ctim.Unix()).UnixMilli()
+
+	uid, gid := int(sys.Uid), int(sys.Gid)
+	stat.Uid, stat.Gid = &uid, &gid
+	if u, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+		stat.Owner = u.Username
+	}
+	if g, err := user.LookupGroupId(strconv.Itoa(gid)); err == nil {
+		stat.Group = g.Name
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package fn
+
+import "os"
+
+// statDetails adds owner and access/change times to the stat, which are
+// only available on linux
+func statDetails(info os.FileInfo, stat *fileStat) {}
@@ -0,0 +1,129 @@
+package fn
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func callFn(t *testing.T, f *Fn, action string, params map[string]any) (json.RawMessage, error) {
+	t.Helper()
+	b, err := json.Marshal(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f.GetFns()[action].Handler(b)
+}
+
+func relatives(out json.RawMessage) []string {
+	paths := []string{}
+	for _, entry := range gjson.ParseBytes(out).Array() {
+		paths = append(paths, entry.Get("relative").String())
+	}
+	return paths
+}
+
+func TestFileListAndGlob(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.go", "b.txt", ".hidden", "sub/c.go", "sub/deep/d.go", "sub/.git/config", "vendor/e.go"} {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte(name), 0644)
+	}
+	f := New("test")
+
+	tests := []struct {
+		action string
+		params map[string]any
+		want   []string
+	}{
+		{"file.list", map[string]any{"source": dir}, []string{"a.go", "b.txt", "sub", "vendor"}},
+		{"file.list", map[string]any{"source": dir, "type": "file", "invisible_files": true}, []string{".hidden", "a.go", "b.txt"}},
+		{"file.list", map[string]any{"source": dir, "recursive": true, "include": []string{"*.go"}, "exclude": []string{"vendor"}}, []string{"a.go", "sub/c.go", "sub/deep/d.go"}},
+		{"file.list", map[string]any{"source": dir, "recursive": true, "type": "dir"}, []string{"sub", "sub/deep", "vendor"}},
+		{"file.glob", map[string]any{"pattern": filepath.Join(dir, "*.go")}, []string{"a.go"}},
+		{"file.glob", map[string]any{"pattern": filepath.Join(dir, "**", "*.go"), "exclude": []string{"vendor/**"}}, []string{"a.go", "sub/c.go", "sub/deep/d.go"}},
+		{"file.glob", map[string]any{"pattern": filepath.Join(dir, "sub", "*", "*.go")}, []string{"deep/d.go"}},
+		{"file.glob", map[string]any{"pattern": filepath.Join(dir, "**", "config"), "invisible_files": true}, []string{"sub/.git/config"}},
+		{"file.glob", map[string]any{"pattern": filepath.Join(dir, "missing", "*.go")}, []string{}},
+	}
+	for _, tt := range tests {
+		out, err := callFn(t, f, tt.action, tt.params)
+		if err != nil {
+			t.Errorf("%s %v: unexpected error: %v", tt.action, tt.params, err)
+			continue
+		}
+		if got := relatives(out); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s %v = %v, want %v", tt.action, tt.params, got, tt.want)
+		}
+	}
+}
+
+func TestFileOperations(t *testing.T) {
+	dir := t.TempDir()
+	f := New("test")
+	nested := filepath.Join(dir, "a", "b")
+	file := filepath.Join(nested, "file.txt")
+
+	if _, err := callFn(t, f, "dir.create", map[string]any{"destination": nested}); err == nil {
+		t.Errorf("expected dir.create without recursive to fail for missing parents")
+	}
+	if _, err := callFn(t, f, "dir.create", map[string]any{"destination": nested, "recursive": true, "mode": "0750"}); err != nil {
+		t.Fatalf("dir.create failed: %v", err)
+	}
+	for _, value := range []string{"hello", " world"} {
+		if _, err := callFn(t, f, "file.append", map[string]any{"destination": file, "value": value}); err != nil {
+			t.Fatalf("file.append failed: %v", err)
+		}
+	}
+	if content, _ := os.ReadFile(file); string(content) != "hello world" {
+		t.Errorf("appended content = %q", content)
+	}
+
+	// only generated blob references are read from the blob store
+	literal := filepath.Join(dir, "literal.txt")
+	if _, err := callFn(t, f, "file.write", map[string]any{"destination": literal, "value": "blob://not-a-reference"}); err != nil {
+		t.Fatalf("file.write of a blob:// literal failed: %v", err)
+	}
+	if content, _ := os.ReadFile(literal); string(content) != "blob://not-a-reference" {
+		t.Errorf("written content = %q", content)
+	}
+
+	if _, err := callFn(t, f, "file.chmod", map[string]any{"source": file, "mode": "0600"}); err != nil {
+		t.Fatalf("file.chmod failed: %v", err)
+	}
+	if _, err := callFn(t, f, "file.touch", map[string]any{"destination": file, "modified": 1000}); err != nil {
+		t.Fatalf("file.touch failed: %v", err)
+	}
+	link := filepath.Join(dir, "link")
+	os.Symlink(file, link)
+
+	out, err := callFn(t, f, "file.stat", map[string]any{"source": file})
+	if err != nil {
+		t.Fatalf("file.stat failed: %v", err)
+	}
+	if perm, modified, size := gjson.GetBytes(out, "perm").String(), gjson.GetBytes(out, "modified").Int(), gjson.GetBytes(out, "size").Int(); perm != "0600" || modified != 1000 || size != 11 {
+		t.Errorf("unexpected stat: %s", out)
+	}
+	out, err = callFn(t, f, "file.stat", map[string]any{"source": link})
+	if err != nil || !gjson.GetBytes(out, "is_symlink").Bool() || gjson.GetBytes(out, "symlink_target").String() != file {
+		t.Errorf("unexpected symlink stat: %s, %v", out, err)
+	}
+
+	if out, _ := callFn(t, f, "file.exists", map[string]any{"source": file}); string(out) != "true" {
+		t.Errorf("expected the file to exist")
+	}
+	if _, err := callFn(t, f, "dir.delete", map[string]any{"source": filepath.Join(dir, "a")}); err == nil {
+		t.Errorf("expected dir.delete without recursive to fail for a non-empty directory")
+	}
+	if _, err := callFn(t, f, "dir.delete", map[string]any{"source": filepath.Join(dir, "a"), "recursive": true}); err != nil {
+		t.Fatalf("dir.delete failed: %v", err)
+	}
+	if out, _ := callFn(t, f, "file.exists", map[string]any{"source": file}); string(out) != "false" {
+		t.Errorf("expected the file to be deleted")
+	}
+}
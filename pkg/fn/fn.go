@@ -2,6 +2,13 @@ package fn
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/go-resty/resty/v2"
+	"github.com/tmc/langchaingo/llms"
 )
 
 type FnEntry struct {
@@ -41,14 +48,120 @@ type fnHandler interface {
 }
 
 type Fn struct {
-	version string
-	fns     map[string]*FnEntry
+	version  string
+	fns      map[string]*FnEntry
+	blobs    BlobStore
+	sandbox  *Sandbox
+	executor Executor
+
+	mutex        sync.Mutex
+	httpSessions map[string]*resty.Client
+	connections  map[string]Connection
+	s3Clients    map[s3Connection]*s3.Client
+	aiClients    map[aiModelParams]llms.Model
+}
+
+// Executor runs an action on behalf of another action, e.g. the tool calls
+// of an agent, applying the same checks as operations of the run
+type Executor func(action string, params json.RawMessage) (json.RawMessage, error)
+
+// ResultError is returned by handlers that fail but still produce a result
+// describing the failure. The engine stores the result before following the
+// onFail branch of the operation.
+type ResultError struct {
+	Err    error
+	Result json.RawMessage
+}
+
+func (e *ResultError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ResultError) Unwrap() error {
+	return e.Err
 }
 
 func (f *Fn) GetFns() map[string]*FnEntry {
 	return f.fns
 }
 
+// SetBlobStore replaces the blob store used by the handlers
+func (f *Fn) SetBlobStore(store BlobStore) {
+	f.blobs = store
+}
+
+// Blobs returns the blob store used by the handlers
+func (f *Fn) Blobs() BlobStore {
+	return f.blobs
+}
+
+// SetSandbox restricts the local paths of actions, nil allows all paths
+func (f *Fn) SetSandbox(sandbox *Sandbox) {
+	f.sandbox = sandbox
+}
+
+// SetExecutor sets the executor running nested actions, without one their
+// handlers are called directly
+func (f *Fn) SetExecutor(executor Executor) {
+	f.executor = executor
+}
+
+// execute runs a nested action through the executor
+func (f *Fn) execute(action string, params json.RawMessage) (json.RawMessage, error) {
+	if f.executor != nil {
+		return f.executor(action, params)
+	}
+	entry, ok := f.fns[action]
+	if !ok {
+		return nil, fmt.Errorf("unknown action: %s", action)
+	}
+	return entry.Handler(params)
+}
+
+// checkRead fails if one of the paths may not be read in the sandbox
+func (f *Fn) checkRead(paths ...string) error {
+	for _, path := range paths {
+		if err := f.sandbox.Check(path, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkWrite fails if one of the paths may not be written in the sandbox
+func (f *Fn) checkWrite(paths ...string) error {
+	for _, path := range paths {
+		if err := f.sandbox.Check(path, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close releases all resources held for the run, including blobs, HTTP sessions and cached clients
+func (f *Fn) Close() error {
+	f.mutex.Lock()
+	for _, client := range f.httpSessions {
+		client.GetClient().CloseIdleConnections()
+	}
+	f.httpSessions = make(map[string]*resty.Client)
+	f.connections = make(map[string]Connection)
+	f.s3Clients = make(map[s3Connection]*s3.Client)
+	f.aiClients = make(map[aiModelParams]llms.Model)
+	f.mutex.Unlock()
+
+	return f.blobs.Close()
+}
+
+// openBlob opens a blob referenced by value, ok is false if value is not a blob reference
+func (f *Fn) openBlob(value string) (r io.ReadSeekCloser, size int64, ok bool, err error) {
+	if !IsBlobRef(value) {
+		return nil, 0, false, nil
+	}
+	r, size, err = f.blobs.Open(value)
+	return r, size, true, err
+}
+
 func (f *Fn) register(name string, entry *FnEntry) {
 	if _, exists := f.fns[name]; exists {
 		panic("function already registered: " + name)
@@ -57,18 +170,35 @@ func (f *Fn) register(name string, entry *FnEntry) {
 }
 
 func New(version string) *Fn {
-	f := &Fn{version: version, fns: make(map[string]*FnEntry)}
+	blobs, err := NewBlobStore(BlobOptions{})
+	if err != nil {
+		panic("failed to create blob store: " + err.Error())
+	}
+	f := &Fn{
+		version:      version,
+		fns:          make(map[string]*FnEntry),
+		blobs:        blobs,
+		httpSessions: make(map[string]*resty.Client),
+		connections:  make(map[string]Connection),
+		s3Clients:    make(map[s3Connection]*s3.Client),
+		aiClients:    make(map[aiModelParams]llms.Model),
+	}
 
 	// setup fn handlers
 	var h = []fnHandler{
-		&fnHttp{category: FnCategoryAI},
+		// http.* was listed under AI before, blacklisting HTTP disables it along with graphql.*
+		&fnHttp{category: FnCategoryHTTP},
+		&fnGraphql{category: FnCategoryHTTP},
 		&fnAi{category: FnCategoryAI},
+		&fnVector{category: FnCategoryAI},
 		&fnFile{category: FnCategoryFile},
 		&fnS3{category: FnCategoryFile},
+		&fnArchive{category: FnCategoryFile},
 		&fnHash{category: FnCategoryHash},
 		&fnIo{category: FnCategoryIO},
 		&fnMath{category: FnCategoryMath},
 		&fnMessage{category: FnCategoryMessaging},
+		&fnQueue{category: FnCategoryMessaging},
 		&fnOs{category: FnCategoryOS},
 		&fnTime{category: FnCategoryTime},
 		&fnString{category: FnCategoryString},
@@ -0,0 +1,116 @@
+package fn
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/yosev/coda/internal/utils"
+)
+
+type fnGraphql struct {
+	*Fn
+	category FnCategory
+}
+
+func (f *fnGraphql) init(fn *Fn) {
+	f.Fn = fn
+
+	fn.register("graphql.query", &FnEntry{
+		Handler:     f.graphqlQuery,
+		Name:        "GraphQL Query",
+		Description: "Performs a GraphQL query or mutation, GraphQL errors fail the operation and are stored for the onFail branch",
+		Category:    f.category,
+		Parameters: append([]FnParameter{
+			{Name: "endpoint", Description: "The url of the GraphQL endpoint", Mandatory: true},
+			{Name: "query", Description: "The GraphQL query or mutation", Mandatory: true},
+			{Name: "variables", Description: "The variables of the query", Type: "object", Mandatory: false},
+			{Name: "operation_name", Description: "The operation to execute if the query contains several", Mandatory: false},
+			{Name: "headers", Description: "The Headers to use", Type: "object", Mandatory: false},
+		}, httpClientParameters...),
+	})
+}
+
+type graphqlParams struct {
+	HttpClientParams
+	Endpoint      string            `json:"endpoint" yaml:"endpoint"`
+	Query         string            `json:"query" yaml:"query"`
+	Variables     map[string]any    `json:"variables,omitempty" yaml:"variables,omitempty"`
+	OperationName string            `json:"operation_name,omitempty" yaml:"operation_name,omitempty"`
+	Headers       map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+}
+
+// graphqlRequest omits unset fields, servers look up an operation named ""
+type graphqlRequest struct {
+	Query         string         `json:"query"`
+	Variables     map[string]any `json:"variables,omitempty"`
+	OperationName string         `json:"operationName,omitempty"`
+}
+
+type graphqlResponse struct {
+	Data       json.RawMessage `json:"data,omitempty"`
+	Errors     []graphqlError  `json:"errors,omitempty"`
+	Extensions json.RawMessage `json:"extensions,omitempty"`
+}
+
+type graphqlError struct {
+	Message    string          `json:"message"`
+	Locations  json.RawMessage `json:"locations,omitempty"`
+	Path       json.RawMessage `json:"path,omitempty"`
+	Extensions json.RawMessage `json:"extensions,omitempty"`
+}
+
+func (f *fnGraphql) graphqlQuery(j json.RawMessage) (json.RawMessage, error) {
+	return utils.HandleJSON(j, func(params *graphqlParams) (json.RawMessage, error) {
+		if params.Query == "" {
+			return nil, fmt.Errorf("query cannot be empty")
+		}
+
+		client, err := f.httpClient(&params.HttpClientParams)
+		if err != nil {
+			return nil, err
+		}
+		request := f.newHttpRequest(client, params.Headers)
+		request.SetHeader("Content-Type", "application/json")
+		request.SetHeader("Accept", "application/graphql-response+json, application/json")
+		request.SetBody(graphqlRequest{
+			Query:         params.Query,
+			Variables:     params.Variables,
+			OperationName: params.OperationName,
+		})
+		params.apply(request)
+		cancel := params.applyTimeout(request)
+		defer cancel()
+
+		response, err := request.Post(params.Endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("error making GraphQL request: %w", err)
+		}
+
+		// servers may answer GraphQL errors with a non-2xx status, so the
+		// body is inspected before the status
+		var result graphqlResponse
+		if err := json.Unmarshal(response.Body(), &result); err != nil || (result.Data == nil && result.Errors == nil) {
+			if err := params.checkStatus(response.StatusCode(), response.Body()); err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("invalid GraphQL response: %s", string(response.Body()))
+		}
+
+		if len(result.Errors) > 0 {
+			messages := make([]string, len(result.Errors))
+			for i, e := range result.Errors {
+				messages[i] = e.Message
+			}
+			return nil, &ResultError{
+				Err:    fmt.Errorf("GraphQL request failed: %s", strings.Join(messages, "; ")),
+				Result: utils.ReturnRaw(result),
+			}
+		}
+		if err := params.checkStatus(response.StatusCode(), response.Body()); err != nil {
+			return nil, err
+		}
+
+		return utils.ReturnRaw(result), nil
+	})
+}
@@ -8,22 +8,27 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"hash"
+	"io"
+	"strings"
 
 	"github.com/yosev/coda/internal/utils"
 )
 
 type fnHash struct {
+	*Fn
 	category FnCategory
 }
 
 func (f *fnHash) init(fn *Fn) {
+	f.Fn = fn
 	fn.register("hash.md5", &FnEntry{
 		Handler:     f.md5,
 		Name:        "MD5 Hash",
 		Description: "Calculate the MD5 hash of a string",
 		Category:    f.category,
 		Parameters: []FnParameter{
-			{Name: "value", Description: "The string to hash", Mandatory: true},
+			{Name: "value", Description: "The string or blob reference to hash", Mandatory: true},
 		},
 	})
 	fn.register("hash.sha1", &FnEntry{
@@ -32,7 +37,7 @@ func (f *fnHash) init(fn *Fn) {
 		Description: "Calculate the SHA1 hash of a string",
 		Category:    f.category,
 		Parameters: []FnParameter{
-			{Name: "value", Description: "The string to hash", Mandatory: true},
+			{Name: "value", Description: "The string or blob reference to hash", Mandatory: true},
 		},
 	})
 	fn.register("hash.sha256", &FnEntry{
@@ -41,7 +46,7 @@ func (f *fnHash) init(fn *Fn) {
 		Description: "Calculate the SHA256 hash of a string",
 		Category:    f.category,
 		Parameters: []FnParameter{
-			{Name: "value", Description: "The string to hash", Mandatory: true},
+			{Name: "value", Description: "The string or blob reference to hash", Mandatory: true},
 		},
 	})
 	fn.register("hash.sha512", &FnEntry{
@@ -50,7 +55,7 @@ func (f *fnHash) init(fn *Fn) {
 		Description: "Calculate the SHA512 hash of a string",
 		Category:    f.category,
 		Parameters: []FnParameter{
-			{Name: "value", Description: "The string to hash", Mandatory: true},
+			{Name: "value", Description: "The string or blob reference to hash", Mandatory: true},
 		},
 	})
 	fn.register("hash.base64.encode", &FnEntry{
@@ -59,7 +64,7 @@ func (f *fnHash) init(fn *Fn) {
 		Description: "Encode to Base64",
 		Category:    f.category,
 		Parameters: []FnParameter{
-			{Name: "value", Description: "The string to encode", Mandatory: true},
+			{Name: "value", Description: "The string or blob reference to encode", Mandatory: true},
 		},
 	})
 	fn.register("hash.base64.decode", &FnEntry{
@@ -78,41 +83,56 @@ type hashParams struct {
 }
 
 func (f *fnHash) md5(j json.RawMessage) (json.RawMessage, error) {
-	return utils.HandleJSON(j, func(params *hashParams) (json.RawMessage, error) {
-		hash := md5.Sum([]byte(params.Value))
-		return utils.ReturnRaw(fmt.Sprintf("%x", hash)), nil
-	})
+	return f.hash(j, md5.New())
 }
 
 func (f *fnHash) sha1(j json.RawMessage) (json.RawMessage, error) {
-	return utils.HandleJSON(j, func(params *hashParams) (json.RawMessage, error) {
-		hash := sha1.New()
-		hash.Write([]byte(params.Value))
-		hashBytes := hash.Sum(nil)
-		return utils.ReturnRaw(fmt.Sprintf("%x", hashBytes)), nil
-	})
+	return f.hash(j, sha1.New())
 }
 
 func (f *fnHash) sha256(j json.RawMessage) (json.RawMessage, error) {
-	return utils.HandleJSON(j, func(params *hashParams) (json.RawMessage, error) {
-		hash := sha256.New()
-		hash.Write([]byte(params.Value))
-		hashBytes := hash.Sum(nil)
-		return utils.ReturnRaw(fmt.Sprintf("%x", hashBytes)), nil
-	})
+	return f.hash(j, sha256.New())
 }
 
 func (f *fnHash) sha512(j json.RawMessage) (json.RawMessage, error) {
+	return f.hash(j, sha512.New())
+}
+
+// hash writes the value, or the referenced blob, into h and returns the hex digest
+func (f *fnHash) hash(j json.RawMessage, h hash.Hash) (json.RawMessage, error) {
 	return utils.HandleJSON(j, func(params *hashParams) (json.RawMessage, error) {
-		hash := sha512.New()
-		hash.Write([]byte(params.Value))
-		hashBytes := hash.Sum(nil)
-		return utils.ReturnRaw(fmt.Sprintf("%x", hashBytes)), nil
+		blob, _, ok, err := f.openBlob(params.Value)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			defer blob.Close()
+			if _, err := io.Copy(h, blob); err != nil {
+				return nil, err
+			}
+		} else {
+			h.Write([]byte(params.Value))
+		}
+		return utils.ReturnRaw(fmt.Sprintf("%x", h.Sum(nil))), nil
 	})
 }
 
 func (f *fnHash) b64enc(j json.RawMessage) (json.RawMessage, error) {
 	return utils.HandleJSON(j, func(params *hashParams) (json.RawMessage, error) {
+		blob, _, ok, err := f.openBlob(params.Value)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			defer blob.Close()
+			var sb strings.Builder
+			encoder := base64.NewEncoder(base64.StdEncoding, &sb)
+			if _, err := io.Copy(encoder, blob); err != nil {
+				return nil, err
+			}
+			encoder.Close()
+			return utils.ReturnRaw(sb.String()), nil
+		}
 		str := base64.StdEncoding.EncodeToString([]byte(params.Value))
 		return utils.ReturnRaw(str), nil
 	})
@@ -2,15 +2,35 @@ package fn
 
 import (
 	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"mime"
 	"mime/multipart"
 	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-resty/resty/v2"
+	"github.com/tidwall/gjson"
 	"github.com/yosev/coda/internal/utils"
 )
 
@@ -27,12 +47,13 @@ func (f *fnHttp) init(fn *Fn) {
 		Name:        "HTTP Request",
 		Description: "Performs an HTTP request",
 		Category:    f.category,
-		Parameters: []FnParameter{
+		Parameters: append([]FnParameter{
 			{Name: "url", Description: "The url to query", Mandatory: true},
-			{Name: "method", Description: "The HTTP method to use", Enum: []string{"GET", "POST", "PUT", "PATCH", "DELETE"}, Mandatory: true},
+			{Name: "method", Description: "The HTTP method to use", Enum: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"}, Mandatory: true},
 			{Name: "headers", Description: "The Headers to use", Type: "object", Mandatory: false},
 			{Name: "body", Description: "The Body to use", Type: "any", Mandatory: false},
-		},
+			{Name: "blob", Description: "If true, the response body is kept in the blob store and a blob reference is returned", Type: "boolean", Mandatory: false},
+		}, httpClientParameters...),
 	})
 
 	fn.register("http.multipart", &FnEntry{
@@ -40,36 +61,449 @@ func (f *fnHttp) init(fn *Fn) {
 		Name:        "HTTP Multipart",
 		Description: "Performs a multipart/form-data HTTP request with automatic file handling",
 		Category:    f.category,
-		Parameters: []FnParameter{
+		Parameters: append([]FnParameter{
 			{Name: "url", Description: "The URL to query", Mandatory: true},
 			{Name: "method", Description: "HTTP method to use", Enum: []string{"POST", "PUT", "PATCH"}, Mandatory: true},
 			{Name: "headers", Description: "Custom headers", Type: "object", Mandatory: false},
-			{Name: "body", Description: "Fields and files for multipart", Type: "object", Mandatory: true},
-		},
+			{Name: "body", Description: "Fields and files for multipart, files are given as data url, blob reference, file://<path> or {file, filename, content_type}", Type: "object", Mandatory: true},
+		}, httpClientParameters...),
 	})
+
+	fn.register("http.download", &FnEntry{
+		Handler:     f.httpDownload,
+		Name:        "HTTP Download",
+		Description: "Streams a response body to a file with optional size limit, checksum verification and resume",
+		Category:    f.category,
+		Parameters: append([]FnParameter{
+			{Name: "url", Description: "The url to download", Mandatory: true},
+			{Name: "destination", Description: "The path of the file to write", Mandatory: true},
+			{Name: "method", Description: "The HTTP method to use (default GET)", Enum: []string{"GET", "POST"}, Mandatory: false},
+			{Name: "headers", Description: "The Headers to use", Type: "object", Mandatory: false},
+			{Name: "body", Description: "The Body to use", Type: "any", Mandatory: false},
+			{Name: "max_size", Description: "The maximum size of the file in bytes", Type: "integer", Mandatory: false},
+			{Name: "checksum", Description: "The expected checksum as <algorithm>:<hex> (md5, sha1, sha256, sha512)", Mandatory: false},
+			{Name: "resume", Description: "If true, a partial download is continued using a Range request", Type: "boolean", Mandatory: false},
+		}, httpClientParameters...),
+	})
+
+	fn.register("http.session", &FnEntry{
+		Handler:     f.httpSession,
+		Name:        "HTTP Session",
+		Description: "Creates a named HTTP session with a cookie jar, default headers and a base url shared by subsequent requests",
+		Category:    f.category,
+		Parameters: append([]FnParameter{
+			{Name: "name", Description: "The name of the session", Mandatory: true},
+			{Name: "base_url", Description: "The base url for relative request urls", Mandatory: false},
+			{Name: "headers", Description: "Default headers for all requests", Type: "object", Mandatory: false},
+		}, withoutParameters(httpClientParameters, "connection", "session", "expected_status")...),
+	})
+
+	fn.register("http.paginate", &FnEntry{
+		Handler:     f.httpPaginate,
+		Name:        "HTTP Paginate",
+		Description: "Fetches all pages of a paginated API and aggregates their items into a single array",
+		Category:    f.category,
+		Parameters: append([]FnParameter{
+			{Name: "url", Description: "The url of the first page", Mandatory: true},
+			{Name: "method", Description: "The HTTP method to use (default GET)", Enum: []string{"GET", "POST"}, Mandatory: false},
+			{Name: "headers", Description: "The Headers to use", Type: "object", Mandatory: false},
+			{Name: "body", Description: "The Body to send with every page request", Type: "any", Mandatory: false},
+			{Name: "mode", Description: "How the next page is determined", Enum: []string{"link", "cursor", "offset", "page"}, Mandatory: true},
+			{Name: "items_path", Description: "The gjson path of the items within a page, defaults to the whole body", Mandatory: false},
+			{Name: "cursor_path", Description: "The gjson path of the next cursor within a page (cursor mode)", Mandatory: false},
+			{Name: "cursor_param", Description: "The query parameter receiving the cursor (default 'cursor')", Mandatory: false},
+			{Name: "offset_param", Description: "The query parameter receiving the offset (default 'offset')", Mandatory: false},
+			{Name: "page_param", Description: "The query parameter receiving the page number (default 'page')", Mandatory: false},
+			{Name: "start_page", Description: "The number of the first page (default 1)", Type: "integer", Mandatory: false},
+			{Name: "limit_param", Description: "The query parameter receiving the page size", Mandatory: false},
+			{Name: "limit", Description: "The page size, a shorter page ends offset and page pagination", Type: "integer", Mandatory: false},
+			{Name: "max_pages", Description: "The maximum number of pages to fetch (default 100)", Type: "integer", Mandatory: false},
+			{Name: "delay", Description: "The delay between page requests in milliseconds", Type: "integer", Mandatory: false},
+		}, httpClientParameters...),
+	})
+}
+
+// httpClientParameters are shared by all actions performing HTTP requests
+var httpClientParameters = []FnParameter{
+	connectionParameter,
+	{Name: "session", Description: "The name of the HTTP session to use, its client options replace the ones of the request", Mandatory: false},
+	{Name: "timeout", Description: "The request timeout in milliseconds", Type: "integer", Mandatory: false},
+	{Name: "query", Description: "Query parameters to add to the url", Type: "object", Mandatory: false},
+	{Name: "basic_auth", Description: "Basic auth credentials ({username, password})", Type: "object", Mandatory: false},
+	{Name: "bearer_token", Description: "Bearer token for the Authorization header", Mandatory: false},
+	{Name: "client_cert", Description: "Client certificate as PEM or file path", Mandatory: false},
+	{Name: "client_key", Description: "Client certificate key as PEM or file path", Mandatory: false},
+	{Name: "ca_cert", Description: "Custom CA certificate as PEM or file path", Mandatory: false},
+	{Name: "insecure_skip_verify", Description: "If true, TLS certificates are not verified", Type: "boolean", Mandatory: false},
+	{Name: "follow_redirects", Description: "If false, redirects are not followed (default true)", Type: "boolean", Mandatory: false},
+	{Name: "max_redirects", Description: "The maximum number of redirects to follow (default 10)", Type: "integer", Mandatory: false},
+	{Name: "proxy", Description: "The proxy url to use", Mandatory: false},
+	{Name: "expected_status", Description: "Status codes treated as success, defaults to all codes below 400", Type: "array", Mandatory: false},
+}
+
+// withoutParameters returns a copy of the parameters without the named ones
+func withoutParameters(params []FnParameter, names ...string) []FnParameter {
+	out := []FnParameter{}
+	for _, p := range params {
+		if !slices.Contains(names, p.Name) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+type httpBasicAuth struct {
+	Username string `json:"username" yaml:"username"`
+	Password string `json:"password" yaml:"password"`
+}
+
+// HttpClientParams configures the client and authentication of an HTTP request
+type HttpClientParams struct {
+	Session            string         `json:"session,omitempty" yaml:"session,omitempty"`
+	Timeout            int            `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	Query              map[string]any `json:"query,omitempty" yaml:"query,omitempty"`
+	BasicAuth          *httpBasicAuth `json:"basic_auth,omitempty" yaml:"basic_auth,omitempty"`
+	BearerToken        string         `json:"bearer_token,omitempty" yaml:"bearer_token,omitempty"`
+	ClientCert         string         `json:"client_cert,omitempty" yaml:"client_cert,omitempty"`
+	ClientKey          string         `json:"client_key,omitempty" yaml:"client_key,omitempty"`
+	CACert             string         `json:"ca_cert,omitempty" yaml:"ca_cert,omitempty"`
+	InsecureSkipVerify bool           `json:"insecure_skip_verify,omitempty" yaml:"insecure_skip_verify,omitempty"`
+	FollowRedirects    *bool          `json:"follow_redirects,omitempty" yaml:"follow_redirects,omitempty"`
+	MaxRedirects       int            `json:"max_redirects,omitempty" yaml:"max_redirects,omitempty"`
+	Proxy              string         `json:"proxy,omitempty" yaml:"proxy,omitempty"`
+	ExpectedStatus     []int          `json:"expected_status,omitempty" yaml:"expected_status,omitempty"`
+}
+
+// HttpSessionParams configures a named HTTP session. Requests referencing
+// the session share its client, cookie jar, connections and defaults.
+type HttpSessionParams struct {
+	HttpClientParams
+	Name    string            `json:"name,omitempty" yaml:"name,omitempty"`
+	BaseUrl string            `json:"base_url,omitempty" yaml:"base_url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+}
+
+// SetHttpSession creates or replaces a named HTTP session for the run
+func (f *Fn) SetHttpSession(name string, params HttpSessionParams) error {
+	if params.Session != "" {
+		return fmt.Errorf("session '%s' cannot reference another session", name)
+	}
+	client, err := f.newHttpClient(&params.HttpClientParams)
+	if err != nil {
+		return fmt.Errorf("failed to create session '%s': %w", name, err)
+	}
+	client.SetBaseURL(params.BaseUrl)
+	client.SetHeaders(params.Headers)
+	if params.BasicAuth != nil {
+		client.SetBasicAuth(params.BasicAuth.Username, params.BasicAuth.Password)
+	}
+	if params.BearerToken != "" {
+		client.SetAuthToken(params.BearerToken)
+	}
+	for k, v := range params.Query {
+		client.QueryParam.Add(k, fmt.Sprint(v))
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.httpSessions[name] = client
+	return nil
+}
+
+// httpClient returns the client of the referenced session or a new client configured by the params
+func (f *Fn) httpClient(params *HttpClientParams) (*resty.Client, error) {
+	if params.Session == "" {
+		return f.newHttpClient(params)
+	}
+	// the transport of a session is shared, its options are set on the session
+	if params.ClientCert != "" || params.ClientKey != "" || params.CACert != "" || params.InsecureSkipVerify ||
+		params.Proxy != "" || params.FollowRedirects != nil || params.MaxRedirects > 0 {
+		return nil, fmt.Errorf("certificate, proxy and redirect options cannot be combined with session '%s', set them on the session", params.Session)
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	client, ok := f.httpSessions[params.Session]
+	if !ok {
+		return nil, fmt.Errorf("unknown HTTP session: %s", params.Session)
+	}
+	return client, nil
+}
+
+// HttpSessionUrl returns the url a request of the session is sent to, relative
+// urls are joined to the base url of the session the way the client does
+func (f *Fn) HttpSessionUrl(session string, rawUrl string) (string, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return "", err
+	}
+	if u.IsAbs() {
+		return rawUrl, nil
+	}
+	f.mutex.Lock()
+	client, ok := f.httpSessions[session]
+	f.mutex.Unlock()
+	if !ok {
+		return "", fmt.Errorf("unknown HTTP session: %s", session)
+	}
+	path := u.String()
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return client.BaseURL + path, nil
+}
+
+// newHttpRequest creates a request with the given headers and the coda user agent
+func (f *Fn) newHttpRequest(client *resty.Client, headers map[string]string) *resty.Request {
+	request := client.R()
+	request.SetHeaders(headers)
+	if _, ok := headers["User-Agent"]; !ok && client.Header.Get("User-Agent") == "" {
+		request.SetHeader("User-Agent", fmt.Sprintf("coda/%s", f.version))
+	}
+	return request
+}
+
+// newHttpClient creates a resty client configured by the params
+func (f *Fn) newHttpClient(p *HttpClientParams) (*resty.Client, error) {
+	client := resty.New()
+
+	if p.Timeout > 0 {
+		client.SetTimeout(time.Duration(p.Timeout) * time.Millisecond)
+	}
+
+	if p.FollowRedirects != nil && !*p.FollowRedirects {
+		// return the redirect response itself instead of failing
+		client.SetRedirectPolicy(resty.RedirectPolicyFunc(func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}))
+	} else if p.MaxRedirects > 0 {
+		client.SetRedirectPolicy(resty.FlexibleRedirectPolicy(p.MaxRedirects))
+	}
+
+	if p.Proxy != "" {
+		client.SetProxy(p.Proxy)
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: p.InsecureSkipVerify}
+	if p.CACert != "" {
+		pem, err := f.readPEM(p.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("failed to parse CA certificate")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if p.ClientCert != "" || p.ClientKey != "" {
+		certPEM, err := f.readPEM(p.ClientCert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client certificate: %w", err)
+		}
+		keyPEM, err := f.readPEM(p.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client key: %w", err)
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	client.SetTLSClientConfig(tlsConfig)
+
+	return client, nil
+}
+
+// applyTimeout bounds a request on a shared session client by the timeout
+// of the request, the returned function releases its context
+func (p *HttpClientParams) applyTimeout(request *resty.Request) context.CancelFunc {
+	if p.Session == "" || p.Timeout <= 0 {
+		return func() {}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.Timeout)*time.Millisecond)
+	request.SetContext(ctx)
+	return cancel
+}
+
+// apply sets query parameters and authentication on the request
+func (p *HttpClientParams) apply(request *resty.Request) {
+	p.applyQuery(request)
+	p.applyAuth(request)
+}
+
+func (p *HttpClientParams) applyQuery(request *resty.Request) {
+	for k, v := range p.Query {
+		switch v := v.(type) {
+		case []any:
+			for _, item := range v {
+				request.QueryParam.Add(k, fmt.Sprint(item))
+			}
+		default:
+			request.QueryParam.Add(k, fmt.Sprint(v))
+		}
+	}
+}
+
+func (p *HttpClientParams) applyAuth(request *resty.Request) {
+	if p.BasicAuth != nil {
+		request.SetBasicAuth(p.BasicAuth.Username, p.BasicAuth.Password)
+	}
+	if p.BearerToken != "" {
+		request.SetAuthToken(p.BearerToken)
+	}
+}
+
+// isExpectedStatus reports whether the status is listed in expected_status,
+// or below 400 if no status codes are expected explicitly
+func (p *HttpClientParams) isExpectedStatus(status int) bool {
+	if len(p.ExpectedStatus) > 0 {
+		return slices.Contains(p.ExpectedStatus, status)
+	}
+	return status < 400
+}
+
+func (p *HttpClientParams) checkStatus(status int, body []byte) error {
+	if !p.isExpectedStatus(status) {
+		return fmt.Errorf("HTTP request failed with status %d: %s", status, string(body))
+	}
+	return nil
+}
+
+// readPEM returns the value if it is PEM encoded, otherwise reads it from a
+// file allowed by the sandbox
+func (f *Fn) readPEM(value string) ([]byte, error) {
+	if strings.HasPrefix(strings.TrimSpace(value), "-----BEGIN") {
+		return []byte(value), nil
+	}
+	if err := f.checkRead(value); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(value)
+}
+
+// decodeBody decodes a response body based on its content type. JSON, XML
+// and form bodies are decoded into structured values, anything else is
+// returned as string.
+func decodeBody(contentType string, body []byte) any {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || len(body) == 0 {
+		return string(body)
+	}
+
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		var j json.RawMessage
+		if err := json.Unmarshal(body, &j); err == nil {
+			return j
+		}
+	case mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"):
+		if x, err := decodeXML(body); err == nil {
+			return x
+		}
+	case mediaType == "application/x-www-form-urlencoded":
+		if values, err := url.ParseQuery(string(body)); err == nil {
+			form := map[string]any{}
+			for k, v := range values {
+				if len(v) == 1 {
+					form[k] = v[0]
+				} else {
+					form[k] = v
+				}
+			}
+			return form
+		}
+	}
+	return string(body)
+}
+
+// decodeXML converts an XML document into a map keyed by the root element.
+// Attributes are prefixed with "@", text content is stored as "#text" and
+// repeated child elements become arrays.
+func decodeXML(body []byte) (map[string]any, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	for {
+		tok, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			root, err := decodeXMLElement(decoder, start)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]any{start.Name.Local: root}, nil
+		}
+	}
+}
+
+func decodeXMLElement(decoder *xml.Decoder, start xml.StartElement) (any, error) {
+	node := map[string]any{}
+	for _, attr := range start.Attr {
+		node["@"+attr.Name.Local] = attr.Value
+	}
+	var text strings.Builder
+	for {
+		tok, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			child, err := decodeXMLElement(decoder, t)
+			if err != nil {
+				return nil, err
+			}
+			name := t.Name.Local
+			switch existing := node[name].(type) {
+			case nil:
+				node[name] = child
+			case []any:
+				node[name] = append(existing, child)
+			default:
+				node[name] = []any{existing, child}
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			content := strings.TrimSpace(text.String())
+			if len(node) == 0 {
+				return content, nil
+			}
+			if content != "" {
+				node["#text"] = content
+			}
+			return node, nil
+		}
+	}
 }
 
 type HttpReqParams struct {
+	HttpClientParams
 	Url     string            `json:"url" yaml:"url"`
 	Method  string            `json:"method" yaml:"method"`
 	Headers map[string]string `json:"headers" yaml:"headers"`
 	Body    any               `json:"body" yaml:"body"`
+	Blob    bool              `json:"blob,omitempty" yaml:"blob,omitempty"`
 }
 
 func (f *fnHttp) httpReq(j json.RawMessage) (json.RawMessage, error) {
 	return utils.HandleJSON(j, func(params *HttpReqParams) (json.RawMessage, error) {
-		client := resty.New()
+		client, err := f.httpClient(&params.HttpClientParams)
+		if err != nil {
+			return nil, err
+		}
 
-		request := client.R()
+		request := f.newHttpRequest(client, params.Headers)
 		request.SetBody(params.Body)
-		request.SetHeaders(params.Headers)
+		params.apply(request)
+		cancel := params.applyTimeout(request)
+		defer cancel()
+		if params.Blob {
+			request.SetDoNotParseResponse(true)
+		}
 
 		var response *resty.Response
-		var err error
-
-		if _, ok := params.Headers["User-Agent"]; !ok {
-			request.SetHeader("User-Agent", fmt.Sprintf("coda/%s", f.Fn.version))
-		}
 
 		switch strings.ToUpper(params.Method) {
 		case "GET":
@@ -94,22 +528,32 @@ func (f *fnHttp) httpReq(j json.RawMessage) (json.RawMessage, error) {
 			return nil, fmt.Errorf("error making HTTP request: %w", err)
 		}
 
+		if params.Blob {
+			body := response.RawBody()
+			defer body.Close()
+			if !params.isExpectedStatus(response.StatusCode()) {
+				b, _ := io.ReadAll(io.LimitReader(body, 4096))
+				return nil, params.checkStatus(response.StatusCode(), b)
+			}
+			ref, err := f.blobs.Put(body)
+			if err != nil {
+				return nil, fmt.Errorf("error storing response body: %w", err)
+			}
+			return utils.ReturnRaw(map[string]any{
+				"status":  response.StatusCode(),
+				"headers": response.Header(),
+				"body":    ref,
+			}), nil
+		}
+
 		resp := map[string]any{
 			"status":  response.StatusCode(),
 			"headers": response.Header(),
-			"body":    string(response.Body()),
+			"body":    decodeBody(response.Header().Get("Content-Type"), response.Body()),
 		}
 
-		if response.Header().Get("Content-Type") == "application/json" {
-			var j json.RawMessage
-			if err := json.Unmarshal(response.Body(), &j); err != nil {
-				return nil, fmt.Errorf("error unmarshaling JSON response: %w", err)
-			}
-			resp["body"] = j
-		}
-
-		if response.StatusCode() >= 400 {
-			return nil, fmt.Errorf("HTTP request failed with status %d: %s", response.StatusCode(), string(response.Body()))
+		if err := params.checkStatus(response.StatusCode(), response.Body()); err != nil {
+			return nil, err
 		}
 
 		return utils.ReturnRaw(resp), nil
@@ -117,95 +561,614 @@ func (f *fnHttp) httpReq(j json.RawMessage) (json.RawMessage, error) {
 }
 
 type MultipartParams struct {
+	HttpClientParams
 	Url     string            `json:"url" yaml:"url"`
 	Method  string            `json:"method" yaml:"method"`
 	Headers map[string]string `json:"headers" yaml:"headers"`
-	Body    map[string]any    `json:"body" yaml:"body"` // key = field name, value = string, []byte, or base64 string
+	Body    map[string]any    `json:"body" yaml:"body"` // key = field name, value = string, data url, blob reference, file:// path or file object
 }
 
-func (f *fnHttp) httpMultipart(j json.RawMessage) (json.RawMessage, error) {
-	return utils.HandleJSON(j, func(params *MultipartParams) (json.RawMessage, error) {
-		var bodyBuf bytes.Buffer
-		writer := multipart.NewWriter(&bodyBuf)
-
-		// Build multipart body
-		for key, val := range params.Body {
-			switch v := val.(type) {
-			case string:
-				if strings.HasPrefix(v, "data:") && strings.Contains(v, "base64,") {
-					// Base64 string, decode and write as file
-					parts := strings.SplitN(v, "base64,", 2)
-					b, err := base64.StdEncoding.DecodeString(parts[1])
-					if err != nil {
-						return nil, fmt.Errorf("failed to decode base64 for field %s: %w", key, err)
-					}
-					part, err := writer.CreateFormFile(key, key)
-					if err != nil {
-						return nil, err
-					}
-					part.Write(b)
-				} else {
-					writer.WriteField(key, v)
+// multipartFile references a local file to upload as a multipart field
+type multipartFile struct {
+	File        string
+	Filename    string
+	ContentType string
+}
+
+// multipartPart is a single field of a multipart body
+type multipartPart struct {
+	field       string
+	value       string
+	filename    string
+	contentType string
+	content     io.ReadCloser // file content, nil for plain values
+}
+
+// multipartParts converts the body fields into parts, opening all files
+// up front so missing files fail before the request is sent
+func (f *fnHttp) multipartParts(body map[string]any) ([]*multipartPart, error) {
+	var parts []*multipartPart
+	fail := func(err error) ([]*multipartPart, error) {
+		closeParts(parts)
+		return nil, err
+	}
+
+	for key, val := range body {
+		part := &multipartPart{field: key, filename: key, contentType: "application/octet-stream"}
+		switch v := val.(type) {
+		case string:
+			if IsBlobRef(v) {
+				blob, _, _, err := f.openBlob(v)
+				if err != nil {
+					return fail(fmt.Errorf("failed to open blob for field %s: %w", key, err))
+				}
+				part.content = blob
+			} else if path, ok := strings.CutPrefix(v, "file://"); ok {
+				if err := f.checkRead(path); err != nil {
+					return fail(err)
 				}
-			case []byte:
-				part, err := writer.CreateFormFile(key, key)
+				if err := part.openFile(multipartFile{File: path}); err != nil {
+					return fail(fmt.Errorf("failed to open file for field %s: %w", key, err))
+				}
+			} else if strings.HasPrefix(v, "data:") && strings.Contains(v, "base64,") {
+				// Base64 string, decode and write as file
+				header, data, _ := strings.Cut(v, "base64,")
+				b, err := base64.StdEncoding.DecodeString(data)
 				if err != nil {
-					return nil, err
+					return fail(fmt.Errorf("failed to decode base64 for field %s: %w", key, err))
 				}
-				part.Write(v)
-			default:
-				return nil, fmt.Errorf("unsupported value type for field %s", key)
+				if mediaType := strings.TrimSuffix(strings.TrimPrefix(header, "data:"), ";"); mediaType != "" {
+					part.contentType = mediaType
+				}
+				part.content = io.NopCloser(bytes.NewReader(b))
+			} else {
+				part.value = v
+			}
+		case map[string]any:
+			file := multipartFile{}
+			file.File, _ = v["file"].(string)
+			file.Filename, _ = v["filename"].(string)
+			file.ContentType, _ = v["content_type"].(string)
+			if file.File == "" {
+				return fail(fmt.Errorf("field %s must reference a file ({file, filename, content_type})", key))
+			}
+			if err := f.checkRead(file.File); err != nil {
+				return fail(err)
 			}
+			if err := part.openFile(file); err != nil {
+				return fail(fmt.Errorf("failed to open file for field %s: %w", key, err))
+			}
+		case []byte:
+			part.content = io.NopCloser(bytes.NewReader(v))
+		default:
+			return fail(fmt.Errorf("unsupported value type for field %s", key))
+		}
+		parts = append(parts, part)
+	}
+	return parts, nil
+}
+
+// openFile opens a local file, defaulting the filename to its base name and
+// the content type to the one of its extension or content
+func (p *multipartPart) openFile(file multipartFile) error {
+	content, err := os.Open(file.File)
+	if err != nil {
+		return err
+	}
+	p.content = content
+	p.filename = file.Filename
+	if p.filename == "" {
+		p.filename = filepath.Base(file.File)
+	}
+	p.contentType = file.ContentType
+	if p.contentType == "" {
+		if p.contentType, err = detectContentType(file.File, content); err != nil {
+			content.Close()
+			return err
 		}
+	}
+	return nil
+}
 
-		writer.Close() // finalize boundary
+// detectContentType returns the content type of the name's extension or
+// sniffs it from the start of the content, which is rewound afterwards
+func detectContentType(name string, content io.ReadSeeker) (string, error) {
+	if contentType := mime.TypeByExtension(filepath.Ext(name)); contentType != "" {
+		return contentType, nil
+	}
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(content, head)
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return http.DetectContentType(head[:n]), nil
+}
 
-		// Prepare request
-		req, err := http.NewRequest(params.Method, params.Url, &bodyBuf)
+// writeMultipart writes all parts and closes them
+func writeMultipart(writer *multipart.Writer, parts []*multipartPart) error {
+	defer closeParts(parts)
+	for _, part := range parts {
+		if part.content == nil {
+			if err := writer.WriteField(part.field, part.value); err != nil {
+				return err
+			}
+			continue
+		}
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{"name": part.field, "filename": part.filename}))
+		header.Set("Content-Type", part.contentType)
+		w, err := writer.CreatePart(header)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		if _, err := io.Copy(w, part.content); err != nil {
+			return fmt.Errorf("failed to write field %s: %w", part.field, err)
 		}
+	}
+	return writer.Close() // finalize boundary
+}
 
-		req.Header.Set("Content-Type", writer.FormDataContentType())
-		for k, v := range params.Headers {
-			req.Header.Set(k, v)
+func closeParts(parts []*multipartPart) {
+	for _, part := range parts {
+		if part.content != nil {
+			part.content.Close()
 		}
+	}
+}
 
-		if _, ok := params.Headers["User-Agent"]; !ok {
-			req.Header.Set("User-Agent", fmt.Sprintf("coda/%s", f.Fn.version))
+func (f *fnHttp) httpMultipart(j json.RawMessage) (json.RawMessage, error) {
+	return utils.HandleJSON(j, func(params *MultipartParams) (json.RawMessage, error) {
+		parts, err := f.multipartParts(params.Body)
+		if err != nil {
+			return nil, err
 		}
 
-		// Execute
-		client := &http.Client{}
-		resp, err := client.Do(req)
+		// Stream the multipart body, files are read while the request is sent
+		reader, pipe := io.Pipe()
+		writer := multipart.NewWriter(pipe)
+		go func() {
+			pipe.CloseWithError(writeMultipart(writer, parts))
+		}()
+
+		// Prepare request
+		client, err := f.httpClient(&params.HttpClientParams)
 		if err != nil {
+			reader.Close()
 			return nil, err
 		}
-		defer resp.Body.Close()
+		request := f.newHttpRequest(client, params.Headers)
+		request.SetHeader("Content-Type", writer.FormDataContentType())
+		request.SetBody(reader)
+		params.apply(request)
+		cancel := params.applyTimeout(request)
+		defer cancel()
 
-		bodyBytes, err := io.ReadAll(resp.Body)
+		// Execute
+		response, err := request.Execute(strings.ToUpper(params.Method), params.Url)
+		reader.Close() // stops the writer if the request ended early
 		if err != nil {
 			return nil, err
 		}
 
 		// Build response object
 		result := map[string]any{
-			"status":  resp.StatusCode,
-			"headers": resp.Header,
-			"body":    string(bodyBytes),
+			"status":  response.StatusCode(),
+			"headers": response.Header(),
+			"body":    decodeBody(response.Header().Get("Content-Type"), response.Body()),
+		}
+
+		if err := params.checkStatus(response.StatusCode(), response.Body()); err != nil {
+			return nil, err
+		}
+
+		return utils.ReturnRaw(result), nil
+	})
+}
+
+type paginateParams struct {
+	HttpClientParams
+	Url         string            `json:"url" yaml:"url"`
+	Method      string            `json:"method,omitempty" yaml:"method,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	Body        any               `json:"body,omitempty" yaml:"body,omitempty"`
+	Mode        string            `json:"mode" yaml:"mode"`
+	ItemsPath   string            `json:"items_path,omitempty" yaml:"items_path,omitempty"`
+	CursorPath  string            `json:"cursor_path,omitempty" yaml:"cursor_path,omitempty"`
+	CursorParam string            `json:"cursor_param,omitempty" yaml:"cursor_param,omitempty"`
+	OffsetParam string            `json:"offset_param,omitempty" yaml:"offset_param,omitempty"`
+	PageParam   string            `json:"page_param,omitempty" yaml:"page_param,omitempty"`
+	StartPage   *int              `json:"start_page,omitempty" yaml:"start_page,omitempty"`
+	LimitParam  string            `json:"limit_param,omitempty" yaml:"limit_param,omitempty"`
+	Limit       int               `json:"limit,omitempty" yaml:"limit,omitempty"`
+	MaxPages    int               `json:"max_pages,omitempty" yaml:"max_pages,omitempty"`
+	Delay       int               `json:"delay,omitempty" yaml:"delay,omitempty"`
+}
+
+func (f *fnHttp) httpPaginate(j json.RawMessage) (json.RawMessage, error) {
+	return utils.HandleJSON(j, func(params *paginateParams) (json.RawMessage, error) {
+		if params.Method == "" {
+			params.Method = "GET"
+		}
+		if params.MaxPages <= 0 {
+			params.MaxPages = 100
+		}
+		if params.CursorParam == "" {
+			params.CursorParam = "cursor"
+		}
+		if params.OffsetParam == "" {
+			params.OffsetParam = "offset"
+		}
+		if params.PageParam == "" {
+			params.PageParam = "page"
+		}
+		page := 1
+		if params.StartPage != nil {
+			page = *params.StartPage
+		}
+		switch params.Mode {
+		case "link", "offset", "page":
+		case "cursor":
+			if params.CursorPath == "" {
+				return nil, fmt.Errorf("cursor_path is required in cursor mode")
+			}
+		default:
+			return nil, fmt.Errorf("unsupported pagination mode: %s", params.Mode)
+		}
+
+		client, err := f.httpClient(&params.HttpClientParams)
+		if err != nil {
+			return nil, err
+		}
+
+		items := []json.RawMessage{}
+		nextUrl := params.Url
+		cursor := ""
+		offset := 0
+		pages := 0
+
+		for pages < params.MaxPages {
+			if pages > 0 && params.Delay > 0 {
+				time.Sleep(time.Duration(params.Delay) * time.Millisecond)
+			}
+
+			request := f.newHttpRequest(client, params.Headers)
+			if params.Body != nil {
+				request.SetBody(params.Body)
+			}
+			params.applyAuth(request)
+			// next links already carry the query of the following page
+			if params.Mode != "link" || pages == 0 {
+				params.applyQuery(request)
+			}
+			if params.Limit > 0 && params.LimitParam != "" && params.Mode != "link" {
+				request.SetQueryParam(params.LimitParam, fmt.Sprint(params.Limit))
+			}
+			switch params.Mode {
+			case "cursor":
+				if cursor != "" {
+					request.SetQueryParam(params.CursorParam, cursor)
+				}
+			case "offset":
+				request.SetQueryParam(params.OffsetParam, fmt.Sprint(offset))
+			case "page":
+				request.SetQueryParam(params.PageParam, fmt.Sprint(page))
+			}
+
+			cancel := params.applyTimeout(request)
+			response, err := request.Execute(strings.ToUpper(params.Method), nextUrl)
+			cancel() // the page body has been read
+			if err != nil {
+				return nil, fmt.Errorf("error fetching page %d: %w", pages+1, err)
+			}
+			if err := params.checkStatus(response.StatusCode(), response.Body()); err != nil {
+				return nil, fmt.Errorf("error fetching page %d: %w", pages+1, err)
+			}
+			pages++
+
+			body := response.Body()
+			if !gjson.ValidBytes(body) {
+				return nil, fmt.Errorf("page %d is not valid JSON", pages)
+			}
+			pageItems := gjson.ParseBytes(body)
+			if params.ItemsPath != "" {
+				pageItems = gjson.GetBytes(body, params.ItemsPath)
+			}
+			count := 0
+			if pageItems.IsArray() {
+				for _, item := range pageItems.Array() {
+					items = append(items, json.RawMessage(item.Raw))
+					count++
+				}
+			} else if pageItems.Exists() && pageItems.Type != gjson.Null {
+				items = append(items, json.RawMessage(pageItems.Raw))
+				count++
+			}
+
+			switch params.Mode {
+			case "link":
+				next := nextLink(response.Header().Values("Link"))
+				if next == "" {
+					return paginateResult(items, pages), nil
+				}
+				base, err := url.Parse(nextUrl)
+				if err != nil {
+					return nil, err
+				}
+				ref, err := url.Parse(next)
+				if err != nil {
+					return nil, fmt.Errorf("invalid next link %q: %w", next, err)
+				}
+				nextUrl = base.ResolveReference(ref).String()
+			case "cursor":
+				cursor = gjson.GetBytes(body, params.CursorPath).String()
+				if cursor == "" {
+					return paginateResult(items, pages), nil
+				}
+			case "offset", "page":
+				if count == 0 || (params.Limit > 0 && count < params.Limit) {
+					return paginateResult(items, pages), nil
+				}
+				offset += count
+				page++
+			}
+		}
+
+		return paginateResult(items, pages), nil
+	})
+}
+
+func paginateResult(items []json.RawMessage, pages int) json.RawMessage {
+	return utils.ReturnRaw(map[string]any{
+		"items": items,
+		"count": len(items),
+		"pages": pages,
+	})
+}
+
+// nextLink extracts the rel="next" target of RFC 8288 Link headers
+func nextLink(headers []string) string {
+	for _, header := range headers {
+		for _, link := range strings.Split(header, ",") {
+			segments := strings.Split(link, ";")
+			target := strings.TrimSpace(segments[0])
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			for _, attr := range segments[1:] {
+				key, value, _ := strings.Cut(strings.TrimSpace(attr), "=")
+				if strings.EqualFold(key, "rel") && slices.Contains(strings.Fields(strings.Trim(value, `"`)), "next") {
+					return strings.Trim(target, "<>")
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func (f *fnHttp) httpSession(j json.RawMessage) (json.RawMessage, error) {
+	return utils.HandleJSON(j, func(params *HttpSessionParams) (json.RawMessage, error) {
+		if params.Name == "" {
+			return nil, fmt.Errorf("session name cannot be empty")
+		}
+		if err := f.SetHttpSession(params.Name, *params); err != nil {
+			return nil, err
+		}
+		return utils.ReturnRaw(map[string]any{
+			"name":     params.Name,
+			"base_url": params.BaseUrl,
+		}), nil
+	})
+}
+
+type downloadParams struct {
+	HttpClientParams
+	Url         string            `json:"url" yaml:"url"`
+	Destination string            `json:"destination" yaml:"destination"`
+	Method      string            `json:"method,omitempty" yaml:"method,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	Body        any               `json:"body,omitempty" yaml:"body,omitempty"`
+	MaxSize     int64             `json:"max_size,omitempty" yaml:"max_size,omitempty"`
+	Checksum    string            `json:"checksum,omitempty" yaml:"checksum,omitempty"`
+	Resume      bool              `json:"resume,omitempty" yaml:"resume,omitempty"`
+}
+
+func (f *fnHttp) httpDownload(j json.RawMessage) (json.RawMessage, error) {
+	return utils.HandleJSON(j, func(params *downloadParams) (json.RawMessage, error) {
+		if params.Destination == "" {
+			return nil, fmt.Errorf("destination cannot be empty")
+		}
+		if err := f.checkWrite(params.Destination); err != nil {
+			return nil, err
+		}
+		var checksum hash.Hash
+		var algorithm, expected string
+		if params.Checksum != "" {
+			var ok bool
+			algorithm, expected, ok = strings.Cut(params.Checksum, ":")
+			if !ok {
+				return nil, fmt.Errorf("checksum must be given as <algorithm>:<hex>")
+			}
+			var err error
+			if checksum, err = newChecksumHash(algorithm); err != nil {
+				return nil, err
+			}
 		}
 
-		if strings.Contains(resp.Header.Get("Content-Type"), "application/json") {
-			var jsonBody json.RawMessage
-			if err := json.Unmarshal(bodyBytes, &jsonBody); err == nil {
-				result["body"] = jsonBody
+		// a partial download is kept next to the destination until it is complete
+		partial := params.Destination + ".part"
+		var offset int64
+		if params.Resume {
+			if info, err := os.Stat(partial); err == nil {
+				offset = info.Size()
 			}
+		} else {
+			os.Remove(partial)
 		}
 
-		if resp.StatusCode >= 400 {
-			return nil, fmt.Errorf("HTTP request failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+		client, err := f.httpClient(&params.HttpClientParams)
+		if err != nil {
+			return nil, err
+		}
+		request := f.newHttpRequest(client, params.Headers)
+		if params.Body != nil {
+			request.SetBody(params.Body)
 		}
+		params.apply(request)
+		cancel := params.applyTimeout(request)
+		defer cancel()
+		request.SetDoNotParseResponse(true)
+		if offset > 0 {
+			request.SetHeader("Range", fmt.Sprintf("bytes=%d-", offset))
+		}
+
+		method := strings.ToUpper(params.Method)
+		if method == "" {
+			method = "GET"
+		}
+		response, err := request.Execute(method, params.Url)
+		if err != nil {
+			return nil, fmt.Errorf("error making HTTP request: %w", err)
+		}
+		body := response.RawBody()
+		defer body.Close()
 
+		status := response.StatusCode()
+		complete := false
+		switch {
+		case offset > 0 && status == http.StatusPartialContent:
+			if start := contentRangeStart(response.Header().Get("Content-Range")); start != offset {
+				return nil, fmt.Errorf("server resumed at byte %d instead of %d", start, offset)
+			}
+		case offset > 0 && status == http.StatusRequestedRangeNotSatisfiable:
+			// the partial file already holds the complete content
+			if total := contentRangeTotal(response.Header().Get("Content-Range")); total != offset {
+				return nil, fmt.Errorf("cannot resume download at byte %d, the remote size is %d", offset, total)
+			}
+			complete = true
+		default:
+			if !params.isExpectedStatus(status) {
+				b, _ := io.ReadAll(io.LimitReader(body, 4096))
+				return nil, params.checkStatus(status, b)
+			}
+			offset = 0 // the server ignored the range, start over
+		}
+
+		if length := response.RawResponse.ContentLength; !complete && params.MaxSize > 0 && length > 0 && offset+length > params.MaxSize {
+			os.Remove(partial)
+			return nil, fmt.Errorf("download of %d bytes exceeds the size limit of %d bytes", offset+length, params.MaxSize)
+		}
+
+		size, err := writeDownload(partial, body, offset, complete, params.MaxSize, checksum)
+		if err != nil {
+			if !params.Resume || errors.Is(err, errDownloadTooLarge) {
+				os.Remove(partial)
+			}
+			return nil, err
+		}
+
+		result := map[string]any{
+			"path":    params.Destination,
+			"size":    size,
+			"status":  status,
+			"headers": response.Header(),
+			"resumed": offset > 0,
+		}
+		if checksum != nil {
+			sum := hex.EncodeToString(checksum.Sum(nil))
+			if !strings.EqualFold(sum, expected) {
+				os.Remove(partial)
+				return nil, fmt.Errorf("checksum mismatch: expected %s but got %s", expected, sum)
+			}
+			result["checksum"] = algorithm + ":" + sum
+		}
+
+		if err := os.Rename(partial, params.Destination); err != nil {
+			return nil, fmt.Errorf("failed to move download to destination: %w", err)
+		}
 		return utils.ReturnRaw(result), nil
 	})
 }
+
+var errDownloadTooLarge = errors.New("download exceeds the size limit")
+
+// writeDownload writes the body to the partial file, appending after offset
+// bytes. The checksum covers the existing and the appended content.
+func writeDownload(path string, body io.Reader, offset int64, complete bool, maxSize int64, checksum hash.Hash) (int64, error) {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	file, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open destination: %w", err)
+	}
+	defer file.Close()
+
+	if checksum != nil && offset > 0 {
+		existing, err := os.Open(path)
+		if err != nil {
+			return 0, err
+		}
+		_, err = io.Copy(checksum, existing)
+		existing.Close()
+		if err != nil {
+			return 0, fmt.Errorf("failed to read partial download: %w", err)
+		}
+	}
+	if complete {
+		return offset, nil
+	}
+
+	var w io.Writer = file
+	if checksum != nil {
+		w = io.MultiWriter(file, checksum)
+	}
+	if maxSize > 0 {
+		body = io.LimitReader(body, maxSize-offset+1)
+	}
+	n, err := io.Copy(w, body)
+	if err != nil {
+		return offset + n, fmt.Errorf("failed to write download: %w", err)
+	}
+	if maxSize > 0 && offset+n > maxSize {
+		return offset + n, fmt.Errorf("%w of %d bytes", errDownloadTooLarge, maxSize)
+	}
+	return offset + n, nil
+}
+
+// contentRangeStart returns the first byte of a "bytes <start>-<end>/<total>" header or -1
+func contentRangeStart(header string) int64 {
+	var start, end int64
+	if _, err := fmt.Sscanf(header, "bytes %d-%d/", &start, &end); err != nil {
+		return -1
+	}
+	return start
+}
+
+// contentRangeTotal returns the total size of a "bytes */<total>" header or -1
+func contentRangeTotal(header string) int64 {
+	_, total, ok := strings.Cut(header, "/")
+	if !ok {
+		return -1
+	}
+	n, err := strconv.ParseInt(total, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+func newChecksumHash(algorithm string) (hash.Hash, error) {
+	switch strings.ToLower(algorithm) {
+	case "md5":
+		return md5.New(), nil
+	case "sha1":
+		return sha1.New(), nil
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported checksum algorithm: %s", algorithm)
+	}
+}
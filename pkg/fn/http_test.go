@@ -0,0 +1,347 @@
+package fn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestHttpRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/json":
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			json.NewEncoder(w).Encode(map[string]any{
+				"query": r.URL.Query().Get("q"),
+				"auth":  r.Header.Get("Authorization"),
+			})
+		case "/xml":
+			w.Header().Set("Content-Type", "application/xml")
+			w.Write([]byte(`<items count="2"><item>a</item><item>b</item></items>`))
+		case "/form":
+			w.Header().Set("Content-Type", "application/x-www-form-urlencoded")
+			w.Write([]byte("a=1&b=2&b=3"))
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		case "/redirect":
+			http.Redirect(w, r, "/json", http.StatusFound)
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+
+	f := New("test")
+	request := func(params map[string]any) (json.RawMessage, error) {
+		b, _ := json.Marshal(params)
+		return f.GetFns()["http.request"].Handler(b)
+	}
+
+	tests := []struct {
+		name   string
+		params map[string]any
+		path   string
+		want   string
+	}{
+		{"json with charset, query and bearer", map[string]any{"url": server.URL + "/json", "query": map[string]any{"q": "x y"}, "bearer_token": "t"}, "body.query", "x y"},
+		{"bearer header", map[string]any{"url": server.URL + "/json", "bearer_token": "t"}, "body.auth", "Bearer t"},
+		{"basic auth", map[string]any{"url": server.URL + "/json", "basic_auth": map[string]any{"username": "u", "password": "p"}}, "body.auth", "Basic dTpw"},
+		{"xml", map[string]any{"url": server.URL + "/xml"}, "body.items.item.1", "b"},
+		{"xml attribute", map[string]any{"url": server.URL + "/xml"}, "body.items.@count", "2"},
+		{"form", map[string]any{"url": server.URL + "/form"}, "body.b.1", "3"},
+		{"expected status", map[string]any{"url": server.URL + "/missing", "expected_status": []int{404}}, "status", "404"},
+		{"no redirects", map[string]any{"url": server.URL + "/redirect", "follow_redirects": false, "expected_status": []int{302}}, "status", "302"},
+	}
+	for _, tt := range tests {
+		tt.params["method"] = "GET"
+		out, err := request(tt.params)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if got := gjson.GetBytes(out, strings.ReplaceAll(tt.path, "@", "\\@")).String(); got != tt.want {
+			t.Errorf("%s: %s = %q, want %q (%s)", tt.name, tt.path, got, tt.want, out)
+		}
+	}
+
+	if _, err := request(map[string]any{"url": server.URL + "/missing", "method": "GET"}); err == nil {
+		t.Errorf("expected status 404 to fail without expected_status")
+	}
+	if _, err := request(map[string]any{"url": server.URL + "/slow", "method": "GET", "timeout": 50}); err == nil {
+		t.Errorf("expected the request to time out")
+	}
+}
+
+func TestHttpPaginate(t *testing.T) {
+	data := []int{1, 2, 3, 4, 5, 6, 7}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		query := r.URL.Query()
+		offset := 0
+		switch r.URL.Path {
+		case "/link", "/cursor":
+			offset, _ = strconv.Atoi(query.Get("from"))
+			if r.URL.Path == "/cursor" {
+				offset, _ = strconv.Atoi(query.Get("cursor"))
+			}
+		case "/offset":
+			offset, _ = strconv.Atoi(query.Get("offset"))
+		case "/page":
+			page, _ := strconv.Atoi(query.Get("page"))
+			offset = (page - 1) * 3
+		}
+		end := min(offset+3, len(data))
+		next := ""
+		if end < len(data) {
+			next = strconv.Itoa(end)
+			if r.URL.Path == "/link" {
+				w.Header().Set("Link", fmt.Sprintf(`</link?from=%d>; rel="next", </link?from=0>; rel="first"`, end))
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": data[offset:end], "next": next})
+	}))
+	defer server.Close()
+
+	f := New("test")
+	for _, mode := range []string{"link", "cursor", "offset", "page"} {
+		params, _ := json.Marshal(map[string]any{
+			"url":         server.URL + "/" + mode,
+			"mode":        mode,
+			"items_path":  "data",
+			"cursor_path": "next",
+			"limit":       3,
+			"limit_param": "limit",
+		})
+		out, err := f.GetFns()["http.paginate"].Handler(params)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", mode, err)
+			continue
+		}
+		if got := gjson.GetBytes(out, "items").Raw; got != "[1,2,3,4,5,6,7]" {
+			t.Errorf("%s: items = %s, want [1,2,3,4,5,6,7]", mode, got)
+		}
+		if got := gjson.GetBytes(out, "pages").Int(); got != 3 {
+			t.Errorf("%s: pages = %d, want 3", mode, got)
+		}
+	}
+
+	params, _ := json.Marshal(map[string]any{"url": server.URL + "/page", "mode": "page", "items_path": "data", "max_pages": 2})
+	out, err := f.GetFns()["http.paginate"].Handler(params)
+	if err != nil || gjson.GetBytes(out, "count").Int() != 6 {
+		t.Errorf("max_pages: expected 6 items from 2 pages, got %s (%v)", out, err)
+	}
+}
+
+func TestHttpSession(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			http.SetCookie(w, &http.Cookie{Name: "sid", Value: "abc", Path: "/"})
+		case "/me":
+			cookie, err := r.Cookie("sid")
+			if err != nil || cookie.Value != "abc" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]any{
+				"tenant": r.Header.Get("X-Tenant"),
+				"trace":  r.Header.Get("X-Trace"),
+			})
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+
+	f := New("test")
+	call := func(action string, params map[string]any) (json.RawMessage, error) {
+		b, _ := json.Marshal(params)
+		return f.GetFns()[action].Handler(b)
+	}
+
+	if _, err := call("http.session", map[string]any{"name": "api", "base_url": server.URL, "headers": map[string]any{"X-Tenant": "t1"}}); err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	if _, err := call("http.request", map[string]any{"url": "/login", "method": "POST", "session": "api"}); err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	out, err := call("http.request", map[string]any{"url": "/me", "method": "GET", "session": "api", "headers": map[string]any{"X-Trace": "1"}})
+	if err != nil {
+		t.Fatalf("request with session cookie failed: %v", err)
+	}
+	if got := gjson.GetBytes(out, "body.tenant").String(); got != "t1" {
+		t.Errorf("expected session header, got %q", got)
+	}
+	if got := gjson.GetBytes(out, "body.trace").String(); got != "1" {
+		t.Errorf("expected request header, got %q", got)
+	}
+
+	if _, err := call("http.request", map[string]any{"url": server.URL + "/me", "method": "GET"}); err == nil {
+		t.Errorf("expected a request without session to be unauthorized")
+	}
+	if _, err := call("http.request", map[string]any{"url": "/me", "method": "GET", "session": "unknown"}); err == nil {
+		t.Errorf("expected an error for an unknown session")
+	}
+	if _, err := call("http.request", map[string]any{"url": "/slow", "method": "GET", "session": "api", "timeout": 50}); err == nil {
+		t.Errorf("expected the request timeout to apply to session requests")
+	}
+	if _, err := call("http.request", map[string]any{"url": "/me", "method": "GET", "session": "api", "proxy": "http://127.0.0.1:1"}); err == nil || !strings.Contains(err.Error(), "cannot be combined with session") {
+		t.Errorf("expected client options to be rejected with a session, got %v", err)
+	}
+	if out, err := call("http.request", map[string]any{"url": "/me", "method": "HEAD", "session": "api"}); err != nil || gjson.GetBytes(out, "status").Int() != 200 {
+		t.Errorf("expected a HEAD request to succeed, got %s, %v", out, err)
+	}
+
+	f.Close()
+	if _, err := call("http.request", map[string]any{"url": "/me", "method": "GET", "session": "api"}); err == nil {
+		t.Errorf("expected sessions to be released on close")
+	}
+}
+
+func TestHttpMultipartFiles(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		files := map[string]any{}
+		for field, headers := range r.MultipartForm.File {
+			file, _ := headers[0].Open()
+			content, _ := io.ReadAll(file)
+			file.Close()
+			files[field] = map[string]any{
+				"filename":     headers[0].Filename,
+				"content_type": headers[0].Header.Get("Content-Type"),
+				"content":      string(content),
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"files": files, "name": r.FormValue("name")})
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "report.json")
+	os.WriteFile(path, []byte(`{"ok":true}`), 0644)
+
+	b, _ := json.Marshal(map[string]any{
+		"url":    server.URL,
+		"method": "POST",
+		"body": map[string]any{
+			"name":   "coda",
+			"report": "file://" + path,
+			"custom": map[string]any{"file": path, "filename": "data.bin", "content_type": "application/x-custom"},
+		},
+	})
+	out, err := New("test").GetFns()["http.multipart"].Handler(b)
+	if err != nil {
+		t.Fatalf("multipart request failed: %v", err)
+	}
+	for path, want := range map[string]string{
+		"body.name":                      "coda",
+		"body.files.report.filename":     "report.json",
+		"body.files.report.content_type": "application/json",
+		"body.files.report.content":      `{"ok":true}`,
+		"body.files.custom.filename":     "data.bin",
+		"body.files.custom.content_type": "application/x-custom",
+	} {
+		if got := gjson.GetBytes(out, path).String(); got != want {
+			t.Errorf("%s = %q, want %q", path, got, want)
+		}
+	}
+
+	b, _ = json.Marshal(map[string]any{"url": server.URL, "method": "POST", "body": map[string]any{"f": "file://" + filepath.Join(dir, "missing")}})
+	if _, err := New("test").GetFns()["http.multipart"].Handler(b); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+}
+
+func TestGraphqlRequestBody(t *testing.T) {
+	var body map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body = nil
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"data": {"ok": true}}`)
+	}))
+	defer server.Close()
+
+	f := New("test")
+	if _, err := callFn(t, f, "graphql.query", map[string]any{"endpoint": server.URL, "query": "{ ok }"}); err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if _, ok := body["operationName"]; ok || len(body) != 1 {
+		t.Errorf("expected only the query to be sent, got %v", body)
+	}
+
+	if _, err := callFn(t, f, "graphql.query", map[string]any{"endpoint": server.URL, "query": "query A { ok }", "operation_name": "A", "variables": map[string]any{"id": 1}}); err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if body["operationName"] != "A" || body["variables"] == nil {
+		t.Errorf("expected the operation name and variables to be sent, got %v", body)
+	}
+}
+
+func TestHttpDownload(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 100))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "data.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	destination := filepath.Join(dir, "data.bin")
+	sum := sha256.Sum256(content)
+	download := func(params map[string]any) (json.RawMessage, error) {
+		params["url"] = server.URL
+		params["destination"] = destination
+		b, _ := json.Marshal(params)
+		return New("test").GetFns()["http.download"].Handler(b)
+	}
+
+	// resume a partial download
+	os.WriteFile(destination+".part", content[:400], 0644)
+	out, err := download(map[string]any{"resume": true, "checksum": "sha256:" + hex.EncodeToString(sum[:])})
+	if err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	if !gjson.GetBytes(out, "resumed").Bool() || gjson.GetBytes(out, "size").Int() != int64(len(content)) {
+		t.Errorf("unexpected download result: %s", out)
+	}
+	if written, _ := os.ReadFile(destination); !bytes.Equal(written, content) {
+		t.Errorf("downloaded content does not match")
+	}
+	if _, err := os.Stat(destination + ".part"); !os.IsNotExist(err) {
+		t.Errorf("expected the partial file to be removed")
+	}
+
+	// a complete partial file is accepted as is
+	os.WriteFile(destination+".part", content, 0644)
+	if _, err := download(map[string]any{"resume": true}); err != nil {
+		t.Errorf("expected a complete partial download to succeed: %v", err)
+	}
+
+	if _, err := download(map[string]any{"checksum": "sha256:00"}); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("expected a checksum mismatch, got %v", err)
+	}
+	if _, err := download(map[string]any{"max_size": 100}); err == nil || !strings.Contains(err.Error(), "size limit") {
+		t.Errorf("expected the size limit to be enforced, got %v", err)
+	}
+	if _, err := os.Stat(destination + ".part"); !os.IsNotExist(err) {
+		t.Errorf("expected failed downloads to be removed")
+	}
+}
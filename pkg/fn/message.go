@@ -3,6 +3,12 @@ package fn
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/containrrr/shoutrrr"
 	"github.com/containrrr/shoutrrr/pkg/types"
@@ -10,42 +16,176 @@ import (
 )
 
 type fnMessage struct {
+	*Fn
 	category FnCategory
 }
 
 func (f *fnMessage) init(fn *Fn) {
+	f.Fn = fn
+
 	fn.register("message.shoutrrr", &FnEntry{
 		Handler:     f.shoutrrr,
 		Name:        "Shoutrrr",
-		Description: "Sends a message using the Shoutrrr notification system",
+		Description: "Sends a message using the Shoutrrr notification system and returns the results per target as [{url, service, success, error, duration}]",
 		Category:    f.category,
 		Parameters: []FnParameter{
 			{Name: "urls", Description: "The shoutrrr targets", Type: "array", Mandatory: true},
 			{Name: "message", Description: "The shoutrrr message to send", Mandatory: true},
+			{Name: "title", Description: "The title of the message", Mandatory: false},
 			{Name: "parameters", Description: "Additional shoutrrr properties", Type: "object", Mandatory: false},
+			{Name: "service_parameters", Description: "Properties by service type overriding parameters, e.g. {\"slack\": {\"color\": \"good\"}}", Type: "object", Mandatory: false},
+			{Name: "min_success", Description: "The number of targets that must receive the message (default all)", Type: "integer", Mandatory: false},
+		},
+	})
+
+	fn.register("message.email", &FnEntry{
+		Handler:     f.email,
+		Name:        "Email",
+		Description: "Sends an email over SMTP and returns {message_id, recipients}",
+		Category:    f.category,
+		Parameters: []FnParameter{
+			connectionParameter,
+			{Name: "host", Description: "The SMTP server host", Mandatory: false},
+			{Name: "port", Description: "The SMTP server port (default 465 for tls, otherwise 587)", Type: "integer", Mandatory: false},
+			{Name: "username", Description: "The username to authenticate with", Mandatory: false},
+			{Name: "password", Description: "The password to authenticate with", Mandatory: false},
+			{Name: "tls", Description: "starttls to require STARTTLS, tls for implicit TLS or none. By default STARTTLS is used if offered", Enum: []string{"starttls", "tls", "none"}, Mandatory: false},
+			{Name: "insecure_skip_verify", Description: "If true, TLS certificates are not verified", Type: "boolean", Mandatory: false},
+			{Name: "timeout", Description: "The timeout of the delivery in ms (default 30s)", Type: "integer", Mandatory: false},
+			{Name: "from", Description: "The sender address", Mandatory: false},
+			{Name: "reply_to", Description: "The reply-to address", Mandatory: false},
+			{Name: "to", Description: "The recipient addresses", Type: "array", Mandatory: false},
+			{Name: "cc", Description: "The carbon copy addresses", Type: "array", Mandatory: false},
+			{Name: "bcc", Description: "The blind carbon copy addresses, not included in the headers", Type: "array", Mandatory: false},
+			{Name: "subject", Description: "The subject", Mandatory: false},
+			{Name: "text", Description: "The plain text body", Mandatory: false},
+			{Name: "html", Description: "The HTML body", Mandatory: false},
+			{Name: "attachments", Description: "Files or blob references to attach, as path or {path, name, content_type}", Type: "array", Mandatory: false},
+			{Name: "inline", Description: "Inline images referenced by cid: in the HTML body, as {path, cid, name, content_type}", Type: "array", Mandatory: false},
+			{Name: "headers", Description: "Additional headers", Type: "object", Mandatory: false},
 		},
 	})
 }
 
 type shoutrrrStruct struct {
-	Urls       []string      `json:"urls" yaml:"urls"`
-	Message    string        `json:"message" yaml:"message"`
-	Parameters *types.Params `json:"parameters,omitempty" yaml:"parameters,omitempty"`
+	Urls              []string                `json:"urls" yaml:"urls"`
+	Message           string                  `json:"message" yaml:"message"`
+	Title             string                  `json:"title,omitempty" yaml:"title,omitempty"`
+	Parameters        *types.Params           `json:"parameters,omitempty" yaml:"parameters,omitempty"`
+	ServiceParameters map[string]types.Params `json:"service_parameters,omitempty" yaml:"service_parameters,omitempty"`
+	MinSuccess        *int                    `json:"min_success,omitempty" yaml:"min_success,omitempty"`
+}
+
+// shoutrrrResult is the delivery result of a single target. The url is
+// redacted, as shoutrrr urls usually embed tokens or passwords.
+type shoutrrrResult struct {
+	Url      string `json:"url"`
+	Service  string `json:"service"`
+	Success  bool   `json:"success"`
+	Error    string `json:"error,omitempty"`
+	Duration int64  `json:"duration"`
 }
 
 func (f *fnMessage) shoutrrr(j json.RawMessage) (json.RawMessage, error) {
 	return utils.HandleJSON(j, func(params *shoutrrrStruct) (json.RawMessage, error) {
-		sender, err := shoutrrr.CreateSender(params.Urls...)
-		if err != nil {
-			return nil, err
-		}
 		if params.Message == "" {
 			return nil, errors.New("message cannot be empty")
 		}
-		erro := sender.Send(params.Message, params.Parameters)
-		if len(erro) > 0 {
-			return nil, erro[0]
+		minSuccess := len(params.Urls)
+		if params.MinSuccess != nil {
+			minSuccess = *params.MinSuccess
+		}
+
+		results := make([]shoutrrrResult, len(params.Urls))
+		forEachConcurrent(len(params.Urls), len(params.Urls), func(i int) error {
+			results[i] = f.sendShoutrrr(params, params.Urls[i])
+			return nil
+		})
+
+		succeeded := 0
+		for _, result := range results {
+			if result.Success {
+				succeeded++
+			}
+		}
+		if succeeded < minSuccess {
+			err := fmt.Errorf("message delivered to %d of %d targets, %d required", succeeded, len(results), minSuccess)
+			for _, result := range results {
+				if !result.Success {
+					err = fmt.Errorf("%w; %s: %s", err, result.Url, result.Error)
+					break
+				}
+			}
+			return nil, &ResultError{Err: err, Result: utils.ReturnRaw(results)}
 		}
-		return nil, nil
+		return utils.ReturnRaw(results), nil
 	})
 }
+
+func (f *fnMessage) sendShoutrrr(params *shoutrrrStruct, target string) shoutrrrResult {
+	result := shoutrrrResult{Url: redactShoutrrrUrl(target)}
+	if u, err := url.Parse(target); err == nil {
+		result.Service = u.Scheme
+	}
+
+	send := types.Params{}
+	if params.Parameters != nil {
+		maps.Copy(send, *params.Parameters)
+	}
+	maps.Copy(send, params.ServiceParameters[result.Service])
+	if params.Title != "" {
+		send.SetTitle(params.Title)
+	}
+
+	start := time.Now()
+	sender, err := shoutrrr.CreateSender(target)
+	if err == nil {
+		err = errors.Join(sender.Send(params.Message, &send)...)
+	}
+	result.Duration = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Error = redactShoutrrrSecrets(err.Error(), target)
+	} else {
+		result.Success = true
+	}
+	return result
+}
+
+// redactShoutrrrUrl keeps the service and the host of urls with credentials.
+// Without credentials the host is often a token itself, e.g. for slack.
+func redactShoutrrrUrl(target string) string {
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme == "" {
+		return "***"
+	}
+	if u.User != nil {
+		return fmt.Sprintf("%s://***@%s", u.Scheme, u.Host)
+	}
+	return u.Scheme + "://***"
+}
+
+// redactShoutrrrSecrets removes the url and all of its parts that may hold
+// credentials from the message
+func redactShoutrrrSecrets(message string, target string) string {
+	secrets := []string{target}
+	if u, err := url.Parse(target); err == nil {
+		if u.User != nil {
+			password, _ := u.User.Password()
+			secrets = append(secrets, u.User.String(), u.User.Username(), password)
+		} else {
+			secrets = append(secrets, u.Host)
+		}
+		secrets = append(secrets, strings.Split(u.Path, "/")...)
+		for _, values := range u.Query() {
+			secrets = append(secrets, values...)
+		}
+	}
+	// replace longer secrets first, they may contain shorter ones
+	sort.Slice(secrets, func(a, b int) bool { return len(secrets[a]) > len(secrets[b]) })
+	for _, secret := range secrets {
+		if len(secret) >= 4 {
+			message = strings.ReplaceAll(message, secret, "***")
+		}
+	}
+	return message
+}
@@ -0,0 +1,388 @@
+package fn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yosev/coda/internal/utils"
+)
+
+type emailParams struct {
+	Host               string            `json:"host,omitempty" yaml:"host,omitempty"`
+	Port               int               `json:"port,omitempty" yaml:"port,omitempty"`
+	Username           string            `json:"username,omitempty" yaml:"username,omitempty"`
+	Password           string            `json:"password,omitempty" yaml:"password,omitempty"`
+	TLS                string            `json:"tls,omitempty" yaml:"tls,omitempty"`
+	InsecureSkipVerify bool              `json:"insecure_skip_verify,omitempty" yaml:"insecure_skip_verify,omitempty"`
+	Timeout            int               `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	From               string            `json:"from,omitempty" yaml:"from,omitempty"`
+	ReplyTo            string            `json:"reply_to,omitempty" yaml:"reply_to,omitempty"`
+	To                 []string          `json:"to,omitempty" yaml:"to,omitempty"`
+	Cc                 []string          `json:"cc,omitempty" yaml:"cc,omitempty"`
+	Bcc                []string          `json:"bcc,omitempty" yaml:"bcc,omitempty"`
+	Subject            string            `json:"subject,omitempty" yaml:"subject,omitempty"`
+	Text               string            `json:"text,omitempty" yaml:"text,omitempty"`
+	HTML               string            `json:"html,omitempty" yaml:"html,omitempty"`
+	Attachments        []emailAttachment `json:"attachments,omitempty" yaml:"attachments,omitempty"`
+	Inline             []emailAttachment `json:"inline,omitempty" yaml:"inline,omitempty"`
+	Headers            map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+}
+
+// emailAttachment is a file or blob attached to an email. A plain string is
+// taken as path.
+type emailAttachment struct {
+	Path        string `json:"path" yaml:"path"`
+	Name        string `json:"name,omitempty" yaml:"name,omitempty"`
+	ContentType string `json:"content_type,omitempty" yaml:"content_type,omitempty"`
+	Cid         string `json:"cid,omitempty" yaml:"cid,omitempty"`
+}
+
+func (a *emailAttachment) UnmarshalJSON(data []byte) error {
+	var path string
+	if err := json.Unmarshal(data, &path); err == nil {
+		*a = emailAttachment{Path: path}
+		return nil
+	}
+	type plain emailAttachment
+	return json.Unmarshal(data, (*plain)(a))
+}
+
+type emailResult struct {
+	MessageId  string   `json:"message_id"`
+	Recipients []string `json:"recipients"`
+}
+
+func (f *fnMessage) email(j json.RawMessage) (json.RawMessage, error) {
+	return utils.HandleJSON(j, func(params *emailParams) (json.RawMessage, error) {
+		if params.Host == "" {
+			return nil, errors.New("host is required")
+		}
+		if !slices.Contains([]string{"", "starttls", "tls", "none"}, params.TLS) {
+			return nil, fmt.Errorf("invalid tls mode: %s", params.TLS)
+		}
+		from, err := mail.ParseAddress(params.From)
+		if err != nil {
+			return nil, fmt.Errorf("invalid from address: %w", err)
+		}
+		recipients := []string{}
+		for _, address := range slices.Concat(params.To, params.Cc, params.Bcc) {
+			parsed, err := mail.ParseAddress(address)
+			if err != nil {
+				return nil, fmt.Errorf("invalid recipient '%s': %w", address, err)
+			}
+			recipients = append(recipients, parsed.Address)
+		}
+		if len(recipients) == 0 {
+			return nil, errors.New("at least one recipient is required")
+		}
+
+		messageId, err := newMessageId(from.Address)
+		if err != nil {
+			return nil, err
+		}
+		message, err := f.buildEmail(params, from, messageId)
+		if err != nil {
+			return nil, err
+		}
+		if err := sendEmail(params, from.Address, recipients, message); err != nil {
+			return nil, err
+		}
+		return utils.ReturnRaw(emailResult{MessageId: messageId, Recipients: recipients}), nil
+	})
+}
+
+func newMessageId(from string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	domain := "localhost"
+	if i := strings.LastIndex(from, "@"); i >= 0 {
+		domain = from[i+1:]
+	}
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(b), domain), nil
+}
+
+// emailReservedHeaders are written by buildEmail and cannot be set as custom headers
+var emailReservedHeaders = []string{"From", "To", "Cc", "Bcc", "Reply-To", "Subject", "Date", "Message-Id", "Mime-Version", "Content-Type", "Content-Transfer-Encoding"}
+
+// buildEmail renders the MIME message. Attachments wrap the body in
+// multipart/mixed, inline images in multipart/related and text and HTML
+// bodies are sent as multipart/alternative.
+func (f *fnMessage) buildEmail(params *emailParams, from *mail.Address, messageId string) ([]byte, error) {
+	var buf bytes.Buffer
+	header := func(key, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", key, value)
+	}
+	header("From", from.String())
+	addresses := func(key string, addresses []string) {
+		if len(addresses) == 0 {
+			return
+		}
+		formatted := []string{}
+		for _, address := range addresses {
+			parsed, _ := mail.ParseAddress(address)
+			formatted = append(formatted, parsed.String())
+		}
+		header(key, strings.Join(formatted, ", "))
+	}
+	addresses("To", params.To)
+	addresses("Cc", params.Cc)
+	if params.ReplyTo != "" {
+		replyTo, err := mail.ParseAddress(params.ReplyTo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid reply_to address: %w", err)
+		}
+		header("Reply-To", replyTo.String())
+	}
+	header("Subject", mime.QEncoding.Encode("utf-8", params.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", messageId)
+	header("MIME-Version", "1.0")
+	for key, value := range params.Headers {
+		if strings.ContainsAny(key+value, "\r\n") {
+			return nil, fmt.Errorf("invalid header: %s", key)
+		}
+		key = textproto.CanonicalMIMEHeaderKey(key)
+		if slices.Contains(emailReservedHeaders, key) {
+			return nil, fmt.Errorf("header %s is set from the params and cannot be overridden", key)
+		}
+		header(key, value)
+	}
+
+	parts := []emailPart{}
+	if params.Text != "" || params.HTML == "" {
+		parts = append(parts, textPart("text/plain", params.Text))
+	}
+	if params.HTML != "" {
+		parts = append(parts, textPart("text/html", params.HTML))
+	}
+	body := parts[0]
+	if len(parts) > 1 {
+		body = multipartOf("alternative", parts...)
+	}
+
+	if len(params.Inline) > 0 {
+		related := []emailPart{body}
+		for _, image := range params.Inline {
+			part, err := f.attachmentPart(image, "inline")
+			if err != nil {
+				return nil, err
+			}
+			related = append(related, part)
+		}
+		body = multipartOf("related", related...)
+	}
+	if len(params.Attachments) > 0 {
+		mixed := []emailPart{body}
+		for _, attachment := range params.Attachments {
+			part, err := f.attachmentPart(attachment, "attachment")
+			if err != nil {
+				return nil, err
+			}
+			mixed = append(mixed, part)
+		}
+		body = multipartOf("mixed", mixed...)
+	}
+
+	for key, values := range body.header {
+		header(key, values[0])
+	}
+	buf.WriteString("\r\n")
+	buf.Write(body.body)
+	return buf.Bytes(), nil
+}
+
+type emailPart struct {
+	header textproto.MIMEHeader
+	body   []byte
+}
+
+func textPart(contentType, content string) emailPart {
+	var body bytes.Buffer
+	w := quotedprintable.NewWriter(&body)
+	w.Write([]byte(content))
+	w.Close()
+	return emailPart{
+		header: textproto.MIMEHeader{
+			"Content-Type":              {contentType + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		},
+		body: body.Bytes(),
+	}
+}
+
+func multipartOf(subtype string, parts ...emailPart) emailPart {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for _, part := range parts {
+		pw, _ := w.CreatePart(part.header)
+		pw.Write(part.body)
+	}
+	w.Close()
+	contentType := fmt.Sprintf("multipart/%s; boundary=%s", subtype, w.Boundary())
+	if subtype == "related" {
+		root, _, _ := mime.ParseMediaType(parts[0].header.Get("Content-Type"))
+		contentType += fmt.Sprintf("; type=%q", root)
+	}
+	return emailPart{header: textproto.MIMEHeader{"Content-Type": {contentType}}, body: body.Bytes()}
+}
+
+// attachmentPart reads a file or blob into a base64 encoded part
+func (f *fnMessage) attachmentPart(attachment emailAttachment, disposition string) (emailPart, error) {
+	var content []byte
+	if blob, _, ok, err := f.openBlob(attachment.Path); ok {
+		if err != nil {
+			return emailPart{}, err
+		}
+		defer blob.Close()
+		if content, err = io.ReadAll(blob); err != nil {
+			return emailPart{}, fmt.Errorf("failed to read attachment: %w", err)
+		}
+	} else {
+		if attachment.Path == "" {
+			return emailPart{}, errors.New("attachments require a path")
+		}
+		if err := f.checkRead(attachment.Path); err != nil {
+			return emailPart{}, err
+		}
+		if content, err = os.ReadFile(attachment.Path); err != nil {
+			return emailPart{}, fmt.Errorf("failed to read attachment: %w", err)
+		}
+	}
+
+	name := attachment.Name
+	if name == "" && !IsBlobRef(attachment.Path) {
+		name = filepath.Base(attachment.Path)
+	}
+	contentType := attachment.ContentType
+	if contentType == "" {
+		var err error
+		if contentType, err = detectContentType(name, bytes.NewReader(content)); err != nil {
+			return emailPart{}, err
+		}
+	}
+
+	header := textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {"base64"},
+	}
+	if name != "" {
+		header.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": name}))
+	} else {
+		header.Set("Content-Disposition", disposition)
+	}
+	if disposition == "inline" {
+		cid := attachment.Cid
+		if cid == "" {
+			cid = name
+		}
+		if cid == "" {
+			return emailPart{}, errors.New("inline images require a cid or name")
+		}
+		header.Set("Content-ID", "<"+cid+">")
+	}
+
+	// base64 lines must not exceed 76 characters
+	encoded := base64.StdEncoding.EncodeToString(content)
+	var body bytes.Buffer
+	for len(encoded) > 76 {
+		body.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	body.WriteString(encoded)
+	return emailPart{header: header, body: body.Bytes()}, nil
+}
+
+// sendEmail delivers the message, upgrading the connection with STARTTLS
+// if required by the params or offered by the server
+func sendEmail(params *emailParams, from string, recipients []string, message []byte) error {
+	port := params.Port
+	if port == 0 {
+		port = 587
+		if params.TLS == "tls" {
+			port = 465
+		}
+	}
+	timeout := 30 * time.Second
+	if params.Timeout > 0 {
+		timeout = time.Duration(params.Timeout) * time.Millisecond
+	}
+	addr := net.JoinHostPort(params.Host, strconv.Itoa(port))
+	tlsConfig := &tls.Config{ServerName: params.Host, InsecureSkipVerify: params.InsecureSkipVerify}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	var err error
+	if params.TLS == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	client, err := smtp.NewClient(conn, params.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	defer client.Close()
+
+	if params.TLS != "tls" && params.TLS != "none" {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				return fmt.Errorf("failed to start TLS: %w", err)
+			}
+		} else if params.TLS == "starttls" {
+			return fmt.Errorf("%s does not support STARTTLS", addr)
+		}
+	}
+	if params.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", params.Username, params.Password, params.Host)); err != nil {
+			return fmt.Errorf("failed to authenticate: %w", err)
+		}
+	}
+
+	if err := client.Mail(from); err != nil {
+		return fmt.Errorf("sender rejected: %w", err)
+	}
+	for _, recipient := range recipients {
+		if err := client.Rcpt(recipient); err != nil {
+			return fmt.Errorf("recipient %s rejected: %w", recipient, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	if _, err := w.Write(message); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	return client.Quit()
+}
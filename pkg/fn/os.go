@@ -2,20 +2,29 @@ package fn
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/yosev/coda/internal/utils"
 )
 
 type fnOs struct {
+	*Fn
 	category FnCategory
 }
 
 func (f *fnOs) init(fn *Fn) {
+	f.Fn = fn
+
 	fn.register("os.name", &FnEntry{
 		Handler:     f.os,
 		Name:        "Get OS",
@@ -33,12 +42,22 @@ func (f *fnOs) init(fn *Fn) {
 	fn.register("os.exec", &FnEntry{
 		Handler:     f.exec,
 		Name:        "Execute Command",
-		Description: "Returns output of the command execution",
+		Description: "Returns {stdout, stderr, code, duration} of the command execution, also to the onFail branch",
 		Category:    f.category,
-		Parameters: []FnParameter{
+		Parameters: append([]FnParameter{
 			{Name: "command", Description: "The command to execute", Mandatory: true},
-			{Name: "arguments", Description: "The arguments for the execution", Type: "array", Mandatory: true},
-		},
+			{Name: "arguments", Description: "The arguments for the execution", Type: "array", Mandatory: false},
+		}, execParameters...),
+	})
+	fn.register("os.shell", &FnEntry{
+		Handler:     f.shell,
+		Name:        "Execute Shell Script",
+		Description: "Runs a script with the system shell (sh -c or cmd /C) and returns {stdout, stderr, code, duration}, also to the onFail branch",
+		Category:    f.category,
+		Parameters: append([]FnParameter{
+			{Name: "script", Description: "The script to run", Mandatory: true},
+			{Name: "shell", Description: "The shell to use, defaults to sh (cmd on windows)", Mandatory: false},
+		}, execParameters...),
 	})
 	fn.register("os.env.get", &FnEntry{
 		Handler:     f.env,
@@ -59,32 +78,169 @@ func (f *fnOs) arch(j json.RawMessage) (json.RawMessage, error) {
 	return utils.ReturnRaw(runtime.GOARCH), nil
 }
 
+// execParameters are shared by os.exec and os.shell
+var execParameters = []FnParameter{
+	{Name: "dir", Description: "The working directory of the command (default current directory)", Mandatory: false},
+	{Name: "stdin", Description: "The input written to stdin", Mandatory: false},
+	{Name: "env", Description: "Environment variables to set", Type: "object", Mandatory: false},
+	{Name: "inherit_env", Description: "If false, the environment of coda is not passed to the command (default true)", Type: "boolean", Mandatory: false},
+	{Name: "timeout", Description: "The timeout in milliseconds after which the command is killed", Type: "integer", Mandatory: false},
+	{Name: "success_codes", Description: "Exit codes treated as success (default [0])", Type: "array", Mandatory: false},
+	{Name: "max_output", Description: "The maximum number of bytes kept of stdout and stderr each, 0 is unlimited", Type: "integer", Mandatory: false},
+}
+
+type execOptions struct {
+	Dir          string            `json:"dir,omitempty" yaml:"dir,omitempty"`
+	Stdin        string            `json:"stdin,omitempty" yaml:"stdin,omitempty"`
+	Env          map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
+	InheritEnv   *bool             `json:"inherit_env,omitempty" yaml:"inherit_env,omitempty"`
+	Timeout      int               `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	SuccessCodes []int             `json:"success_codes,omitempty" yaml:"success_codes,omitempty"`
+	MaxOutput    int               `json:"max_output,omitempty" yaml:"max_output,omitempty"`
+}
+
 type cmdParams struct {
+	execOptions
 	Command   string   `json:"command" yaml:"command"`
 	Arguments []string `json:"arguments" yaml:"arguments"`
 }
 
+type shellParams struct {
+	execOptions
+	Script string `json:"script" yaml:"script"`
+	Shell  string `json:"shell,omitempty" yaml:"shell,omitempty"`
+}
+
+// execResult is returned on success and, wrapped in a ResultError, on failure
+type execResult struct {
+	StdOut    string `json:"stdout" yaml:"stdout"`
+	StdErr    string `json:"stderr" yaml:"stderr"`
+	Code      int    `json:"code" yaml:"code"`
+	Duration  int64  `json:"duration" yaml:"duration"` // milliseconds
+	TimedOut  bool   `json:"timed_out,omitempty" yaml:"timed_out,omitempty"`
+	Truncated bool   `json:"truncated,omitempty" yaml:"truncated,omitempty"`
+}
+
 func (f *fnOs) exec(j json.RawMessage) (json.RawMessage, error) {
 	return utils.HandleJSON(j, func(params *cmdParams) (json.RawMessage, error) {
-		cmd := exec.Command(params.Command, params.Arguments...)
-		var stdOutBuffer bytes.Buffer
-		var stdErrBuffer bytes.Buffer
-		cmd.Stdout = &stdOutBuffer
-		cmd.Stderr = &stdErrBuffer
-
-		err := cmd.Run()
-		if err != nil {
+		if params.Command == "" {
+			return nil, fmt.Errorf("command cannot be empty")
+		}
+		return f.run(params.Command, params.Arguments, &params.execOptions)
+	})
+}
+
+func (f *fnOs) shell(j json.RawMessage) (json.RawMessage, error) {
+	return utils.HandleJSON(j, func(params *shellParams) (json.RawMessage, error) {
+		if params.Script == "" {
+			return nil, fmt.Errorf("script cannot be empty")
+		}
+		shell, flag := "sh", "-c"
+		if runtime.GOOS == "windows" {
+			shell, flag = "cmd", "/C"
+		}
+		if params.Shell != "" {
+			shell = params.Shell
+			if base := strings.TrimSuffix(filepath.Base(shell), ".exe"); base == "cmd" {
+				flag = "/C"
+			} else if base == "powershell" || base == "pwsh" {
+				flag = "-Command"
+			} else {
+				flag = "-c"
+			}
+		}
+		return f.run(shell, []string{flag, params.Script}, &params.execOptions)
+	})
+}
+
+// run executes the command and fails with the result attached if it cannot
+// be started, times out or exits with a code not treated as success
+func (f *fnOs) run(command string, arguments []string, opts *execOptions) (json.RawMessage, error) {
+	// the command may write anywhere, the sandbox only guards the directory it is started in
+	if opts.Dir != "" {
+		if err := f.checkRead(opts.Dir); err != nil {
 			return nil, err
 		}
+	}
 
-		res := &struct {
-			StdOut string `json:"stdout" yaml:"stdout"`
-			StdErr string `json:"stderr" yaml:"stderr"`
-			Code   int    `json:"code" yaml:"code"`
-		}{StdOut: strings.Trim(stdOutBuffer.String(), "\n"), StdErr: strings.Trim(stdErrBuffer.String(), "\n"), Code: cmd.ProcessState.ExitCode()}
+	ctx := context.Background()
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(opts.Timeout)*time.Millisecond)
+		defer cancel()
+	}
 
-		return utils.ReturnRaw(res), nil
-	})
+	cmd := exec.CommandContext(ctx, command, arguments...)
+	cmd.Dir = opts.Dir
+	cmd.WaitDelay = time.Second // don't wait for children holding the output pipes after a kill
+	if opts.InheritEnv == nil || *opts.InheritEnv {
+		cmd.Env = os.Environ()
+	} else {
+		cmd.Env = []string{}
+	}
+	for k, v := range opts.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	if opts.Stdin != "" {
+		cmd.Stdin = strings.NewReader(opts.Stdin)
+	}
+	stdout := &limitedBuffer{limit: opts.MaxOutput}
+	stderr := &limitedBuffer{limit: opts.MaxOutput}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	start := time.Now()
+	err := cmd.Run()
+	res := &execResult{
+		StdOut:    strings.Trim(stdout.String(), "\n"),
+		StdErr:    strings.Trim(stderr.String(), "\n"),
+		Code:      -1,
+		Duration:  time.Since(start).Milliseconds(),
+		TimedOut:  errors.Is(ctx.Err(), context.DeadlineExceeded),
+		Truncated: stdout.truncated || stderr.truncated,
+	}
+	if cmd.ProcessState != nil {
+		res.Code = cmd.ProcessState.ExitCode()
+	}
+
+	successCodes := opts.SuccessCodes
+	if len(successCodes) == 0 {
+		successCodes = []int{0}
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case res.TimedOut:
+		err = fmt.Errorf("command timed out after %dms", opts.Timeout)
+	case err == nil || errors.As(err, &exitErr):
+		err = nil
+		if !slices.Contains(successCodes, res.Code) {
+			err = fmt.Errorf("command exited with code %d", res.Code)
+		}
+	}
+	if err != nil {
+		return nil, &ResultError{Err: err, Result: utils.ReturnRaw(res)}
+	}
+	return utils.ReturnRaw(res), nil
+}
+
+// limitedBuffer keeps at most limit bytes and discards the rest
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	limit     int // 0 is unlimited
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.limit > 0 && b.buf.Len()+len(p) > b.limit {
+		b.truncated = true
+		b.buf.Write(p[:max(b.limit-b.buf.Len(), 0)])
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) String() string {
+	return b.buf.String()
 }
 
 func (f *fnOs) env(j json.RawMessage) (json.RawMessage, error) {
@@ -0,0 +1,56 @@
+//go:build !windows
+
+package fn
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestOsExec(t *testing.T) {
+	f := New("test")
+	dir := t.TempDir()
+
+	tests := []struct {
+		name   string
+		action string
+		params map[string]any
+		fail   bool
+		want   map[string]string
+	}{
+		{"stdin", "os.exec", map[string]any{"command": "cat", "stdin": "hello"}, false, map[string]string{"stdout": "hello", "code": "0"}},
+		{"env and dir", "os.shell", map[string]any{"script": "echo $GREETING; pwd", "env": map[string]any{"GREETING": "hi"}, "dir": dir}, false, map[string]string{"stdout": "hi\n" + dir}},
+		{"no inherited env", "os.shell", map[string]any{"script": "echo \"[$HOME]\"", "inherit_env": false}, false, map[string]string{"stdout": "[]"}},
+		{"exit code", "os.shell", map[string]any{"script": "echo out; echo err >&2; exit 3"}, true, map[string]string{"stdout": "out", "stderr": "err", "code": "3"}},
+		{"success codes", "os.shell", map[string]any{"script": "exit 1", "success_codes": []int{0, 1}}, false, map[string]string{"code": "1"}},
+		{"timeout", "os.exec", map[string]any{"command": "sleep", "arguments": []string{"5"}, "timeout": 100}, true, map[string]string{"timed_out": "true"}},
+		{"max output", "os.shell", map[string]any{"script": "echo 0123456789", "max_output": 4}, false, map[string]string{"stdout": "0123", "truncated": "true"}},
+		{"not found", "os.exec", map[string]any{"command": "coda-missing-command"}, true, map[string]string{"code": "-1"}},
+	}
+	for _, tt := range tests {
+		out, err := callFn(t, f, tt.action, tt.params)
+		if tt.fail {
+			var resultErr *ResultError
+			if !errors.As(err, &resultErr) {
+				t.Errorf("%s: expected a failure with result, got %v", tt.name, err)
+				continue
+			}
+			out = resultErr.Result
+		} else if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		for path, want := range tt.want {
+			if got := gjson.GetBytes(out, path).String(); got != want {
+				t.Errorf("%s: %s = %q, want %q (%s)", tt.name, path, got, want, out)
+			}
+		}
+		var res execResult
+		if err := json.Unmarshal(out, &res); err != nil {
+			t.Errorf("%s: invalid result: %v", tt.name, err)
+		}
+	}
+}
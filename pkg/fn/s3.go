@@ -1,43 +1,74 @@
 package fn
 
 import (
+	"cmp"
 	"context"
+	"crypto/md5"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/yosev/coda/internal/utils"
 )
 
 type fnS3 struct {
+	*Fn
 	category FnCategory
 }
 
+// s3ConnectionParameters are shared by all s3 actions, they are required
+// unless provided by the referenced connection
+var s3ConnectionParameters = []FnParameter{
+	connectionParameter,
+	{Name: "endpoint", Description: "The S3 endpoint to use (default AWS)", Mandatory: false},
+	{Name: "bucket", Description: "The S3 bucket to use", Mandatory: false},
+	{Name: "region", Description: "The S3 region to use", Mandatory: false},
+	{Name: "key_id", Description: "The S3 key ID to use (default AWS credential chain)", Mandatory: false},
+	{Name: "key_secret", Description: "The S3 key secret to use", Mandatory: false},
+}
+
+func s3Parameters(params ...FnParameter) []FnParameter {
+	return append(slices.Clone(s3ConnectionParameters), params...)
+}
+
 func (f *fnS3) init(fn *Fn) {
+	f.Fn = fn
 	fn.register("s3.upload", &FnEntry{
 		Handler:     f.upload,
 		Name:        "Upload to S3",
-		Description: "Uploads a file or folder to an S3-compatible bucket",
+		Description: "Uploads a file or folder to an S3-compatible bucket, large files are uploaded in parts",
 		Category:    f.category,
-		Parameters: []FnParameter{
-			{Name: "endpoint", Description: "The S3 endpoint to use", Mandatory: true},
-			{Name: "bucket", Description: "The S3 bucket to use", Mandatory: true},
-			{Name: "region", Description: "The S3 region to use", Mandatory: true},
-			{Name: "key_id", Description: "The S3 key ID to use", Mandatory: true},
-			{Name: "key_secret", Description: "The S3 key secret to use", Mandatory: true},
-			{Name: "local_path", Description: "The local path to upload", Mandatory: true},
-			{Name: "remote_path", Description: "The remote path in the S3 bucket", Mandatory: false},
-			{Name: "remote_prefix", Description: "The remote prefix in the S3 bucket (for recursive upload)", Mandatory: false},
-			{Name: "invisible_files", Description: "If true, invisible files will be uploaded", Type: "boolean", Mandatory: false},
-		},
+		Parameters: s3Parameters(
+			FnParameter{Name: "local_path", Description: "The local path or blob reference to upload", Mandatory: true},
+			FnParameter{Name: "remote_path", Description: "The remote path in the S3 bucket", Mandatory: false},
+			FnParameter{Name: "remote_prefix", Description: "The remote prefix in the S3 bucket (for recursive upload)", Mandatory: false},
+			FnParameter{Name: "invisible_files", Description: "If true, invisible files will be uploaded", Type: "boolean", Mandatory: false},
+			FnParameter{Name: "concurrency", Description: "The number of files uploaded in parallel (default 4)", Type: "integer", Mandatory: false},
+			FnParameter{Name: "content_type", Description: "The content type of the objects (default detected from the extension or content)", Mandatory: false},
+			FnParameter{Name: "metadata", Description: "User metadata stored with the objects", Type: "object", Mandatory: false},
+			FnParameter{Name: "cache_control", Description: "The Cache-Control header of the objects", Mandatory: false},
+			FnParameter{Name: "storage_class", Description: "The storage class of the objects (e.g. STANDARD_IA)", Mandatory: false},
+			FnParameter{Name: "acl", Description: "The canned ACL of the objects (default private)", Mandatory: false},
+			FnParameter{Name: "sse", Description: "The server side encryption of the objects", Enum: []string{"AES256", "aws:kms"}, Mandatory: false},
+			FnParameter{Name: "sse_kms_key_id", Description: "The KMS key to encrypt the objects with aws:kms", Mandatory: false},
+			FnParameter{Name: "part_size", Description: "The part size in bytes of multipart uploads of large files (minimum 5MB)", Type: "integer", Mandatory: false},
+		),
 	})
 
 	fn.register("s3.download", &FnEntry{
@@ -45,40 +76,172 @@ func (f *fnS3) init(fn *Fn) {
 		Name:        "Download from S3",
 		Description: "Downloads a file or folder from S3 to the local filesystem",
 		Category:    f.category,
-		Parameters: []FnParameter{
-			{Name: "endpoint", Description: "The S3 endpoint to use", Mandatory: true},
-			{Name: "bucket", Description: "The S3 bucket to use", Mandatory: true},
-			{Name: "region", Description: "The S3 region to use", Mandatory: true},
-			{Name: "key_id", Description: "The S3 key ID to use", Mandatory: true},
-			{Name: "key_secret", Description: "The S3 key secret to use", Mandatory: true},
-			{Name: "local_path", Description: "The local path to download to", Mandatory: true},
-			{Name: "remote_path", Description: "The remote path in the S3 bucket", Mandatory: false},
-		},
+		Parameters: s3Parameters(
+			FnParameter{Name: "local_path", Description: "The local path to download to", Mandatory: true},
+			FnParameter{Name: "remote_path", Description: "The remote path in the S3 bucket", Mandatory: false},
+			FnParameter{Name: "concurrency", Description: "The number of files downloaded in parallel (default 4)", Type: "integer", Mandatory: false},
+		),
+	})
+
+	fn.register("s3.list", &FnEntry{
+		Handler:     f.list,
+		Name:        "List S3 objects",
+		Description: "Lists the objects of a bucket as {objects: [{key, size, etag, last_modified}], prefixes}",
+		Category:    f.category,
+		Parameters: s3Parameters(
+			FnParameter{Name: "prefix", Description: "Only list keys starting with the prefix", Mandatory: false},
+			FnParameter{Name: "delimiter", Description: "Group keys containing the delimiter after the prefix into prefixes (e.g. /)", Mandatory: false},
+			FnParameter{Name: "max_keys", Description: "The maximum number of keys to return (default all)", Type: "integer", Mandatory: false},
+		),
+	})
+
+	fn.register("s3.delete", &FnEntry{
+		Handler:     f.delete,
+		Name:        "Delete from S3",
+		Description: "Deletes a single object or all objects below a prefix",
+		Category:    f.category,
+		Parameters: s3Parameters(
+			FnParameter{Name: "key", Description: "The key of the object to delete", Mandatory: false},
+			FnParameter{Name: "prefix", Description: "Delete all objects below the prefix instead of a single key", Mandatory: false},
+			FnParameter{Name: "all", Description: "Must be true to delete every object of the bucket, without or with an empty prefix", Type: "boolean", Mandatory: false},
+		),
+	})
+
+	fn.register("s3.copy", &FnEntry{
+		Handler:     f.copy,
+		Name:        "Copy S3 objects",
+		Description: "Copies an object or all objects below a prefix to another key or bucket",
+		Category:    f.category,
+		Parameters:  s3Parameters(s3CopyParameters...),
+	})
+
+	fn.register("s3.move", &FnEntry{
+		Handler:     f.move,
+		Name:        "Move S3 objects",
+		Description: "Moves an object or all objects below a prefix to another key or bucket",
+		Category:    f.category,
+		Parameters:  s3Parameters(s3CopyParameters...),
+	})
+
+	fn.register("s3.head", &FnEntry{
+		Handler:     f.head,
+		Name:        "S3 object info",
+		Description: "Returns {key, size, etag, content_type, last_modified, metadata} of an object",
+		Category:    f.category,
+		Parameters: s3Parameters(
+			FnParameter{Name: "key", Description: "The key of the object", Mandatory: true},
+		),
+	})
+
+	fn.register("s3.presign", &FnEntry{
+		Handler:     f.presign,
+		Name:        "Presign S3 url",
+		Description: "Creates a presigned url to download (GET) or upload (PUT) an object without credentials",
+		Category:    f.category,
+		Parameters: s3Parameters(
+			FnParameter{Name: "key", Description: "The key of the object", Mandatory: true},
+			FnParameter{Name: "method", Description: "The method the url is signed for", Enum: []string{"GET", "PUT"}, Mandatory: false},
+			FnParameter{Name: "expires", Description: "The validity of the url in milliseconds (default 15 minutes)", Type: "integer", Mandatory: false},
+			FnParameter{Name: "content_type", Description: "The content type a PUT request must send", Mandatory: false},
+		),
+	})
+
+	fn.register("s3.sync", &FnEntry{
+		Handler:     f.sync,
+		Name:        "Sync with S3",
+		Description: "Uploads or downloads the files of a folder that differ by size, ETag or modify date",
+		Category:    f.category,
+		Parameters: s3Parameters(
+			FnParameter{Name: "direction", Description: "upload syncs the local folder to the bucket, download the bucket to the local folder", Enum: []string{"upload", "download"}, Mandatory: true},
+			FnParameter{Name: "local_path", Description: "The local folder", Mandatory: true},
+			FnParameter{Name: "remote_prefix", Description: "The remote prefix in the S3 bucket", Mandatory: false},
+			FnParameter{Name: "delete", Description: "If true, files missing in the source are deleted from the destination", Type: "boolean", Mandatory: false},
+			FnParameter{Name: "invisible_files", Description: "If true, invisible files will be synced", Type: "boolean", Mandatory: false},
+		),
 	})
 }
 
-type s3Params struct {
+var s3CopyParameters = []FnParameter{
+	{Name: "source_key", Description: "The key of the source object, or the source prefix if recursive", Mandatory: true},
+	{Name: "destination_key", Description: "The destination key, or the destination prefix if recursive (default source key)", Mandatory: false},
+	{Name: "source_bucket", Description: "The source bucket (default bucket)", Mandatory: false},
+	{Name: "destination_bucket", Description: "The destination bucket (default bucket)", Mandatory: false},
+	{Name: "recursive", Description: "If true, all objects below the source prefix are copied", Type: "boolean", Mandatory: false},
+}
+
+// s3Connection holds the endpoint and credentials of an S3-compatible storage
+type s3Connection struct {
 	Endpoint  string `json:"endpoint" yaml:"endpoint"`
 	Bucket    string `json:"bucket" yaml:"bucket"`
 	Region    string `json:"region" yaml:"region"`
 	KeyId     string `json:"key_id" yaml:"key_id"`
 	KeySecret string `json:"key_secret" yaml:"key_secret"`
+}
+
+type s3Params struct {
+	s3Connection
+	s3UploadOptions
 
 	LocalPath    string `json:"local_path" yaml:"local_path"`                           // Local file or directory
 	RemotePath   string `json:"remote_path,omitempty" yaml:"remote_path,omitempty"`     // S3 file key (for single file)
 	RemotePrefix string `json:"remote_prefix,omitempty" yaml:"remote_prefix,omitempty"` // S3 folder key (for recursive)
 
 	InvisibleFiles bool `json:"invisible_files,omitempty" yaml:"invisible_files,omitempty"` // Whether to include files starting with a dot (.)
+	Concurrency    int  `json:"concurrency,omitempty" yaml:"concurrency,omitempty"`         // Files transferred in parallel
+}
+
+// s3UploadOptions configure the objects created by uploads
+type s3UploadOptions struct {
+	ContentType  string            `json:"content_type,omitempty" yaml:"content_type,omitempty"` // detected from the extension or content if empty
+	Metadata     map[string]string `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+	CacheControl string            `json:"cache_control,omitempty" yaml:"cache_control,omitempty"`
+	StorageClass string            `json:"storage_class,omitempty" yaml:"storage_class,omitempty"`
+	ACL          string            `json:"acl,omitempty" yaml:"acl,omitempty"`
+	SSE          string            `json:"sse,omitempty" yaml:"sse,omitempty"`
+	SSEKMSKeyId  string            `json:"sse_kms_key_id,omitempty" yaml:"sse_kms_key_id,omitempty"`
+	PartSize     int64             `json:"part_size,omitempty" yaml:"part_size,omitempty"` // bytes per part of multipart uploads
+}
+
+// s3Transfer is the result of a single uploaded or downloaded file
+type s3Transfer struct {
+	Key         string `json:"key"`
+	Path        string `json:"path,omitempty"`
+	ETag        string `json:"etag,omitempty"`
+	Size        int64  `json:"size"` // bytes transferred
+	ContentType string `json:"content_type,omitempty"`
 }
 
 // UploadToS3 uploads a single file or folder to an S3-compatible bucket
 func (f *fnS3) upload(j json.RawMessage) (json.RawMessage, error) {
 	return utils.HandleJSON(j, func(params *s3Params) (json.RawMessage, error) {
-		client, err := buildS3Client(params)
+		client, err := f.s3Client(&params.s3Connection)
 		if err != nil {
 			return nil, err
 		}
 
+		if blob, size, ok, err := f.openBlob(params.LocalPath); ok {
+			if err != nil {
+				return nil, err
+			}
+			defer blob.Close()
+			if params.RemotePath == "" {
+				return nil, fmt.Errorf("remote_path is required to upload a blob")
+			}
+			key := filepath.ToSlash(params.RemotePath)
+			transfer, err := putObject(client, params.Bucket, blob, size, key, &params.s3UploadOptions)
+			if err != nil {
+				return nil, err
+			}
+			return json.Marshal(map[string]interface{}{
+				"message":  "upload successful",
+				"uploaded": []string{transfer.Key},
+				"files":    []s3Transfer{transfer},
+			})
+		}
+
+		if err := f.checkRead(params.LocalPath); err != nil {
+			return nil, err
+		}
 		info, err := os.Stat(params.LocalPath)
 		if err != nil {
 			return nil, fmt.Errorf("cannot access local path: %w", err)
@@ -89,7 +252,7 @@ func (f *fnS3) upload(j json.RawMessage) (json.RawMessage, error) {
 			prefix += "/"
 		}
 
-		var uploaded []string
+		var paths, keys []string
 
 		if info.IsDir() {
 			err = filepath.Walk(params.LocalPath, func(path string, fi os.FileInfo, err error) error {
@@ -103,16 +266,17 @@ func (f *fnS3) upload(j json.RawMessage) (json.RawMessage, error) {
 					return nil // Skip invisible files
 				}
 
+				// symlinks are reported as files, the target must be allowed as well
+				if err := f.checkRead(path); err != nil {
+					return err
+				}
 				relPath, err := filepath.Rel(params.LocalPath, path)
 				if err != nil {
 					return err
 				}
 
-				key := filepath.ToSlash(filepath.Join(prefix, relPath))
-				if err := uploadFile(client, params.Bucket, path, key); err != nil {
-					return err
-				}
-				uploaded = append(uploaded, key)
+				paths = append(paths, path)
+				keys = append(keys, filepath.ToSlash(filepath.Join(prefix, relPath)))
 				return nil
 			})
 			if err != nil {
@@ -123,15 +287,28 @@ func (f *fnS3) upload(j json.RawMessage) (json.RawMessage, error) {
 			if params.RemotePath != "" {
 				key = filepath.ToSlash(params.RemotePath)
 			}
-			if err := uploadFile(client, params.Bucket, params.LocalPath, key); err != nil {
-				return nil, err
-			}
-			uploaded = append(uploaded, key)
+			paths = append(paths, params.LocalPath)
+			keys = append(keys, key)
+		}
+
+		files := make([]s3Transfer, len(paths))
+		err = forEachConcurrent(len(paths), params.Concurrency, func(i int) error {
+			transfer, err := uploadFile(client, params.Bucket, paths[i], keys[i], &params.s3UploadOptions)
+			files[i] = transfer
+			return err
+		})
+		if err != nil {
+			return nil, err
 		}
 
+		uploaded := make([]string, len(files))
+		for i, file := range files {
+			uploaded[i] = file.Key
+		}
 		return json.Marshal(map[string]interface{}{
 			"message":  "upload successful",
 			"uploaded": uploaded,
+			"files":    files,
 		})
 	})
 }
@@ -139,7 +316,7 @@ func (f *fnS3) upload(j json.RawMessage) (json.RawMessage, error) {
 // DownloadFromS3 downloads a file or folder from S3 to the local filesystem
 func (f *fnS3) download(j json.RawMessage) (json.RawMessage, error) {
 	return utils.HandleJSON(j, func(params *s3Params) (json.RawMessage, error) {
-		client, err := buildS3Client(params)
+		client, err := f.s3Client(&params.s3Connection)
 		if err != nil {
 			return nil, err
 		}
@@ -177,12 +354,17 @@ func (f *fnS3) download(j json.RawMessage) (json.RawMessage, error) {
 		if !isFolder {
 			// === Single file download ===
 			target := params.LocalPath
-			if err := downloadFile(client, params.Bucket, remotePath, target); err != nil {
+			if err := f.checkWrite(target); err != nil {
+				return nil, err
+			}
+			transfer, err := downloadFile(client, params.Bucket, remotePath, target)
+			if err != nil {
 				return nil, fmt.Errorf("failed to download file '%s': %w", remotePath, err)
 			}
 			return json.Marshal(map[string]interface{}{
 				"message": "single file download successful",
 				"file":    target,
+				"files":   []s3Transfer{transfer},
 			})
 		}
 
@@ -191,7 +373,7 @@ func (f *fnS3) download(j json.RawMessage) (json.RawMessage, error) {
 			prefix += "/"
 		}
 
-		var downloaded []string
+		var keys, downloaded []string
 		paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
 			Bucket: aws.String(params.Bucket),
 			Prefix: aws.String(prefix),
@@ -219,29 +401,522 @@ func (f *fnS3) download(j json.RawMessage) (json.RawMessage, error) {
 					continue
 				}
 
-				localPath := filepath.Join(params.LocalPath, relPath)
-				if err := downloadFile(client, params.Bucket, key, localPath); err != nil {
-					return nil, fmt.Errorf("failed to download key '%s': %w", key, err)
+				if !filepath.IsLocal(filepath.FromSlash(relPath)) {
+					return nil, fmt.Errorf("unsafe key '%s' cannot be downloaded", key)
 				}
+				localPath := filepath.Join(params.LocalPath, filepath.FromSlash(relPath))
+				if err := f.checkWrite(localPath); err != nil {
+					return nil, err
+				}
+				keys = append(keys, key)
 				downloaded = append(downloaded, localPath)
 			}
 		}
 
+		files := make([]s3Transfer, len(keys))
+		err = forEachConcurrent(len(keys), params.Concurrency, func(i int) error {
+			transfer, err := downloadFile(client, params.Bucket, keys[i], downloaded[i])
+			if err != nil {
+				return fmt.Errorf("failed to download key '%s': %w", keys[i], err)
+			}
+			files[i] = transfer
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
 		return json.Marshal(map[string]interface{}{
 			"message":    "folder or bucket download successful",
 			"downloaded": downloaded,
+			"files":      files,
 		})
 	})
 }
 
+type s3ListParams struct {
+	s3Connection
+	Prefix    string `json:"prefix,omitempty" yaml:"prefix,omitempty"`
+	Delimiter string `json:"delimiter,omitempty" yaml:"delimiter,omitempty"`
+	MaxKeys   int    `json:"max_keys,omitempty" yaml:"max_keys,omitempty"`
+}
+
+// s3Object is a single object returned by s3.list
+type s3Object struct {
+	Key          string `json:"key"`
+	Size         int64  `json:"size"`
+	ETag         string `json:"etag"`
+	LastModified int64  `json:"last_modified"` // unix timestamp in milliseconds
+}
+
+func (f *fnS3) list(j json.RawMessage) (json.RawMessage, error) {
+	return utils.HandleJSON(j, func(params *s3ListParams) (json.RawMessage, error) {
+		client, err := f.s3Client(&params.s3Connection)
+		if err != nil {
+			return nil, err
+		}
+
+		objects := []s3Object{}
+		prefixes := []string{}
+		input := &s3.ListObjectsV2Input{
+			Bucket: aws.String(params.Bucket),
+			Prefix: aws.String(params.Prefix),
+		}
+		if params.Delimiter != "" {
+			input.Delimiter = aws.String(params.Delimiter)
+		}
+		if params.MaxKeys > 0 {
+			input.MaxKeys = aws.Int32(int32(min(params.MaxKeys, 1000)))
+		}
+		// max_keys limits objects and prefixes together, like the API does
+		full := func() bool {
+			return params.MaxKeys > 0 && len(objects)+len(prefixes) >= params.MaxKeys
+		}
+		paginator := s3.NewListObjectsV2Paginator(client, input)
+		for paginator.HasMorePages() && !full() {
+			page, err := paginator.NextPage(context.TODO())
+			if err != nil {
+				return nil, fmt.Errorf("list objects: %w", err)
+			}
+			// both lists are sorted by key, they are merged to keep the first keys
+			contents, common := page.Contents, page.CommonPrefixes
+			for (len(contents) > 0 || len(common) > 0) && !full() {
+				if len(common) == 0 || (len(contents) > 0 && aws.ToString(contents[0].Key) < aws.ToString(common[0].Prefix)) {
+					objects = append(objects, newS3Object(contents[0]))
+					contents = contents[1:]
+				} else {
+					prefixes = append(prefixes, aws.ToString(common[0].Prefix))
+					common = common[1:]
+				}
+			}
+		}
+
+		return utils.ReturnRaw(map[string]any{
+			"objects":  objects,
+			"prefixes": prefixes,
+		}), nil
+	})
+}
+
+type s3DeleteParams struct {
+	s3Connection
+	Key    string `json:"key,omitempty" yaml:"key,omitempty"`
+	Prefix string `json:"prefix,omitempty" yaml:"prefix,omitempty"`
+	All    bool   `json:"all,omitempty" yaml:"all,omitempty"`
+}
+
+func (f *fnS3) delete(j json.RawMessage) (json.RawMessage, error) {
+	return utils.HandleJSON(j, func(params *s3DeleteParams) (json.RawMessage, error) {
+		key := strings.TrimLeft(params.Key, "/")
+		prefix := strings.TrimLeft(params.Prefix, "/")
+		if params.Prefix != "" && prefix == "" && !params.All {
+			return nil, fmt.Errorf("prefix '%s' selects the whole bucket, set all to delete every object", params.Prefix)
+		}
+		if params.All && key != "" {
+			return nil, fmt.Errorf("key cannot be combined with all")
+		}
+		if !params.All && (key == "") == (prefix == "") {
+			return nil, fmt.Errorf("either key or prefix is required")
+		}
+		client, err := f.s3Client(&params.s3Connection)
+		if err != nil {
+			return nil, err
+		}
+
+		keys := []string{key}
+		if key == "" {
+			objects, err := listObjects(client, params.Bucket, prefix)
+			if err != nil {
+				return nil, err
+			}
+			keys = keys[:0]
+			for _, obj := range objects {
+				keys = append(keys, aws.ToString(obj.Key))
+			}
+		}
+		if err := deleteObjects(client, params.Bucket, keys); err != nil {
+			return nil, err
+		}
+
+		return utils.ReturnRaw(map[string]any{
+			"deleted": keys,
+		}), nil
+	})
+}
+
+type s3CopyParams struct {
+	s3Connection
+	SourceKey         string `json:"source_key" yaml:"source_key"`
+	DestinationKey    string `json:"destination_key,omitempty" yaml:"destination_key,omitempty"`
+	SourceBucket      string `json:"source_bucket,omitempty" yaml:"source_bucket,omitempty"`
+	DestinationBucket string `json:"destination_bucket,omitempty" yaml:"destination_bucket,omitempty"`
+	Recursive         bool   `json:"recursive,omitempty" yaml:"recursive,omitempty"`
+}
+
+type s3CopiedObject struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+}
+
+func (f *fnS3) copy(j json.RawMessage) (json.RawMessage, error) {
+	return utils.HandleJSON(j, func(params *s3CopyParams) (json.RawMessage, error) {
+		copied, err := f.copyObjects(params, false)
+		if err != nil {
+			return nil, err
+		}
+		return utils.ReturnRaw(map[string]any{
+			"copied": copied,
+		}), nil
+	})
+}
+
+func (f *fnS3) move(j json.RawMessage) (json.RawMessage, error) {
+	return utils.HandleJSON(j, func(params *s3CopyParams) (json.RawMessage, error) {
+		moved, err := f.copyObjects(params, true)
+		if err != nil {
+			return nil, err
+		}
+		return utils.ReturnRaw(map[string]any{
+			"moved": moved,
+		}), nil
+	})
+}
+
+// copyObjects copies the source key or prefix and deletes the sources
+// afterwards if move is set
+func (f *fnS3) copyObjects(params *s3CopyParams, move bool) ([]s3CopiedObject, error) {
+	client, err := f.s3Client(&params.s3Connection)
+	if err != nil {
+		return nil, err
+	}
+	sourceBucket := cmp.Or(params.SourceBucket, params.Bucket)
+	destinationBucket := cmp.Or(params.DestinationBucket, params.Bucket)
+	sourceKey := strings.TrimPrefix(params.SourceKey, "/")
+	destinationKey := strings.TrimPrefix(cmp.Or(params.DestinationKey, params.SourceKey), "/")
+	if sourceKey == "" {
+		return nil, fmt.Errorf("source_key cannot be empty")
+	}
+	if sourceBucket == destinationBucket && sourceKey == destinationKey {
+		return nil, fmt.Errorf("source and destination are the same")
+	}
+
+	copied := []s3CopiedObject{{Source: sourceKey, Destination: destinationKey}}
+	if params.Recursive {
+		objects, err := listObjects(client, sourceBucket, sourceKey)
+		if err != nil {
+			return nil, err
+		}
+		copied = copied[:0]
+		for _, obj := range objects {
+			key := aws.ToString(obj.Key)
+			copied = append(copied, s3CopiedObject{
+				Source:      key,
+				Destination: destinationKey + strings.TrimPrefix(key, sourceKey),
+			})
+		}
+	}
+
+	sources := make([]string, 0, len(copied))
+	for _, c := range copied {
+		_, err := client.CopyObject(context.TODO(), &s3.CopyObjectInput{
+			Bucket:     aws.String(destinationBucket),
+			Key:        aws.String(c.Destination),
+			CopySource: aws.String(copySource(sourceBucket, c.Source)),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("copy object '%s': %w", c.Source, err)
+		}
+		sources = append(sources, c.Source)
+	}
+	if move {
+		if err := deleteObjects(client, sourceBucket, sources); err != nil {
+			return nil, err
+		}
+	}
+	return copied, nil
+}
+
+type s3KeyParams struct {
+	s3Connection
+	Key string `json:"key" yaml:"key"`
+}
+
+func (f *fnS3) head(j json.RawMessage) (json.RawMessage, error) {
+	return utils.HandleJSON(j, func(params *s3KeyParams) (json.RawMessage, error) {
+		client, err := f.s3Client(&params.s3Connection)
+		if err != nil {
+			return nil, err
+		}
+		key := strings.TrimPrefix(params.Key, "/")
+		out, err := client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+			Bucket: aws.String(params.Bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("head object '%s': %w", key, err)
+		}
+
+		metadata := out.Metadata
+		if metadata == nil {
+			metadata = map[string]string{}
+		}
+		return utils.ReturnRaw(map[string]any{
+			"key":           key,
+			"size":          aws.ToInt64(out.ContentLength),
+			"etag":          strings.Trim(aws.ToString(out.ETag), `"`),
+			"content_type":  aws.ToString(out.ContentType),
+			"last_modified": aws.ToTime(out.LastModified).UnixMilli(),
+			"metadata":      metadata,
+		}), nil
+	})
+}
+
+type s3PresignParams struct {
+	s3Connection
+	Key         string `json:"key" yaml:"key"`
+	Method      string `json:"method,omitempty" yaml:"method,omitempty"`
+	Expires     int64  `json:"expires,omitempty" yaml:"expires,omitempty"`
+	ContentType string `json:"content_type,omitempty" yaml:"content_type,omitempty"`
+}
+
+func (f *fnS3) presign(j json.RawMessage) (json.RawMessage, error) {
+	return utils.HandleJSON(j, func(params *s3PresignParams) (json.RawMessage, error) {
+		client, err := f.s3Client(&params.s3Connection)
+		if err != nil {
+			return nil, err
+		}
+		expires := 15 * time.Minute
+		if params.Expires > 0 {
+			expires = time.Duration(params.Expires) * time.Millisecond
+		}
+		key := strings.TrimPrefix(params.Key, "/")
+
+		presigner := s3.NewPresignClient(client, s3.WithPresignExpires(expires))
+		var request *v4.PresignedHTTPRequest
+		switch strings.ToUpper(cmp.Or(params.Method, "GET")) {
+		case "GET":
+			request, err = presigner.PresignGetObject(context.TODO(), &s3.GetObjectInput{
+				Bucket: aws.String(params.Bucket),
+				Key:    aws.String(key),
+			})
+		case "PUT":
+			input := &s3.PutObjectInput{
+				Bucket: aws.String(params.Bucket),
+				Key:    aws.String(key),
+			}
+			if params.ContentType != "" {
+				input.ContentType = aws.String(params.ContentType)
+			}
+			request, err = presigner.PresignPutObject(context.TODO(), input)
+		default:
+			return nil, fmt.Errorf("unsupported presign method: %s", params.Method)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("presign object '%s': %w", key, err)
+		}
+
+		headers := map[string]string{}
+		for name := range request.SignedHeader {
+			if !strings.EqualFold(name, "host") {
+				headers[name] = request.SignedHeader.Get(name)
+			}
+		}
+		return utils.ReturnRaw(map[string]any{
+			"url":        request.URL,
+			"method":     request.Method,
+			"headers":    headers,
+			"expires_at": time.Now().Add(expires).UnixMilli(),
+		}), nil
+	})
+}
+
+type s3SyncParams struct {
+	s3Connection
+	Direction      string `json:"direction" yaml:"direction"`
+	LocalPath      string `json:"local_path" yaml:"local_path"`
+	RemotePrefix   string `json:"remote_prefix,omitempty" yaml:"remote_prefix,omitempty"`
+	Delete         bool   `json:"delete,omitempty" yaml:"delete,omitempty"`
+	InvisibleFiles bool   `json:"invisible_files,omitempty" yaml:"invisible_files,omitempty"`
+}
+
+// sync compares the files below local_path with the objects below
+// remote_prefix by their relative path and transfers the changed ones
+func (f *fnS3) sync(j json.RawMessage) (json.RawMessage, error) {
+	return utils.HandleJSON(j, func(params *s3SyncParams) (json.RawMessage, error) {
+		upload := params.Direction == "upload"
+		if !upload && params.Direction != "download" {
+			return nil, fmt.Errorf("invalid direction: %s", params.Direction)
+		}
+		if upload {
+			if err := f.checkRead(params.LocalPath); err != nil {
+				return nil, err
+			}
+		} else {
+			if err := f.checkWrite(params.LocalPath); err != nil {
+				return nil, err
+			}
+			if err := os.MkdirAll(params.LocalPath, 0755); err != nil {
+				return nil, fmt.Errorf("create dir: %w", err)
+			}
+		}
+		client, err := f.s3Client(&params.s3Connection)
+		if err != nil {
+			return nil, err
+		}
+
+		prefix := strings.Trim(params.RemotePrefix, "/")
+		if prefix != "" {
+			prefix += "/"
+		}
+		entries, err := walkEntries(params.LocalPath, walkOptions{invisibleFiles: params.InvisibleFiles, entryType: "file"})
+		if err != nil {
+			return nil, fmt.Errorf("cannot access local path: %w", err)
+		}
+		local := map[string]fileEntry{}
+		for _, entry := range entries {
+			local[entry.Relative] = entry
+		}
+		objects, err := listObjects(client, params.Bucket, prefix)
+		if err != nil {
+			return nil, err
+		}
+		remote := map[string]types.Object{}
+		for _, obj := range objects {
+			rel := strings.TrimPrefix(aws.ToString(obj.Key), prefix)
+			if rel == "" || strings.HasSuffix(rel, "/") {
+				continue // Skip folder markers
+			}
+			if !params.InvisibleFiles && slices.ContainsFunc(strings.Split(rel, "/"), func(s string) bool { return strings.HasPrefix(s, ".") }) {
+				continue
+			}
+			remote[rel] = obj
+		}
+
+		transferred, skipped, deleted := []string{}, []string{}, []string{}
+		if upload {
+			var extraneous []string
+			for _, entry := range entries {
+				if err := f.checkRead(entry.Path); err != nil {
+					return nil, err
+				}
+				if obj, ok := remote[entry.Relative]; ok {
+					unchanged, err := s3Unchanged(entry, obj, true)
+					if err != nil {
+						return nil, err
+					}
+					if unchanged {
+						skipped = append(skipped, entry.Relative)
+						continue
+					}
+				}
+				if _, err := uploadFile(client, params.Bucket, entry.Path, prefix+entry.Relative, &s3UploadOptions{}); err != nil {
+					return nil, err
+				}
+				transferred = append(transferred, entry.Relative)
+			}
+			for rel := range remote {
+				if _, ok := local[rel]; !ok && params.Delete {
+					extraneous = append(extraneous, prefix+rel)
+					deleted = append(deleted, rel)
+				}
+			}
+			if err := deleteObjects(client, params.Bucket, extraneous); err != nil {
+				return nil, err
+			}
+		} else {
+			for _, obj := range objects {
+				rel := strings.TrimPrefix(aws.ToString(obj.Key), prefix)
+				if _, ok := remote[rel]; !ok {
+					continue
+				}
+				if !filepath.IsLocal(filepath.FromSlash(rel)) {
+					return nil, fmt.Errorf("unsafe key '%s' cannot be synced", aws.ToString(obj.Key))
+				}
+				target := filepath.Join(params.LocalPath, filepath.FromSlash(rel))
+				if err := f.checkWrite(target); err != nil {
+					return nil, err
+				}
+				if entry, ok := local[rel]; ok {
+					unchanged, err := s3Unchanged(entry, obj, false)
+					if err != nil {
+						return nil, err
+					}
+					if unchanged {
+						skipped = append(skipped, rel)
+						continue
+					}
+				}
+				if _, err := downloadFile(client, params.Bucket, aws.ToString(obj.Key), target); err != nil {
+					return nil, fmt.Errorf("failed to download key '%s': %w", aws.ToString(obj.Key), err)
+				}
+				// keep the remote modify date so the next sync can compare it
+				modified := aws.ToTime(obj.LastModified)
+				if err := os.Chtimes(target, modified, modified); err != nil {
+					return nil, fmt.Errorf("set modify date: %w", err)
+				}
+				transferred = append(transferred, rel)
+			}
+			for _, entry := range entries {
+				if _, ok := remote[entry.Relative]; ok || !params.Delete {
+					continue
+				}
+				if err := f.checkWrite(entry.Path); err != nil {
+					return nil, err
+				}
+				if err := os.Remove(entry.Path); err != nil {
+					return nil, fmt.Errorf("delete file: %w", err)
+				}
+				deleted = append(deleted, entry.Relative)
+			}
+		}
+		slices.Sort(deleted)
+
+		return utils.ReturnRaw(map[string]any{
+			"direction":   params.Direction,
+			"transferred": transferred,
+			"skipped":     skipped,
+			"deleted":     deleted,
+		}), nil
+	})
+}
+
 // Helpers
 
-func buildS3Client(params *s3Params) (*s3.Client, error) {
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion(params.Region),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
-			params.KeyId, params.KeySecret, "")),
-		config.WithEndpointResolverWithOptions(
+// s3Client returns the cached client of the connection, clients are shared
+// by all operations of the run using the same endpoint and credentials
+func (f *Fn) s3Client(params *s3Connection) (*s3.Client, error) {
+	if params.Bucket == "" {
+		return nil, fmt.Errorf("bucket is required")
+	}
+	key := *params
+	key.Bucket = "" // clients are not bound to a bucket
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if client, ok := f.s3Clients[key]; ok {
+		return client, nil
+	}
+	client, err := buildS3Client(params)
+	if err != nil {
+		return nil, err
+	}
+	f.s3Clients[key] = client
+	return client, nil
+}
+
+// buildS3Client creates a client for the endpoint, without key_id the
+// credentials and region are taken from the default AWS credential chain
+func buildS3Client(params *s3Connection) (*s3.Client, error) {
+	var options []func(*config.LoadOptions) error
+	if params.Region != "" {
+		options = append(options, config.WithRegion(params.Region))
+	}
+	if params.KeyId != "" {
+		options = append(options, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
+			params.KeyId, params.KeySecret, "")))
+	}
+	if params.Endpoint != "" {
+		options = append(options, config.WithEndpointResolverWithOptions(
 			aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
 				if service == s3.ServiceID {
 					return aws.Endpoint{
@@ -251,59 +926,237 @@ func buildS3Client(params *s3Params) (*s3.Client, error) {
 				}
 				return aws.Endpoint{}, fmt.Errorf("unknown endpoint requested")
 			}),
-		),
-	)
+		))
+	}
+	cfg, err := config.LoadDefaultConfig(context.TODO(), options...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load AWS config: %w", err)
 	}
 
 	return s3.NewFromConfig(cfg, func(o *s3.Options) {
-		o.UsePathStyle = true
+		o.UsePathStyle = params.Endpoint != ""
 	}), nil
 }
 
-func uploadFile(client *s3.Client, bucket, localPath, key string) error {
+func uploadFile(client *s3.Client, bucket, localPath, key string, opts *s3UploadOptions) (s3Transfer, error) {
 	file, err := os.Open(localPath)
 	if err != nil {
-		return fmt.Errorf("open file: %w", err)
+		return s3Transfer{}, fmt.Errorf("open file: %w", err)
 	}
 	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return s3Transfer{}, fmt.Errorf("stat file: %w", err)
+	}
 
-	_, err = client.PutObject(context.TODO(), &s3.PutObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(strings.TrimPrefix(key, "/")),
-		Body:   file,
-		ACL:    types.ObjectCannedACLPrivate,
+	transfer, err := putObject(client, bucket, file, info.Size(), key, opts)
+	transfer.Path = localPath
+	return transfer, err
+}
+
+// putObject uploads the body with the transfer manager, which splits large
+// bodies into parts uploaded in parallel
+func putObject(client *s3.Client, bucket string, body io.ReadSeeker, size int64, key string, opts *s3UploadOptions) (s3Transfer, error) {
+	key = strings.TrimPrefix(key, "/")
+	contentType := opts.ContentType
+	if contentType == "" {
+		var err error
+		if contentType, err = detectContentType(key, body); err != nil {
+			return s3Transfer{}, fmt.Errorf("detect content type: %w", err)
+		}
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        body,
+		ACL:         types.ObjectCannedACL(cmp.Or(opts.ACL, string(types.ObjectCannedACLPrivate))),
+		ContentType: aws.String(contentType),
+		Metadata:    opts.Metadata,
+	}
+	if opts.CacheControl != "" {
+		input.CacheControl = aws.String(opts.CacheControl)
+	}
+	if opts.StorageClass != "" {
+		input.StorageClass = types.StorageClass(opts.StorageClass)
+	}
+	if opts.SSE != "" {
+		input.ServerSideEncryption = types.ServerSideEncryption(opts.SSE)
+	}
+	if opts.SSEKMSKeyId != "" {
+		input.SSEKMSKeyId = aws.String(opts.SSEKMSKeyId)
+	}
+
+	uploader := manager.NewUploader(client, func(u *manager.Uploader) {
+		if opts.PartSize > 0 {
+			u.PartSize = opts.PartSize
+		}
 	})
+	out, err := uploader.Upload(context.TODO(), input)
 	if err != nil {
-		return fmt.Errorf("put object: %w", err)
+		return s3Transfer{}, fmt.Errorf("put object: %w", err)
 	}
-	return nil
+	return s3Transfer{
+		Key:         key,
+		ETag:        strings.Trim(aws.ToString(out.ETag), `"`),
+		Size:        size,
+		ContentType: contentType,
+	}, nil
 }
 
-func downloadFile(client *s3.Client, bucket, key, targetPath string) error {
+func downloadFile(client *s3.Client, bucket, key, targetPath string) (s3Transfer, error) {
 	resp, err := client.GetObject(context.TODO(), &s3.GetObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(strings.TrimPrefix(key, "/")),
 	})
 	if err != nil {
-		return fmt.Errorf("get object: %w", err)
+		return s3Transfer{}, fmt.Errorf("get object: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
-		return fmt.Errorf("create dir: %w", err)
+		return s3Transfer{}, fmt.Errorf("create dir: %w", err)
 	}
 
 	outFile, err := os.Create(targetPath)
 	if err != nil {
-		return fmt.Errorf("create file: %w", err)
+		return s3Transfer{}, fmt.Errorf("create file: %w", err)
 	}
 	defer outFile.Close()
 
-	_, err = io.Copy(outFile, resp.Body)
+	n, err := io.Copy(outFile, resp.Body)
 	if err != nil {
-		return fmt.Errorf("write file: %w", err)
+		return s3Transfer{}, fmt.Errorf("write file: %w", err)
+	}
+	return s3Transfer{
+		Key:         key,
+		Path:        targetPath,
+		ETag:        strings.Trim(aws.ToString(resp.ETag), `"`),
+		Size:        n,
+		ContentType: aws.ToString(resp.ContentType),
+	}, nil
+}
+
+// forEachConcurrent calls fn for the indexes below n with at most
+// concurrency calls running at once (default 4) and returns the first error
+func forEachConcurrent(n, concurrency int, fn func(i int) error) error {
+	if concurrency < 1 {
+		concurrency = 4
+	}
+	var (
+		wg     sync.WaitGroup
+		once   sync.Once
+		failed atomic.Bool
+		first  error
+	)
+	sem := make(chan struct{}, concurrency)
+	for i := 0; i < n && !failed.Load(); i++ {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := fn(i); err != nil {
+				once.Do(func() { first = err })
+				failed.Store(true)
+			}
+		}()
+	}
+	wg.Wait()
+	return first
+}
+
+func newS3Object(obj types.Object) s3Object {
+	return s3Object{
+		Key:          aws.ToString(obj.Key),
+		Size:         aws.ToInt64(obj.Size),
+		ETag:         strings.Trim(aws.ToString(obj.ETag), `"`),
+		LastModified: aws.ToTime(obj.LastModified).UnixMilli(),
+	}
+}
+
+// listObjects returns all objects below the prefix
+func listObjects(client *s3.Client, bucket, prefix string) ([]types.Object, error) {
+	var objects []types.Object
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, fmt.Errorf("list objects: %w", err)
+		}
+		objects = append(objects, page.Contents...)
+	}
+	return objects, nil
+}
+
+// deleteObjects deletes the keys in batches of the maximum of 1000 keys per request
+func deleteObjects(client *s3.Client, bucket string, keys []string) error {
+	for batch := range slices.Chunk(keys, 1000) {
+		identifiers := make([]types.ObjectIdentifier, len(batch))
+		for i, key := range batch {
+			identifiers[i] = types.ObjectIdentifier{Key: aws.String(key)}
+		}
+		out, err := client.DeleteObjects(context.TODO(), &s3.DeleteObjectsInput{
+			Bucket: aws.String(bucket),
+			Delete: &types.Delete{Objects: identifiers, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return fmt.Errorf("delete objects: %w", err)
+		}
+		if len(out.Errors) > 0 {
+			e := out.Errors[0]
+			return fmt.Errorf("delete object '%s': %s", aws.ToString(e.Key), aws.ToString(e.Message))
+		}
 	}
 	return nil
 }
+
+// copySource builds the url encoded bucket/key source of a copy request
+func copySource(bucket, key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return bucket + "/" + strings.Join(segments, "/")
+}
+
+// s3Unchanged compares a local file with an object by size and ETag. The
+// ETag of multipart uploads is no content hash, so the newer modify date
+// decides whether the destination is outdated.
+func s3Unchanged(entry fileEntry, obj types.Object, upload bool) (bool, error) {
+	if entry.Size != aws.ToInt64(obj.Size) {
+		return false, nil
+	}
+	etag := strings.Trim(aws.ToString(obj.ETag), `"`)
+	if etag != "" && !strings.Contains(etag, "-") {
+		sum, err := fileMD5(entry.Path)
+		if err != nil {
+			return false, err
+		}
+		return strings.EqualFold(sum, etag), nil
+	}
+	modified := aws.ToTime(obj.LastModified).UnixMilli()
+	if upload {
+		return modified >= entry.Modified, nil
+	}
+	return entry.Modified >= modified, nil
+}
+
+func fileMD5(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("open file: %w", err)
+	}
+	defer file.Close()
+
+	h := md5.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", fmt.Errorf("read file: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
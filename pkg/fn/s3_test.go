@@ -0,0 +1,267 @@
+package fn
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/tidwall/gjson"
+)
+
+func newS3Server(t *testing.T, buckets ...string) map[string]any {
+	t.Helper()
+	backend := s3mem.New()
+	for _, bucket := range buckets {
+		if err := backend.CreateBucket(bucket); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := httptest.NewServer(gofakes3.New(backend).Server())
+	t.Cleanup(server.Close)
+	return map[string]any{
+		"endpoint":   server.URL,
+		"bucket":     buckets[0],
+		"region":     "us-east-1",
+		"key_id":     "key",
+		"key_secret": "secret",
+	}
+}
+
+func s3Call(t *testing.T, f *Fn, action string, conn map[string]any, params map[string]any) gjson.Result {
+	t.Helper()
+	out, err := callFn(t, f, action, merge(conn, params))
+	if err != nil {
+		t.Fatalf("%s: %v", action, err)
+	}
+	return gjson.ParseBytes(out)
+}
+
+func merge(maps ...map[string]any) map[string]any {
+	merged := map[string]any{}
+	for _, m := range maps {
+		for k, v := range m {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+func stringsOf(r gjson.Result) []string {
+	values := []string{}
+	for _, v := range r.Array() {
+		values = append(values, v.String())
+	}
+	return values
+}
+
+func TestS3Objects(t *testing.T) {
+	f := New("test")
+	conn := newS3Server(t, "main", "archive")
+	dir := t.TempDir()
+	for name, content := range map[string]string{"a.txt": "a", "docs/b.txt": "bb", "docs/c.txt": "ccc"} {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte(content), 0644)
+	}
+	s3Call(t, f, "s3.upload", conn, map[string]any{"local_path": dir, "remote_prefix": "data"})
+
+	list := s3Call(t, f, "s3.list", conn, map[string]any{"prefix": "data/", "delimiter": "/"})
+	if keys := stringsOf(list.Get("objects.#.key")); !reflect.DeepEqual(keys, []string{"data/a.txt"}) {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+	if prefixes := stringsOf(list.Get("prefixes")); !reflect.DeepEqual(prefixes, []string{"data/docs/"}) {
+		t.Errorf("unexpected prefixes: %v", prefixes)
+	}
+	if list.Get("objects.0.etag").String() != "0cc175b9c0f1b6a831c399e269772661" {
+		t.Errorf("unexpected etag: %s", list.Get("objects.0.etag"))
+	}
+	if n := len(s3Call(t, f, "s3.list", conn, map[string]any{"max_keys": 2}).Get("objects").Array()); n != 2 {
+		t.Errorf("expected 2 objects, got %d", n)
+	}
+	limited := s3Call(t, f, "s3.list", conn, map[string]any{"prefix": "data/", "delimiter": "/", "max_keys": 1})
+	if keys, prefixes := stringsOf(limited.Get("objects.#.key")), stringsOf(limited.Get("prefixes")); !reflect.DeepEqual(keys, []string{"data/a.txt"}) || len(prefixes) != 0 {
+		t.Errorf("expected max_keys to limit objects and prefixes together, got %v and %v", keys, prefixes)
+	}
+
+	s3Call(t, f, "s3.copy", conn, map[string]any{"source_key": "data/a.txt", "destination_key": "unsafe/../../escaped.txt"})
+	if _, err := callFn(t, f, "s3.download", merge(conn, map[string]any{"remote_path": "unsafe/", "local_path": filepath.Join(dir, "download")})); err == nil || !strings.Contains(err.Error(), "unsafe key") {
+		t.Errorf("expected a key escaping the local path to be rejected, got %v", err)
+	}
+	s3Call(t, f, "s3.delete", conn, map[string]any{"key": "unsafe/../../escaped.txt"})
+
+	head := s3Call(t, f, "s3.head", conn, map[string]any{"key": "data/docs/c.txt"})
+	if head.Get("size").Int() != 3 || head.Get("last_modified").Int() == 0 {
+		t.Errorf("unexpected head: %s", head.Raw)
+	}
+
+	s3Call(t, f, "s3.copy", conn, map[string]any{"source_key": "data/docs/", "destination_key": "backup/", "destination_bucket": "archive", "recursive": true})
+	archived := s3Call(t, f, "s3.list", conn, map[string]any{"bucket": "archive"})
+	if keys := stringsOf(archived.Get("objects.#.key")); !reflect.DeepEqual(keys, []string{"backup/b.txt", "backup/c.txt"}) {
+		t.Errorf("unexpected copied keys: %v", keys)
+	}
+
+	s3Call(t, f, "s3.move", conn, map[string]any{"source_key": "data/a.txt", "destination_key": "moved/a.txt"})
+	if _, err := callFn(t, f, "s3.head", merge(conn, map[string]any{"key": "data/a.txt"})); err == nil {
+		t.Error("expected moved source to be deleted")
+	}
+
+	deleted := s3Call(t, f, "s3.delete", conn, map[string]any{"prefix": "data/"})
+	if keys := stringsOf(deleted.Get("deleted")); !reflect.DeepEqual(keys, []string{"data/docs/b.txt", "data/docs/c.txt"}) {
+		t.Errorf("unexpected deleted keys: %v", keys)
+	}
+	s3Call(t, f, "s3.delete", conn, map[string]any{"key": "moved/a.txt"})
+	if n := len(s3Call(t, f, "s3.list", conn, nil).Get("objects").Array()); n != 0 {
+		t.Errorf("expected empty bucket, got %d objects", n)
+	}
+	if _, err := callFn(t, f, "s3.delete", conn); err == nil {
+		t.Error("expected delete without key or prefix to fail")
+	}
+
+	s3Call(t, f, "s3.upload", conn, map[string]any{"local_path": dir, "remote_prefix": "data"})
+	for _, prefix := range []string{"/", "//"} {
+		if _, err := callFn(t, f, "s3.delete", merge(conn, map[string]any{"prefix": prefix})); err == nil {
+			t.Errorf("expected prefix %q to be rejected", prefix)
+		}
+	}
+	if n := len(s3Call(t, f, "s3.list", conn, nil).Get("objects").Array()); n != 3 {
+		t.Errorf("expected the rejected deletes to keep all objects, got %d", n)
+	}
+	if deleted := s3Call(t, f, "s3.delete", conn, map[string]any{"prefix": "/", "all": true}).Get("deleted"); len(deleted.Array()) != 3 {
+		t.Errorf("expected all objects to be deleted: %s", deleted.Raw)
+	}
+}
+
+func TestS3Presign(t *testing.T) {
+	f := New("test")
+	conn := newS3Server(t, "main")
+
+	put := s3Call(t, f, "s3.presign", conn, map[string]any{"key": "upload.txt", "method": "PUT"})
+	request, _ := http.NewRequest(http.MethodPut, put.Get("url").String(), strings.NewReader("presigned"))
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("presigned PUT failed: %d", response.StatusCode)
+	}
+
+	get := s3Call(t, f, "s3.presign", conn, map[string]any{"key": "upload.txt", "expires": 60000})
+	if !strings.Contains(get.Get("url").String(), "X-Amz-Expires=60") {
+		t.Errorf("expected expiry in url: %s", get.Get("url"))
+	}
+	response, err = http.Get(get.Get("url").String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if body, _ := io.ReadAll(response.Body); string(body) != "presigned" {
+		t.Errorf("unexpected body: %s", body)
+	}
+}
+
+func TestS3Sync(t *testing.T) {
+	f := New("test")
+	conn := newS3Server(t, "main")
+	src, dst := t.TempDir(), t.TempDir()
+	write := func(dir, name, content string) {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(src, "a.txt", "a")
+	write(src, "sub/b.txt", "b")
+	write(src, ".hidden", "h")
+
+	up := map[string]any{"direction": "upload", "local_path": src, "remote_prefix": "sync"}
+	if got := stringsOf(s3Call(t, f, "s3.sync", conn, up).Get("transferred")); !reflect.DeepEqual(got, []string{"a.txt", "sub/b.txt"}) {
+		t.Errorf("unexpected transferred files: %v", got)
+	}
+
+	write(src, "a.txt", "changed")
+	os.Remove(filepath.Join(src, "sub/b.txt"))
+	result := s3Call(t, f, "s3.sync", conn, merge(up, map[string]any{"delete": true}))
+	if got := stringsOf(result.Get("transferred")); !reflect.DeepEqual(got, []string{"a.txt"}) {
+		t.Errorf("unexpected transferred files: %v", got)
+	}
+	if got := stringsOf(result.Get("deleted")); !reflect.DeepEqual(got, []string{"sub/b.txt"}) {
+		t.Errorf("unexpected deleted files: %v", got)
+	}
+
+	write(dst, "stale.txt", "stale")
+	down := map[string]any{"direction": "download", "local_path": dst, "remote_prefix": "sync", "delete": true}
+	result = s3Call(t, f, "s3.sync", conn, down)
+	if got := stringsOf(result.Get("transferred")); !reflect.DeepEqual(got, []string{"a.txt"}) {
+		t.Errorf("unexpected transferred files: %v", got)
+	}
+	if got := stringsOf(result.Get("deleted")); !reflect.DeepEqual(got, []string{"stale.txt"}) {
+		t.Errorf("unexpected deleted files: %v", got)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dst, "a.txt")); string(b) != "changed" {
+		t.Errorf("unexpected content: %s", b)
+	}
+
+	result = s3Call(t, f, "s3.sync", conn, down)
+	if got := stringsOf(result.Get("skipped")); !reflect.DeepEqual(got, []string{"a.txt"}) || len(result.Get("transferred").Array()) != 0 {
+		t.Errorf("expected unchanged file to be skipped: %s", result.Raw)
+	}
+}
+
+func TestS3UploadOptions(t *testing.T) {
+	f := New("test")
+	conn := newS3Server(t, "main")
+	dir := t.TempDir()
+	for i := range 6 {
+		os.WriteFile(filepath.Join(dir, fmt.Sprintf("page%d.html", i)), []byte("<html></html>"), 0644)
+	}
+	large := bytes.Repeat([]byte("x"), 6<<20)
+	os.WriteFile(filepath.Join(dir, "large.bin"), large, 0644)
+
+	result := s3Call(t, f, "s3.upload", conn, map[string]any{
+		"local_path":    dir,
+		"remote_prefix": "site",
+		"concurrency":   3,
+		"part_size":     5 << 20,
+		"metadata":      map[string]string{"owner": "ops"},
+		"cache_control": "max-age=60",
+	})
+	files := result.Get("files").Array()
+	if len(files) != 7 || files[0].Get("key").String() != "site/large.bin" {
+		t.Fatalf("unexpected files: %s", result.Get("files").Raw)
+	}
+	if files[0].Get("size").Int() != int64(len(large)) || !strings.Contains(files[0].Get("etag").String(), "-") {
+		t.Errorf("expected multipart upload of large file: %s", files[0].Raw)
+	}
+	if files[1].Get("content_type").String() != "text/html; charset=utf-8" || files[1].Get("etag").String() == "" {
+		t.Errorf("unexpected file result: %s", files[1].Raw)
+	}
+
+	head := s3Call(t, f, "s3.head", conn, map[string]any{"key": "site/page1.html"})
+	if head.Get("content_type").String() != "text/html; charset=utf-8" || head.Get("metadata.owner").String() != "ops" {
+		t.Errorf("unexpected head: %s", head.Raw)
+	}
+	if head := s3Call(t, f, "s3.head", conn, map[string]any{"key": "site/large.bin"}); head.Get("content_type").String() != "application/octet-stream" {
+		t.Errorf("expected sniffed content type: %s", head.Raw)
+	}
+
+	target := t.TempDir()
+	result = s3Call(t, f, "s3.download", conn, map[string]any{"local_path": target, "remote_path": "site", "concurrency": 2})
+	files = result.Get("files").Array()
+	if len(files) != 7 || files[0].Get("size").Int() != int64(len(large)) {
+		t.Fatalf("unexpected downloaded files: %s", result.Get("files").Raw)
+	}
+	if b, _ := os.ReadFile(filepath.Join(target, "large.bin")); !bytes.Equal(b, large) {
+		t.Error("large file differs after download")
+	}
+}
@@ -0,0 +1,94 @@
+package fn
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrSandbox is returned when an action accesses a path outside of the sandbox
+var ErrSandbox = errors.New("sandbox violation")
+
+// SandboxRoot is a directory actions may access below
+type SandboxRoot struct {
+	Path     string `json:"path" yaml:"path"`
+	Writable bool   `json:"writable,omitempty" yaml:"writable,omitempty"` // read-only unless set
+}
+
+// Sandbox restricts the local paths of actions to a set of allowed roots.
+// Paths are resolved including symlinks and `..` before they are compared.
+type Sandbox struct {
+	roots []SandboxRoot // resolved roots
+}
+
+// NewSandbox creates a sandbox allowing access below the given roots
+func NewSandbox(roots ...SandboxRoot) (*Sandbox, error) {
+	s := &Sandbox{}
+	for _, root := range roots {
+		resolved, err := resolvePath(root.Path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sandbox root %s: %w", root.Path, err)
+		}
+		s.roots = append(s.roots, SandboxRoot{Path: resolved, Writable: root.Writable})
+	}
+	return s, nil
+}
+
+// Check fails if the path is outside of the allowed roots or if write access
+// is requested below a read-only root. A nil sandbox allows all paths.
+func (s *Sandbox) Check(path string, write bool) error {
+	if s == nil {
+		return nil
+	}
+	resolved, err := resolvePath(path)
+	if err != nil {
+		return fmt.Errorf("%w: cannot resolve %s: %s", ErrSandbox, path, err)
+	}
+
+	readOnly := false
+	for _, root := range s.roots {
+		if !isWithin(resolved, root.Path) {
+			continue
+		}
+		if !write || root.Writable {
+			return nil
+		}
+		readOnly = true
+	}
+	if readOnly {
+		return fmt.Errorf("%w: %s is read-only", ErrSandbox, path)
+	}
+	return fmt.Errorf("%w: %s is outside of the allowed roots", ErrSandbox, path)
+}
+
+// resolvePath returns the absolute path with all symlinks of its existing
+// part resolved, so paths to files that do not exist yet can be checked
+func resolvePath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	existing, rest := abs, ""
+	for {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			return filepath.Join(resolved, rest), nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return abs, nil
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = parent
+	}
+}
+
+func isWithin(path, root string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
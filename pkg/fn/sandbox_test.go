@@ -0,0 +1,100 @@
+package fn
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSandbox(t *testing.T) {
+	dir := t.TempDir()
+	data := filepath.Join(dir, "data")
+	config := filepath.Join(dir, "config")
+	outside := filepath.Join(dir, "outside")
+	for _, d := range []string{data, config, outside} {
+		os.MkdirAll(d, 0755)
+	}
+	os.Symlink(outside, filepath.Join(data, "escape"))
+
+	sandbox, err := NewSandbox(SandboxRoot{Path: data, Writable: true}, SandboxRoot{Path: config})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path  string
+		write bool
+		ok    bool
+	}{
+		{filepath.Join(data, "file.txt"), true, true},
+		{filepath.Join(data, "new", "nested", "file.txt"), true, true},
+		{filepath.Join(config, "app.yaml"), false, true},
+		{filepath.Join(config, "app.yaml"), true, false},
+		{filepath.Join(outside, "file.txt"), false, false},
+		{filepath.Join(data, "..", "outside", "file.txt"), false, false},
+		{filepath.Join(data, "escape", "file.txt"), false, false},
+		{data + "-sibling", false, false},
+	}
+	for _, tt := range tests {
+		err := sandbox.Check(tt.path, tt.write)
+		if tt.ok && err != nil {
+			t.Errorf("Check(%s, %v) returned error: %v", tt.path, tt.write, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrSandbox) {
+			t.Errorf("Check(%s, %v) = %v, want a sandbox violation", tt.path, tt.write, err)
+		}
+	}
+
+	f := New("test")
+	f.SetSandbox(sandbox)
+	if _, err := callFn(t, f, "file.write", map[string]any{"destination": filepath.Join(data, "ok.txt"), "value": "ok"}); err != nil {
+		t.Errorf("expected writing inside the sandbox to succeed: %v", err)
+	}
+	if _, err := callFn(t, f, "file.copy", map[string]any{"source": filepath.Join(data, "ok.txt"), "destination": filepath.Join(data, "escape", "copy.txt")}); !errors.Is(err, ErrSandbox) {
+		t.Errorf("expected copying through a symlink to fail, got %v", err)
+	}
+	if _, err := callFn(t, f, "file.glob", map[string]any{"pattern": filepath.Join(dir, "*", "*.txt")}); !errors.Is(err, ErrSandbox) {
+		t.Errorf("expected globbing outside of the sandbox to fail, got %v", err)
+	}
+	if _, err := callFn(t, f, "os.shell", map[string]any{"script": "true"}); err != nil {
+		t.Errorf("expected a command without dir to run under the sandbox: %v", err)
+	}
+	if _, err := callFn(t, f, "os.shell", map[string]any{"script": "true", "dir": config}); err != nil {
+		t.Errorf("expected a command to run in a read-only root: %v", err)
+	}
+	if _, err := callFn(t, f, "os.shell", map[string]any{"script": "true", "dir": outside}); !errors.Is(err, ErrSandbox) {
+		t.Errorf("expected a command outside of the sandbox to fail, got %v", err)
+	}
+}
+
+func TestSandboxSymlinkedFiles(t *testing.T) {
+	dir := t.TempDir()
+	upload := filepath.Join(dir, "upload")
+	os.MkdirAll(upload, 0755)
+	os.WriteFile(filepath.Join(upload, "ok.txt"), []byte("ok"), 0644)
+	secret := filepath.Join(dir, "secret.pem")
+	os.WriteFile(secret, []byte("secret"), 0644)
+	os.Symlink(secret, filepath.Join(upload, "leak.txt"))
+
+	sandbox, err := NewSandbox(SandboxRoot{Path: upload})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := New("test")
+	f.SetSandbox(sandbox)
+	conn := newS3Server(t, "main")
+
+	if _, err := callFn(t, f, "s3.upload", merge(conn, map[string]any{"local_path": upload})); !errors.Is(err, ErrSandbox) {
+		t.Errorf("expected uploading a symlink leaving the sandbox to fail, got %v", err)
+	}
+	if n := len(s3Call(t, f, "s3.list", conn, nil).Get("objects").Array()); n != 0 {
+		t.Errorf("expected nothing to be uploaded, got %d objects", n)
+	}
+
+	for _, param := range []string{"ca_cert", "client_cert"} {
+		if _, err := callFn(t, f, "http.request", map[string]any{"url": "https://localhost", param: secret, "client_key": secret}); !errors.Is(err, ErrSandbox) {
+			t.Errorf("expected %s outside of the sandbox to fail, got %v", param, err)
+		}
+	}
+}
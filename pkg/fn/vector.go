@@ -0,0 +1,283 @@
+package fn
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/yosev/coda/internal/utils"
+)
+
+type fnVector struct {
+	*Fn
+	category FnCategory
+}
+
+// vectorIndexMutex serializes the updates of index files
+var vectorIndexMutex sync.Mutex
+
+// vectorIndexParameters select an index held in the store or in a JSON file
+var vectorIndexParameters = []FnParameter{
+	{Name: "index", Description: "The index as array of {id, vector, text, metadata}, e.g. from the store", Type: "array", Mandatory: false},
+	{Name: "file", Description: "The path of a JSON index file, used instead of index", Mandatory: false},
+}
+
+func (f *fnVector) init(fn *Fn) {
+	f.Fn = fn
+
+	fn.register("vector.similarity", &FnEntry{
+		Handler:     f.similarity,
+		Name:        "Vector similarity",
+		Description: "Returns the cosine similarity of two vectors",
+		Category:    f.category,
+		Parameters: []FnParameter{
+			{Name: "a", Description: "The first vector", Type: "array", Mandatory: true},
+			{Name: "b", Description: "The second vector", Type: "array", Mandatory: true},
+		},
+	})
+
+	fn.register("vector.search", &FnEntry{
+		Handler:     f.search,
+		Name:        "Vector search",
+		Description: "Returns the items of an index nearest to the vector as [{id, score, text, metadata}] ordered by cosine similarity",
+		Category:    f.category,
+		Parameters: append([]FnParameter{
+			{Name: "vector", Description: "The vector to search for", Type: "array", Mandatory: true},
+			{Name: "top_k", Description: "The number of items to return (default 5)", Type: "integer", Mandatory: false},
+			{Name: "min_score", Description: "The minimum similarity of returned items", Type: "number", Mandatory: false},
+		}, vectorIndexParameters...),
+	})
+
+	fn.register("vector.index.add", &FnEntry{
+		Handler:     f.indexAdd,
+		Name:        "Add to vector index",
+		Description: "Adds items to an index, replacing items with the same id. Returns the index, or {file, count} for index files",
+		Category:    f.category,
+		Parameters: append([]FnParameter{
+			{Name: "items", Description: "The items to add as array of {id, vector, text, metadata}", Type: "array", Mandatory: true},
+		}, vectorIndexParameters...),
+	})
+
+	fn.register("vector.index.remove", &FnEntry{
+		Handler:     f.indexRemove,
+		Name:        "Remove from vector index",
+		Description: "Removes items by id from an index. Returns the index, or {file, count} for index files",
+		Category:    f.category,
+		Parameters: append([]FnParameter{
+			{Name: "ids", Description: "The ids of the items to remove", Type: "array", Mandatory: true},
+		}, vectorIndexParameters...),
+	})
+}
+
+// vectorItem is a single entry of an index
+type vectorItem struct {
+	Id       string          `json:"id" yaml:"id"`
+	Vector   []float64       `json:"vector" yaml:"vector"`
+	Text     string          `json:"text,omitempty" yaml:"text,omitempty"`
+	Metadata json.RawMessage `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+}
+
+type vectorMatch struct {
+	Id       string          `json:"id"`
+	Score    float64         `json:"score"`
+	Text     string          `json:"text,omitempty"`
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+}
+
+type vectorIndexParams struct {
+	Index []vectorItem `json:"index,omitempty" yaml:"index,omitempty"`
+	File  string       `json:"file,omitempty" yaml:"file,omitempty"`
+}
+
+type similarityParams struct {
+	A []float64 `json:"a" yaml:"a"`
+	B []float64 `json:"b" yaml:"b"`
+}
+
+func (f *fnVector) similarity(j json.RawMessage) (json.RawMessage, error) {
+	return utils.HandleJSON(j, func(params *similarityParams) (json.RawMessage, error) {
+		score, err := cosineSimilarity(params.A, params.B)
+		if err != nil {
+			return nil, err
+		}
+		return utils.ReturnRaw(score), nil
+	})
+}
+
+type vectorSearchParams struct {
+	vectorIndexParams
+	Vector   []float64 `json:"vector" yaml:"vector"`
+	TopK     int       `json:"top_k,omitempty" yaml:"top_k,omitempty"`
+	MinScore *float64  `json:"min_score,omitempty" yaml:"min_score,omitempty"`
+}
+
+func (f *fnVector) search(j json.RawMessage) (json.RawMessage, error) {
+	return utils.HandleJSON(j, func(params *vectorSearchParams) (json.RawMessage, error) {
+		index := params.Index
+		if params.File != "" {
+			if err := f.checkRead(params.File); err != nil {
+				return nil, err
+			}
+			var err error
+			if index, err = readVectorIndex(params.File); err != nil {
+				return nil, err
+			}
+		}
+
+		matches := []vectorMatch{}
+		for _, item := range index {
+			score, err := cosineSimilarity(params.Vector, item.Vector)
+			if err != nil {
+				return nil, fmt.Errorf("item '%s': %w", item.Id, err)
+			}
+			if params.MinScore != nil && score < *params.MinScore {
+				continue
+			}
+			matches = append(matches, vectorMatch{Id: item.Id, Score: score, Text: item.Text, Metadata: item.Metadata})
+		}
+		sort.SliceStable(matches, func(a, b int) bool {
+			return matches[a].Score > matches[b].Score
+		})
+
+		topK := params.TopK
+		if topK <= 0 {
+			topK = 5
+		}
+		return utils.ReturnRaw(matches[:min(topK, len(matches))]), nil
+	})
+}
+
+type vectorAddParams struct {
+	vectorIndexParams
+	Items []vectorItem `json:"items" yaml:"items"`
+}
+
+func (f *fnVector) indexAdd(j json.RawMessage) (json.RawMessage, error) {
+	return utils.HandleJSON(j, func(params *vectorAddParams) (json.RawMessage, error) {
+		for _, item := range params.Items {
+			if item.Id == "" || len(item.Vector) == 0 {
+				return nil, fmt.Errorf("items require an id and a vector")
+			}
+		}
+		return f.updateIndex(&params.vectorIndexParams, func(index []vectorItem) []vectorItem {
+			for _, item := range params.Items {
+				i := slices.IndexFunc(index, func(existing vectorItem) bool { return existing.Id == item.Id })
+				if i >= 0 {
+					index[i] = item
+				} else {
+					index = append(index, item)
+				}
+			}
+			return index
+		})
+	})
+}
+
+type vectorRemoveParams struct {
+	vectorIndexParams
+	Ids []string `json:"ids" yaml:"ids"`
+}
+
+func (f *fnVector) indexRemove(j json.RawMessage) (json.RawMessage, error) {
+	return utils.HandleJSON(j, func(params *vectorRemoveParams) (json.RawMessage, error) {
+		return f.updateIndex(&params.vectorIndexParams, func(index []vectorItem) []vectorItem {
+			return slices.DeleteFunc(index, func(item vectorItem) bool {
+				return slices.Contains(params.Ids, item.Id)
+			})
+		})
+	})
+}
+
+// updateIndex applies the update to the index of the params. Index files
+// are rewritten, other indexes are returned to be stored by the operation.
+func (f *fnVector) updateIndex(params *vectorIndexParams, update func([]vectorItem) []vectorItem) (json.RawMessage, error) {
+	if params.File == "" {
+		index := update(slices.Clone(params.Index))
+		if index == nil {
+			index = []vectorItem{}
+		}
+		return utils.ReturnRaw(index), nil
+	}
+
+	if err := f.checkWrite(params.File); err != nil {
+		return nil, err
+	}
+	vectorIndexMutex.Lock()
+	defer vectorIndexMutex.Unlock()
+
+	index, err := readVectorIndex(params.File)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	index = update(index)
+	if index == nil {
+		index = []vectorItem{}
+	}
+	if err := writeVectorIndex(params.File, index); err != nil {
+		return nil, err
+	}
+	return utils.ReturnRaw(map[string]any{
+		"file":  params.File,
+		"count": len(index),
+	}), nil
+}
+
+func readVectorIndex(path string) ([]vectorItem, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+	var index []vectorItem
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("invalid index %s: %w", path, err)
+	}
+	return index, nil
+}
+
+// writeVectorIndex replaces the index file through a temporary file, so
+// readers never see a partially written index
+func writeVectorIndex(path string, index []vectorItem) error {
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func cosineSimilarity(a, b []float64) (float64, error) {
+	if len(a) != len(b) {
+		return 0, fmt.Errorf("vectors have different dimensions (%d and %d)", len(a), len(b))
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0, nil
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB)), nil
+}
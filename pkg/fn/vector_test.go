@@ -0,0 +1,60 @@
+package fn
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestVectorIndex(t *testing.T) {
+	f := New("test")
+	file := filepath.Join(t.TempDir(), "index.json")
+
+	out, err := callFn(t, f, "vector.similarity", map[string]any{"a": []float64{1, 0}, "b": []float64{1, 1}})
+	if err != nil || gjson.ParseBytes(out).Float() < 0.7071 || gjson.ParseBytes(out).Float() > 0.7072 {
+		t.Errorf("unexpected similarity %s: %v", out, err)
+	}
+	if _, err := callFn(t, f, "vector.similarity", map[string]any{"a": []float64{1}, "b": []float64{1, 1}}); err == nil {
+		t.Error("expected vectors of different dimensions to fail")
+	}
+
+	items := []map[string]any{
+		{"id": "cats", "vector": []float64{1, 0, 0}, "text": "about cats"},
+		{"id": "dogs", "vector": []float64{0, 1, 0}, "text": "about dogs"},
+		{"id": "pets", "vector": []float64{0.7, 0.7, 0}, "metadata": map[string]any{"source": "wiki"}},
+	}
+	if out, err := callFn(t, f, "vector.index.add", map[string]any{"file": file, "items": items}); err != nil || gjson.GetBytes(out, "count").Int() != 3 {
+		t.Fatalf("unexpected add result %s: %v", out, err)
+	}
+	// re-adding an id replaces the item
+	callFn(t, f, "vector.index.add", map[string]any{"file": file, "items": []map[string]any{{"id": "dogs", "vector": []float64{0, 0, 1}}}})
+
+	ids := func(out []byte) []string {
+		ids := []string{}
+		for _, id := range gjson.GetBytes(out, "#.id").Array() {
+			ids = append(ids, id.String())
+		}
+		return ids
+	}
+	out, err = callFn(t, f, "vector.search", map[string]any{"file": file, "vector": []float64{1, 0.1, 0}, "top_k": 2})
+	if err != nil || !reflect.DeepEqual(ids(out), []string{"cats", "pets"}) {
+		t.Errorf("unexpected search result %s: %v", out, err)
+	}
+	if gjson.GetBytes(out, "1.metadata.source").String() != "wiki" {
+		t.Errorf("expected the metadata to be returned: %s", out)
+	}
+
+	callFn(t, f, "vector.index.remove", map[string]any{"file": file, "ids": []string{"cats"}})
+	out, _ = callFn(t, f, "vector.search", map[string]any{"file": file, "vector": []float64{1, 0.1, 0}, "min_score": 0.5})
+	if !reflect.DeepEqual(ids(out), []string{"pets"}) {
+		t.Errorf("unexpected search result after remove: %s", out)
+	}
+
+	// indexes in the store are returned instead of written
+	out, err = callFn(t, f, "vector.index.add", map[string]any{"index": items[:1], "items": items[1:2]})
+	if err != nil || !reflect.DeepEqual(ids(out), []string{"cats", "dogs"}) {
+		t.Errorf("unexpected store index %s: %v", out, err)
+	}
+}
@@ -0,0 +1,254 @@
+package coda
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	PolicyAllow = "allow"
+	PolicyDeny  = "deny"
+)
+
+// Policy allows or denies operations by action name and parameters. Rules
+// are evaluated in order and the first rule matching the action decides,
+// actions matching no rule get the default effect.
+type Policy struct {
+	Default string       `json:"default,omitempty" yaml:"default,omitempty"` // allow (default) or deny
+	Rules   []PolicyRule `json:"rules" yaml:"rules"`
+}
+
+// PolicyRule matches actions by a glob pattern (e.g. file.*). Parameter
+// constraints of an allow rule must all be met, otherwise the operation is denied.
+type PolicyRule struct {
+	Action string                     `json:"action" yaml:"action"`
+	Effect string                     `json:"effect" yaml:"effect"`
+	Params map[string]PolicyParamRule `json:"params,omitempty" yaml:"params,omitempty"` // key is a gjson path into the params
+}
+
+// PolicyParamRule constrains a parameter value, arrays must satisfy the
+// constraints with every item
+type PolicyParamRule struct {
+	Allow []string `json:"allow,omitempty" yaml:"allow,omitempty"` // glob patterns the value must match
+	Deny  []string `json:"deny,omitempty" yaml:"deny,omitempty"`   // glob patterns the value must not match
+	// Hosts are glob patterns the host of a url value must match. Relative urls
+	// of requests using an HTTP session or connection are joined to its base
+	// url first. Only the first request is checked, redirects and the next
+	// pages followed by http.paginate are not.
+	Hosts []string `json:"hosts,omitempty" yaml:"hosts,omitempty"`
+}
+
+// PolicyDecision is the result of evaluating the policy for an operation
+type PolicyDecision struct {
+	Operation string `json:"operation,omitempty" yaml:"operation,omitempty"`
+	Action    string `json:"action" yaml:"action"`
+	Allowed   bool   `json:"allowed" yaml:"allowed"`
+	Rule      string `json:"rule,omitempty" yaml:"rule,omitempty"` // the action pattern of the deciding rule
+	Reason    string `json:"reason,omitempty" yaml:"reason,omitempty"`
+	Dynamic   bool   `json:"dynamic,omitempty" yaml:"dynamic,omitempty"` // params contain variables and are checked again at runtime
+}
+
+// LoadPolicy parses and validates a JSON or YAML policy document
+func LoadPolicy(data []byte) (*Policy, error) {
+	p := &Policy{}
+	if err := yaml.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("failed to parse policy: %w", err)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Validate checks the effects and glob patterns of the policy
+func (p *Policy) Validate() error {
+	if p.Default != "" && p.Default != PolicyAllow && p.Default != PolicyDeny {
+		return fmt.Errorf("invalid policy default: %s", p.Default)
+	}
+	for i, rule := range p.Rules {
+		if rule.Effect != PolicyAllow && rule.Effect != PolicyDeny {
+			return fmt.Errorf("rule %d: invalid effect: %s", i, rule.Effect)
+		}
+		patterns := []string{rule.Action}
+		for _, param := range rule.Params {
+			patterns = append(patterns, param.Allow...)
+			patterns = append(patterns, param.Deny...)
+			patterns = append(patterns, param.Hosts...)
+		}
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("rule %d: invalid pattern '%s': %w", i, pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Evaluate decides whether an action may be executed with the given params
+func (p *Policy) Evaluate(action string, params json.RawMessage) PolicyDecision {
+	decision := PolicyDecision{Action: action}
+	for _, rule := range p.Rules {
+		if ok, _ := path.Match(rule.Action, action); !ok {
+			continue
+		}
+		decision.Rule = rule.Action
+		if rule.Effect == PolicyDeny {
+			decision.Reason = fmt.Sprintf("action denied by rule '%s'", rule.Action)
+			return decision
+		}
+		if reason := rule.checkParams(params); reason != "" {
+			decision.Reason = reason
+			return decision
+		}
+		decision.Allowed = true
+		return decision
+	}
+
+	decision.Allowed = p.Default != PolicyDeny
+	if !decision.Allowed {
+		decision.Reason = "action denied by default"
+	}
+	return decision
+}
+
+// checkParams returns the reason the params violate the rule, if any
+func (r *PolicyRule) checkParams(params json.RawMessage) string {
+	names := make([]string, 0, len(r.Params))
+	for name := range r.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		constraint := r.Params[name]
+		value := gjson.GetBytes(params, name)
+		values := []string{value.String()}
+		if value.IsArray() {
+			values = values[:0]
+			for _, item := range value.Array() {
+				values = append(values, item.String())
+			}
+		}
+		for _, v := range values {
+			if reason := constraint.check(v); reason != "" {
+				return fmt.Sprintf("parameter '%s' %s", name, reason)
+			}
+		}
+	}
+	return ""
+}
+
+func (c PolicyParamRule) check(value string) string {
+	if len(c.Allow) > 0 && !matchAnyPattern(c.Allow, value) {
+		return fmt.Sprintf("value '%s' is not allowed", value)
+	}
+	if matchAnyPattern(c.Deny, value) {
+		return fmt.Sprintf("value '%s' is denied", value)
+	}
+	if len(c.Hosts) > 0 {
+		u, err := url.Parse(value)
+		if err != nil || u.Hostname() == "" {
+			return fmt.Sprintf("value '%s' is not an absolute url", value)
+		}
+		if !matchAnyPattern(c.Hosts, strings.ToLower(u.Hostname())) {
+			return fmt.Sprintf("host '%s' is not allowed", u.Hostname())
+		}
+	}
+	return ""
+}
+
+func matchAnyPattern(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy restricts the operations of this run by action name and parameters
+func (c *Coda) Policy(p *Policy) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.policy = p
+}
+
+// PolicyReport evaluates the policy for all operations before a run. Params
+// containing variables are evaluated unresolved and checked again at runtime.
+func (c *Coda) PolicyReport() []PolicyDecision {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	uids := make([]string, 0, len(c.Operations))
+	for uid := range c.Operations {
+		uids = append(uids, uid)
+	}
+	sort.Strings(uids)
+
+	report := []PolicyDecision{}
+	for _, uid := range uids {
+		op := c.Operations[uid]
+		decision := PolicyDecision{Action: op.Action, Allowed: true}
+		if c.policy != nil {
+			decision = c.policy.Evaluate(op.Action, op.Params)
+		}
+		decision.Operation = uid
+		decision.Dynamic = strings.Contains(string(op.Params), "${")
+		if _, ok := c.Fn.GetFns()[op.Action]; !ok {
+			decision.Allowed = false
+			decision.Reason = "unknown action"
+		} else if c.isBlacklisted(c.Fn.GetFns()[op.Action].Category) {
+			decision.Allowed = false
+			decision.Reason = "category is disabled"
+		}
+		report = append(report, decision)
+	}
+	return report
+}
+
+// checkPolicy fails if the policy denies the operation
+func (c *Coda) checkPolicy(action string, params json.RawMessage) error {
+	if c.policy == nil {
+		return nil
+	}
+	params, err := c.sessionUrls(params)
+	if err != nil {
+		return fmt.Errorf("operation '%s' denied by policy: %w", action, err)
+	}
+	if decision := c.policy.Evaluate(action, params); !decision.Allowed {
+		return fmt.Errorf("operation '%s' denied by policy: %s", action, decision.Reason)
+	}
+	return nil
+}
+
+// sessionUrls joins the relative request urls of params using an HTTP session
+// to the base url of the session, so host rules see the requested host
+func (c *Coda) sessionUrls(params json.RawMessage) (json.RawMessage, error) {
+	session := gjson.GetBytes(params, "session")
+	if session.Type != gjson.String {
+		return params, nil
+	}
+	var merged map[string]json.RawMessage
+	if err := json.Unmarshal(params, &merged); err != nil {
+		return params, nil
+	}
+	for _, key := range []string{"url", "endpoint"} {
+		value := gjson.GetBytes(params, key)
+		if value.Type != gjson.String {
+			continue
+		}
+		u, err := c.Fn.HttpSessionUrl(session.String(), value.String())
+		if err != nil {
+			return nil, err
+		}
+		merged[key], _ = json.Marshal(u)
+	}
+	return json.Marshal(merged)
+}
@@ -0,0 +1,140 @@
+package coda
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var testPolicy = []byte(`
+default: deny
+rules:
+  - action: file.delete
+    effect: deny
+  - action: file.*
+    effect: allow
+  - action: http.request
+    effect: allow
+    params:
+      url:
+        hosts: ["api.example.com", "*.internal"]
+  - action: os.exec
+    effect: allow
+    params:
+      command:
+        allow: ["git", "ls"]
+      arguments:
+        deny: ["--exec*"]
+  - action: string
+    effect: allow
+`)
+
+func TestPolicyEvaluate(t *testing.T) {
+	policy, err := LoadPolicy(testPolicy)
+	if err != nil {
+		t.Fatalf("failed to load policy: %v", err)
+	}
+
+	tests := []struct {
+		action string
+		params string
+		want   bool
+		reason string
+	}{
+		{"file.read", `{"source": "a"}`, true, ""},
+		{"file.delete", `{"source": "a"}`, false, "action denied by rule 'file.delete'"},
+		{"http.request", `{"url": "https://api.example.com/v1"}`, true, ""},
+		{"http.request", `{"url": "http://db.internal:8080"}`, true, ""},
+		{"http.request", `{"url": "https://evil.com"}`, false, "parameter 'url' host 'evil.com' is not allowed"},
+		{"http.request", `{"url": "/relative"}`, false, "is not an absolute url"},
+		{"os.exec", `{"command": "git", "arguments": ["status"]}`, true, ""},
+		{"os.exec", `{"command": "rm", "arguments": ["-rf"]}`, false, "parameter 'command' value 'rm' is not allowed"},
+		{"os.exec", `{"command": "git", "arguments": ["log", "--exec=sh"]}`, false, "parameter 'arguments' value '--exec=sh' is denied"},
+		{"time.sleep", `{}`, false, "action denied by default"},
+	}
+	for _, tt := range tests {
+		decision := policy.Evaluate(tt.action, []byte(tt.params))
+		if decision.Allowed != tt.want || !strings.Contains(decision.Reason, tt.reason) {
+			t.Errorf("Evaluate(%s, %s) = %+v, want allowed %v with reason %q", tt.action, tt.params, decision, tt.want, tt.reason)
+		}
+	}
+
+	for _, doc := range []string{`{"rules": [{"action": "file.*", "effect": "maybe"}]}`, `{"default": "never"}`, `{"rules": [{"action": "[", "effect": "deny"}]}`} {
+		if _, err := LoadPolicy([]byte(doc)); err == nil {
+			t.Errorf("expected an error loading policy %s", doc)
+		}
+	}
+}
+
+func TestPolicyRun(t *testing.T) {
+	policy, err := LoadPolicy(testPolicy)
+	if err != nil {
+		t.Fatalf("failed to load policy: %v", err)
+	}
+
+	c, err := New().FromJson(`{
+		"store": {"command": "cat"},
+		"operations": {
+			"greet": {"entrypoint": true, "action": "string", "params": {"value": "hello"}, "onSuccess": "exec"},
+			"exec": {"action": "os.exec", "params": {"command": "${store.command}", "arguments": ["secrets.txt"]}, "onSuccess": "sleep"},
+			"sleep": {"action": "time.sleep", "params": {"value": 1}}
+		}
+	}`)
+	if err != nil {
+		t.Fatalf("failed to load coda from JSON: %v", err)
+	}
+	c.Policy(policy)
+
+	report := c.PolicyReport()
+	want := map[string]bool{"exec": false, "greet": true, "sleep": false}
+	for _, decision := range report {
+		if decision.Allowed != want[decision.Operation] {
+			t.Errorf("report for %s = %+v, want allowed %v", decision.Operation, decision, want[decision.Operation])
+		}
+		if decision.Operation == "exec" && !decision.Dynamic {
+			t.Errorf("expected the exec operation to be reported as dynamic")
+		}
+	}
+
+	if err := c.Run(); err == nil || !strings.Contains(err.Error(), "denied by policy: parameter 'command' value 'cat' is not allowed") {
+		t.Fatalf("expected the policy to deny the resolved command, got %v", err)
+	}
+	if c.Stats.OperationsDeniedTotal != 1 {
+		t.Errorf("expected one denied operation, got %v", c.Stats.OperationsDeniedTotal)
+	}
+}
+
+func TestPolicySessionUrls(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	for _, tt := range []struct {
+		hosts   string
+		allowed bool
+	}{
+		{`["127.0.0.1"]`, true},
+		{`["api.example.com"]`, false},
+	} {
+		policy, err := LoadPolicy([]byte(`{"rules": [{"action": "http.*", "effect": "allow", "params": {"url": {"hosts": ` + tt.hosts + `}}}]}`))
+		if err != nil {
+			t.Fatalf("failed to load policy: %v", err)
+		}
+		c, err := New().FromJson(fmt.Sprintf(`{
+			"coda": {"connections": {"api": {"type": "http", "params": {"base_url": %q}}}},
+			"operations": {"get": {"entrypoint": true, "action": "http.request", "params": {"connection": "api", "url": "/items", "method": "GET"}}}
+		}`, server.URL))
+		if err != nil {
+			t.Fatalf("failed to load coda from JSON: %v", err)
+		}
+		c.Policy(policy)
+		err = c.Run()
+		if tt.allowed && err != nil {
+			t.Errorf("hosts %s: expected the relative url to be allowed, got %v", tt.hosts, err)
+		}
+		if !tt.allowed && (err == nil || !strings.Contains(err.Error(), "host '127.0.0.1' is not allowed")) {
+			t.Errorf("hosts %s: expected the host of the base url to be denied, got %v", tt.hosts, err)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package coda
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -16,6 +17,33 @@ func (c *Coda) run() error {
 		c.Stats.CodaRuntimeTotalMs += float64(since.Milliseconds())
 	}()
 
+	if c.Coda != nil && c.Coda.Blobs != nil {
+		blobs, err := fn.NewBlobStore(*c.Coda.Blobs)
+		if err != nil {
+			return err
+		}
+		c.Fn.SetBlobStore(blobs)
+	}
+	if c.sandboxed {
+		sandbox, err := fn.NewSandbox(c.sandbox...)
+		if err != nil {
+			return err
+		}
+		c.Fn.SetSandbox(sandbox)
+	}
+	defer func() {
+		if err := c.Fn.Close(); err != nil {
+			c.debug(fmt.Sprintf("failed to release run resources: %s", err))
+		}
+	}()
+
+	if err := c.setupHttpSessions(); err != nil {
+		return err
+	}
+	if err := c.setupConnections(); err != nil {
+		return err
+	}
+
 	if startUid, err := c.findEntrypoint(); err != nil {
 		return err
 	} else {
@@ -34,6 +62,53 @@ func (c *Coda) run() error {
 	return nil
 }
 
+// setupHttpSessions registers the HTTP sessions of the coda settings,
+// resolving variables so credentials can reference secrets
+func (c *Coda) setupHttpSessions() error {
+	if c.Coda == nil {
+		return nil
+	}
+	for name, session := range c.Coda.HttpSessions {
+		raw, err := json.Marshal(session)
+		if err != nil {
+			return fmt.Errorf("failed to marshal http session '%s': %w", name, err)
+		}
+		resolved, err := c.resolveVariables(raw)
+		if err != nil {
+			return fmt.Errorf("failed to resolve http session '%s': %w", name, err)
+		}
+		var params fn.HttpSessionParams
+		if err := json.Unmarshal(resolved, &params); err != nil {
+			return fmt.Errorf("failed to unmarshal http session '%s': %w", name, err)
+		}
+		if err := c.Fn.SetHttpSession(name, params); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setupConnections registers the named connections of the coda settings,
+// resolving variables so credentials can reference secrets
+func (c *Coda) setupConnections() error {
+	if c.Coda == nil {
+		return nil
+	}
+	for name, conn := range c.Coda.Connections {
+		if len(conn.Params) > 0 {
+			resolved, err := c.resolveVariables(conn.Params)
+			if err != nil {
+				return fmt.Errorf("failed to resolve connection '%s': %w", name, err)
+			}
+			conn.Params = resolved
+		}
+		if err := c.Fn.SetConnection(name, conn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (c *Coda) runOperations(uid string) (string, error) {
 	for uid != "" {
 		op, ok := c.Operations[uid]
@@ -61,7 +136,7 @@ func (c *Coda) runOperations(uid string) (string, error) {
 }
 
 func (c *Coda) executeOperation(op Operation) error {
-	if action, ok := fns.GetFns()[op.Action]; !ok {
+	if action, ok := c.Fn.GetFns()[op.Action]; !ok {
 		return fmt.Errorf("unknown action: %s", op.Action)
 	} else {
 		if c.isBlacklisted(action.Category) {
@@ -82,10 +157,29 @@ func (c *Coda) executeOperation(op Operation) error {
 		}
 		op.Params = p
 
+		if op.Params, err = c.Fn.ApplyConnection(op.Action, op.Params); err != nil {
+			return err
+		}
+
+		if err := c.checkPolicy(op.Action, op.Params); err != nil {
+			c.Stats.OperationsDeniedTotal++
+			return err
+		}
+
 		execWithLock := func() error {
 			result, err := action.Handler(op.Params)
 			if err != nil {
-				return err
+				if errors.Is(err, fn.ErrSandbox) {
+					c.mutex.Lock()
+					c.Stats.OperationsSandboxedTotal++
+					c.mutex.Unlock()
+				}
+				// failures carrying a result are stored for the onFail branch
+				var resultErr *fn.ResultError
+				if !errors.As(err, &resultErr) {
+					return err
+				}
+				result = resultErr.Result
 			}
 
 			// delay locking to make this routine non-blocking during the actual execution
@@ -95,15 +189,15 @@ func (c *Coda) executeOperation(op Operation) error {
 			if op.Store != "" && len(result) != 0 {
 				// check if the result should be stored in a JSON path
 				if strings.Contains(op.Store, ".") {
-					err := c.storeNestedJSONValue(op.Store, result)
-					if err != nil {
+					if err := c.storeNestedJSONValue(op.Store, result); err != nil {
 						return fmt.Errorf("failed to store nested value: %v", err)
 					}
 				} else {
 					c.Store[op.Store] = result
 				}
+				c.invalidateSnapshots()
 			}
-			return nil
+			return err
 		}
 
 		if op.Async {
@@ -114,6 +208,50 @@ func (c *Coda) executeOperation(op Operation) error {
 	}
 }
 
+// executeAction runs an action on behalf of another action, e.g. a tool call
+// of an ai agent. It applies the blacklist, connections and policy like
+// operations do, but variables are not resolved since the params may come
+// from a model and must not reach the secrets or store.
+func (c *Coda) executeAction(name string, params json.RawMessage) (json.RawMessage, error) {
+	action, ok := c.Fn.GetFns()[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown action: %s", name)
+	}
+	c.mutex.Lock()
+	blacklisted := c.isBlacklisted(action.Category)
+	if blacklisted {
+		c.Stats.OperationsBlacklistedTotal++
+	}
+	c.mutex.Unlock()
+	if blacklisted {
+		return nil, fmt.Errorf("category of action '%s' is disabled (%s)", name, action.Category)
+	}
+
+	params, err := c.Fn.ApplyConnection(name, params)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.checkPolicy(name, params); err != nil {
+		c.mutex.Lock()
+		c.Stats.OperationsDeniedTotal++
+		c.mutex.Unlock()
+		return nil, err
+	}
+
+	start := time.Now()
+	result, err := action.Handler(params)
+	since := time.Since(start)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.Stats.OperationsTotal++
+	c.Stats.OperationsRuntimeTotalMs += float64(since.Milliseconds())
+	if errors.Is(err, fn.ErrSandbox) {
+		c.Stats.OperationsSandboxedTotal++
+	}
+	return result, err
+}
+
 func (c *Coda) storeNestedJSONValue(path string, value json.RawMessage) error {
 	parts := strings.Split(path, ".")
 	rootKey := parts[0]
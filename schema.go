@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"slices"
 	"strings"
+	"sync"
 
 	"github.com/xeipuuv/gojsonschema"
 )
@@ -23,6 +24,7 @@ type Schema struct {
 	Required             []string                          `json:"required,omitempty"`
 	AdditionalProperties bool                              `json:"additionalProperties"`
 	Defs                 map[string]map[string]interface{} `json:"$defs,omitempty"`
+	Filters              []FilterDefinition                `json:"filters,omitempty"` // informational, lists the available ${...} filters
 }
 
 type SchemaProperties struct {
@@ -34,6 +36,7 @@ type SchemaProperties struct {
 
 type SchemaCodaProperty struct {
 	Type                 string                        `json:"type"`
+	Enum                 []string                      `json:"enum,omitempty"`
 	Properties           map[string]SchemaCodaProperty `json:"properties,omitempty"`
 	Required             []string                      `json:"required,omitempty"`
 	AdditionalProperties bool                          `json:"additionalProperties"`
@@ -85,10 +88,22 @@ func init() {
 	new().Schema()
 }
 
-var schema = ""
+var (
+	schemaMutex sync.Mutex
+	schema      = ""
+)
+
+// resetSchema makes the next call of Schema generate the schema again
+func resetSchema() {
+	schemaMutex.Lock()
+	defer schemaMutex.Unlock()
+	schema = ""
+}
 
 // Get the JSON schema for the Coda engine.
 func (c *Coda) Schema() string {
+	schemaMutex.Lock()
+	defer schemaMutex.Unlock()
 	if schema == "" {
 		err := json.Unmarshal([]byte(jsonSchemaRaw), jsonSchema)
 		if err != nil {
@@ -121,6 +136,7 @@ func (c *Coda) validateSchema(input string) error {
 // populateSchema fills the Schema struct with operation definitions and properties.
 func (s *Schema) populateSchema(version string) {
 	s.Version = version
+	s.Filters = Filters()
 	s.Defs = map[string]map[string]interface{}{}
 	s.Properties.Operations = &SchemaOperationsProperty{
 		Type: "object",
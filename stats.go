@@ -8,6 +8,8 @@ type CodaStats struct {
 	OperationsSuccessfulTotal  float64 `json:"operations_successful_total" yaml:"operations_successful_total"`
 	OperationsFailedTotal      float64 `json:"operations_failed_total" yaml:"operations_failed_total"`
 	OperationsBlacklistedTotal float64 `json:"operations_blacklisted_total" yaml:"operations_blacklisted_total"`
+	OperationsSandboxedTotal   float64 `json:"operations_sandboxed_total" yaml:"operations_sandboxed_total"`
+	OperationsDeniedTotal      float64 `json:"operations_denied_total" yaml:"operations_denied_total"`
 
 	VariablesTotal           float64 `json:"variables_total" yaml:"variables_total"`
 	VariablesFailedTotal     float64 `json:"variables_failed_total" yaml:"variables_failed_total"`
@@ -23,6 +25,8 @@ func (c *Coda) newStats() *CodaStats {
 		OperationsSuccessfulTotal:  0,
 		OperationsFailedTotal:      0,
 		OperationsBlacklistedTotal: 0,
+		OperationsSandboxedTotal:   0,
+		OperationsDeniedTotal:      0,
 
 		VariablesTotal:           0,
 		VariablesFailedTotal:     0,
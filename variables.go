@@ -1,35 +1,38 @@
 package coda
 
 import (
-	"crypto/md5"
-	"crypto/sha1"
-	"crypto/sha256"
-	"crypto/sha512"
-	"encoding/base64"
+	"bytes"
 	"encoding/json"
 	"fmt"
-	"reflect"
-	"regexp"
-	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/tidwall/gjson"
 )
 
 func (c *Coda) resolveVariables(in json.RawMessage) (json.RawMessage, error) {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
 	if len(in) == 0 {
 		return in, nil // No input to resolve
 	}
-	defer func() {
-		c.Stats.VariablesTotal++
-	}()
+	out, err := c.resolveInput(in)
 
-	// Marshal `c` so we can use gjson to query it
-	codaJSON, err := json.Marshal(c)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal Coda struct: %w", err)
+	// the stats are written, which the read lock of the resolution does not allow
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.Stats.VariablesTotal++
+	if err == nil {
+		c.Stats.VariablesSuccessfulTotal++
+	}
+	return out, err
+}
+
+// resolveInput resolves the variables of the input against the store
+func (c *Coda) resolveInput(in json.RawMessage) (json.RawMessage, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if !bytes.Contains(in, []byte("${")) {
+		return in, nil // Nothing to resolve
 	}
 
 	// Unmarshal the input into an interface{}
@@ -39,296 +42,317 @@ func (c *Coda) resolveVariables(in json.RawMessage) (json.RawMessage, error) {
 	}
 
 	// Recursively resolve variables in the data
-	resolved := resolveValue(input, codaJSON)
+	r := &resolver{query: c.queryPath, templates: c.templates, strict: c.isStrict()}
+	resolved, err := r.resolveValue(input)
+	if err != nil {
+		return nil, err
+	}
 
 	// Re-marshal to JSON
 	out, err := json.Marshal(resolved)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal resolved result: %w", err)
 	}
-
-	c.Stats.VariablesSuccessfulTotal++
 	return json.RawMessage(out), nil
 }
 
-func resolveValue(val any, codaJSON []byte) any {
+// resolver resolves variables using a gjson path query function
+type resolver struct {
+	query     func(path string) gjson.Result
+	templates *templateCache // optional, templates are compiled on demand without it
+	strict    bool           // fail on missing paths, unknown filters and filter errors
+}
+
+// jsonResolver resolves variables against a marshalled document
+func jsonResolver(doc []byte, strict bool) *resolver {
+	return &resolver{query: func(path string) gjson.Result {
+		return gjson.GetBytes(doc, path)
+	}, strict: strict}
+}
+
+// lookup queries a gjson path, reporting whether it exists
+func (r *resolver) lookup(path string) (any, bool) {
+	val := r.query(path)
+	if !val.Exists() {
+		return nil, false
+	}
+	return parseRaw(val), true
+}
+
+func (r *resolver) resolveValue(val any) (any, error) {
 	switch v := val.(type) {
 	case map[string]any:
 		for key, value := range v {
-			v[key] = resolveValue(value, codaJSON)
+			resolved, err := r.resolveValue(value)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = resolved
 		}
-		return v
+		return v, nil
 	case []any:
 		for i, item := range v {
-			v[i] = resolveValue(item, codaJSON)
+			resolved, err := r.resolveValue(item)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = resolved
 		}
-		return v
+		return v, nil
 	case string:
-		return resolveString(v, codaJSON)
+		return r.resolveString(v)
 	default:
-		return val
+		return val, nil
 	}
 }
 
-func resolveString(input string, codaJSON []byte) any {
-	// Updated regex: capture variable path and any filter string (starting with a pipe) until "}"
-	re := regexp.MustCompile(`\${\s*([^}\|]+?)\s*((?:\|[^}]+)+)?\s*}`)
-
-	matches := re.FindAllStringSubmatch(input, -1)
+func (r *resolver) resolveString(input string) (any, error) {
+	if !strings.Contains(input, "${") {
+		return input, nil
+	}
+	var t *template
+	if r.templates != nil {
+		t = r.templates.get(input)
+	} else {
+		t = compileTemplate(input)
+	}
+	return r.render(t)
+}
 
-	if len(matches) == 1 && strings.TrimSpace(input) == matches[0][0] {
-		path := matches[0][1] // variable path trimmed
-		filters := parseFilters(matches[0][2])
-		val := gjson.GetBytes(codaJSON, path)
-		return applyFilterChain(val, filters)
+func (r *resolver) render(t *template) (any, error) {
+	if t.err != nil {
+		return nil, t.err
 	}
 
-	result := input
-	for _, match := range matches {
-		full := match[0]
-		path := match[1]
-		filters := parseFilters(match[2])
+	// a string consisting of a single placeholder keeps the type of its value
+	if t.single {
+		return r.evaluate(t.exprs[0])
+	}
 
-		val := gjson.GetBytes(codaJSON, path)
-		replacement := fmt.Sprintf("%v", applyFilterChain(val, filters))
-		result = strings.ReplaceAll(result, full, replacement)
+	var sb strings.Builder
+	for i, expr := range t.exprs {
+		val, err := r.evaluate(expr)
+		if err != nil {
+			return nil, err
+		}
+		sb.WriteString(t.literals[i])
+		sb.WriteString(stringify(val))
 	}
+	sb.WriteString(t.literals[len(t.exprs)])
 
-	return result
+	return sb.String(), nil
 }
 
-func parseFilters(raw string) []Filter {
-	var filters []Filter
-	if raw == "" {
-		return filters
+func (r *resolver) evaluate(expr *expression) (any, error) {
+	val, err := expr.evaluate(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate '${%s}': %w", expr.src, err)
 	}
-	// Remove the leading pipe if present.
-	raw = strings.TrimSpace(raw)
-	if strings.HasPrefix(raw, "|") {
-		raw = raw[1:]
+	return val, nil
+}
+
+// template is a string compiled into literal text and `${...}` expressions
+type template struct {
+	literals []string // text around the expressions, one more than exprs
+	exprs    []*expression
+	single   bool  // the string consists of a single expression
+	err      error // compile error, reported when the template is resolved
+}
+
+// compileTemplate splits the input into literals and expressions, `$${`
+// is an escaped literal `${`
+func compileTemplate(input string) *template {
+	t := &template{}
+	placeholders, err := findPlaceholders(input)
+	if err != nil {
+		t.err = err
+		return t
 	}
-	// Split on pipes and trim each part.
-	parts := strings.Split(raw, "|")
-	for _, part := range parts {
-		part = strings.TrimSpace(part)
-		if part == "" {
+
+	var literal strings.Builder
+	last := 0
+	for _, ph := range placeholders {
+		literal.WriteString(input[last:ph.start])
+		last = ph.end
+		if ph.escaped {
+			literal.WriteString("${")
 			continue
 		}
-		// Split filter name and optional argument (e.g., "join" or "join:/")
-		sub := strings.SplitN(part, ":", 2)
-		f := Filter{Name: strings.TrimSpace(sub[0])}
-		if len(sub) == 2 {
-			f.Arg = strings.TrimSpace(sub[1])
+		expr, err := parseExpression(ph.body)
+		if err != nil {
+			t.err = fmt.Errorf("invalid expression '${%s}': %w", ph.body, err)
+			return t
 		}
-		filters = append(filters, f)
+		t.literals = append(t.literals, literal.String())
+		t.exprs = append(t.exprs, expr)
+		literal.Reset()
 	}
-	return filters
+	literal.WriteString(input[last:])
+	t.literals = append(t.literals, literal.String())
+	t.single = len(t.exprs) == 1 && strings.TrimSpace(t.literals[0]+t.literals[1]) == ""
+	return t
 }
 
-type Filter struct {
-	Name string
-	Arg  string
+// templateCache holds compiled templates keyed by their source string
+type templateCache struct {
+	mutex     sync.RWMutex
+	templates map[string]*template
 }
 
-func applyFilterChain(val gjson.Result, filters []Filter) any {
-	current := parseRaw(val)
-
-	for _, filter := range filters {
-		current = applySingleFilter(current, filter)
-	}
-	return current
+func newTemplateCache() *templateCache {
+	return &templateCache{templates: make(map[string]*template)}
 }
 
-func applySingleFilter(val any, filter Filter) any {
-	switch filter.Name {
-	case "string":
-		return fmt.Sprintf("%v", val)
-	case "substring":
-		if filter.Arg == "" {
-			return val
-		}
-
-		parts := strings.Split(filter.Arg, ":")
-		// Expect 1 or 2 numeric args
-		if len(parts) == 0 || len(parts) > 2 {
-			return val
-		}
-
-		// Parse start index
-		start, err := strconv.Atoi(parts[0])
-		if err != nil {
-			return val
-		}
+func (tc *templateCache) get(input string) *template {
+	tc.mutex.RLock()
+	t, ok := tc.templates[input]
+	tc.mutex.RUnlock()
+	if ok {
+		return t
+	}
 
-		// Parse end index if provided
-		end := -1
-		if len(parts) == 2 && parts[1] != "" {
-			if e, err := strconv.Atoi(parts[1]); err == nil {
-				end = e
-			}
-		}
+	t = compileTemplate(input)
+	tc.mutex.Lock()
+	tc.templates[input] = t
+	tc.mutex.Unlock()
+	return t
+}
 
-		// Handle string input
-		if s, ok := val.(string); ok {
-			// fix out-of-range
-			if start < 0 {
-				start = 0
-			}
-			if start > len(s) {
-				return ""
+// compile walks a JSON value and compiles all strings containing placeholders
+func (tc *templateCache) compile(raw json.RawMessage) {
+	if !bytes.Contains(raw, []byte("${")) {
+		return
+	}
+	var val any
+	if err := json.Unmarshal(raw, &val); err != nil {
+		return
+	}
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			for _, item := range v {
+				walk(item)
 			}
-			if end == -1 || end > len(s) {
-				end = len(s)
+		case []any:
+			for _, item := range v {
+				walk(item)
 			}
-			if end < start {
-				end = start
+		case string:
+			if strings.Contains(v, "${") {
+				tc.get(v)
 			}
-
-			return s[start:end]
 		}
+	}
+	walk(val)
+}
 
-		// Handle []any input
-		if arr, ok := val.([]any); ok {
-			if start < 0 {
-				start = 0
-			}
-			if start > len(arr) {
-				return []any{}
-			}
-			if end == -1 || end > len(arr) {
-				end = len(arr)
-			}
-			if end < start {
-				end = start
-			}
-
-			return arr[start:end]
-		}
+// placeholder is a `${...}` occurrence or an escaped `$${` within a string
+type placeholder struct {
+	start, end int
+	body       string
+	escaped    bool
+}
 
-		return val
-	case "join":
-		del := ","
-		if filter.Arg != "" {
-			del = filter.Arg
-		}
-		// Use reflection to support slices of any type
-		rVal := reflect.ValueOf(val)
-		if rVal.Kind() != reflect.Slice {
-			fmt.Println("join: value is not a slice:", val)
-			return val
-		}
-		parts := make([]string, rVal.Len())
-		for i := 0; i < rVal.Len(); i++ {
-			parts[i] = fmt.Sprintf("%v", rVal.Index(i).Interface())
-		}
-		return strings.Join(parts, del)
-	case "replace":
-		if filter.Arg == "" {
-			return val
-		}
-		parts := strings.SplitN(filter.Arg, ":", 2)
-		if len(parts) != 2 {
-			return val
+// findPlaceholders locates all `${...}` occurrences, honouring quoted string
+// literals and nested braces inside the expression.
+func findPlaceholders(input string) ([]placeholder, error) {
+	var placeholders []placeholder
+	for i := 0; i < len(input)-1; i++ {
+		if input[i] == '$' && strings.HasPrefix(input[i+1:], "${") {
+			placeholders = append(placeholders, placeholder{start: i, end: i + 3, escaped: true})
+			i += 2
+			continue
 		}
-		old := parts[0]
-		new := parts[1]
-		if s, ok := val.(string); ok {
-			return strings.ReplaceAll(s, old, new)
+		if input[i] != '$' || input[i+1] != '{' {
+			continue
 		}
-		if arr, ok := val.([]any); ok {
-			for i, item := range arr {
-				if strItem, ok := item.(string); ok {
-					arr[i] = strings.ReplaceAll(strItem, old, new)
+		start := i
+		depth := 0
+		var quote byte
+		closed := false
+		for i += 2; i < len(input); i++ {
+			ch := input[i]
+			if quote != 0 {
+				if ch == '\\' {
+					i++
+				} else if ch == quote {
+					quote = 0
 				}
+				continue
 			}
-			return arr
-		}
-		return val
-	case "upper":
-		if s, ok := val.(string); ok {
-			return strings.ToUpper(s)
-		}
-	case "lower":
-		if s, ok := val.(string); ok {
-			return strings.ToLower(s)
-		}
-	case "trim":
-		if s, ok := val.(string); ok {
-			return strings.TrimSpace(s)
-		}
-	case "split":
-		if s, ok := val.(string); ok {
-			del := "."
-			if filter.Arg != "" {
-				del = filter.Arg
-			}
-			return strings.Split(s, del)
-		}
-	case "md5":
-		if s, ok := val.(string); ok {
-			hash := md5.Sum([]byte(s))
-			return fmt.Sprintf("%x", hash)
-		}
-	case "sha1":
-		if s, ok := val.(string); ok {
-			hash := sha1.New()
-			hash.Write([]byte(s))
-			hashBytes := hash.Sum(nil)
-			return fmt.Sprintf("%x", hashBytes)
-		}
-	case "sha256":
-		if s, ok := val.(string); ok {
-			hash := sha256.New()
-			hash.Write([]byte(s))
-			hashBytes := hash.Sum(nil)
-			return fmt.Sprintf("%x", hashBytes)
-		}
-	case "sha512":
-		if s, ok := val.(string); ok {
-			hash := sha512.New()
-			hash.Write([]byte(s))
-			hashBytes := hash.Sum(nil)
-			return fmt.Sprintf("%x", hashBytes)
-		}
-	case "jsonDecode":
-		if s, ok := val.(string); ok {
-			var b = make(map[string]interface{})
-			err := json.Unmarshal([]byte(s), &b)
-			if err != nil {
-				return s
-			}
-			return b
-		}
-	case "jsonEncode":
-		if s, ok := val.(any); ok {
-			b, err := json.Marshal(s)
-			if err != nil {
-				return s
+			if ch == '"' || ch == '\'' || ch == '`' {
+				quote = ch
+			} else if ch == '{' {
+				depth++
+			} else if ch == '}' {
+				if depth == 0 {
+					closed = true
+					break
+				}
+				depth--
 			}
-			return string(b)
 		}
-	case "base64Decode":
-		if s, ok := val.(string); ok {
-			b, err := base64.StdEncoding.DecodeString(s)
-			if err != nil {
-				return s
-			}
-			return string(b)
+		if !closed {
+			return nil, fmt.Errorf("unterminated expression starting at position %d: %s", start, input[start:])
 		}
-	case "base64DecodeAsByteArray":
-		if s, ok := val.(string); ok {
-			b, err := base64.StdEncoding.DecodeString(s)
-			if err != nil {
-				return s
-			}
-			return b
+		placeholders = append(placeholders, placeholder{start: start, end: i + 1, body: input[start+2 : i]})
+	}
+	return placeholders, nil
+}
+
+// parseFilters parses the pipe segments following the expression (e.g., "join:/")
+func parseFilters(segments []string) []Filter {
+	var filters []Filter
+	for _, part := range segments {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
 		}
-	case "base64Encode":
-		if s, ok := val.(string); ok {
-			return base64.StdEncoding.EncodeToString([]byte(s))
+		// Split filter name and optional argument (e.g., "join" or "join:/")
+		sub := strings.SplitN(part, ":", 2)
+		f := Filter{Name: strings.TrimSpace(sub[0])}
+		if len(sub) == 2 {
+			f.Arg = strings.TrimSpace(sub[1])
 		}
+		filters = append(filters, f)
 	}
-	return val
+	return filters
+}
+
+type Filter struct {
+	Name string
+	Arg  string
+}
+
+// applyFilter applies a pipe filter, parsing its raw argument string
+func (r *resolver) applyFilter(val any, filter Filter) (any, error) {
+	def, ok := lookupFilter(filter.Name)
+	if !ok {
+		return r.filterFailed(val, filter.Name, fmt.Errorf("unknown filter"))
+	}
+	args, err := splitFilterArgs(filter.Arg, len(def.Arguments))
+	if err != nil {
+		return r.filterFailed(val, filter.Name, err)
+	}
+	return r.callFilter(def, val, args)
+}
+
+// callFilter runs a filter, falling back to the unfiltered value on errors
+// unless strict mode is enabled
+func (r *resolver) callFilter(def *FilterDefinition, val any, args []any) (any, error) {
+	out, err := def.call(val, args)
+	if err != nil {
+		return r.filterFailed(val, def.Name, err)
+	}
+	return out, nil
+}
+
+func (r *resolver) filterFailed(val any, name string, err error) (any, error) {
+	if r.strict {
+		return nil, fmt.Errorf("filter '%s': %w", name, err)
+	}
+	return val, nil
 }
 
 func parseRaw(v gjson.Result) any {
@@ -342,3 +366,110 @@ func parseRaw(v gjson.Result) any {
 	}
 	return out
 }
+
+// queryPath resolves a gjson path against the coda instance. Store and
+// secrets are queried key by key so only the referenced value is parsed,
+// other sections are marshalled on demand.
+func (c *Coda) queryPath(path string) gjson.Result {
+	root, rest, plain := splitPath(path)
+	if plain {
+		switch root {
+		case "store":
+			return c.queryRawMap(root, c.Store, rest)
+		case "secrets":
+			return c.queryRawMap(root, c.Secrets, rest)
+		case "coda":
+			return querySection(c.Coda, rest)
+		case "logs":
+			return querySection(c.Logs, rest)
+		case "stats":
+			return querySection(c.Stats, rest)
+		case "operations":
+			return querySection(c.Operations, rest)
+		}
+	}
+
+	// fall back to querying the complete instance
+	b, err := json.Marshal(c)
+	if err != nil {
+		return gjson.Result{}
+	}
+	return gjson.GetBytes(b, path)
+}
+
+func (c *Coda) queryRawMap(name string, m map[string]json.RawMessage, rest string) gjson.Result {
+	if rest == "" {
+		return gjson.ParseBytes(c.snapshot(name, m))
+	}
+	key, sub, plain := splitPath(rest)
+	if !plain {
+		return gjson.GetBytes(c.snapshot(name, m), rest)
+	}
+	raw, ok := m[key]
+	if !ok {
+		return gjson.Result{}
+	}
+	if sub == "" {
+		return gjson.ParseBytes(raw)
+	}
+	return gjson.GetBytes(raw, sub)
+}
+
+func querySection(section any, rest string) gjson.Result {
+	b, err := json.Marshal(section)
+	if err != nil {
+		return gjson.Result{}
+	}
+	if rest == "" {
+		return gjson.ParseBytes(b)
+	}
+	return gjson.GetBytes(b, rest)
+}
+
+// snapshot returns the marshalled map, cached until the store changes
+func (c *Coda) snapshot(name string, m map[string]json.RawMessage) []byte {
+	c.snapshotMutex.Lock()
+	defer c.snapshotMutex.Unlock()
+	if b, ok := c.snapshots[name]; ok {
+		return b
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil
+	}
+	if c.snapshots == nil {
+		c.snapshots = make(map[string][]byte)
+	}
+	c.snapshots[name] = b
+	return b
+}
+
+func (c *Coda) invalidateSnapshots() {
+	c.snapshotMutex.Lock()
+	defer c.snapshotMutex.Unlock()
+	c.snapshots = nil
+}
+
+// splitPath splits the first key off a gjson path. The key is unescaped and
+// reported as plain if it contains no wildcards, queries or modifiers.
+func splitPath(path string) (string, string, bool) {
+	var sb strings.Builder
+	plain := true
+	for i := 0; i < len(path); i++ {
+		switch ch := path[i]; ch {
+		case '\\':
+			if i+1 < len(path) {
+				i++
+				sb.WriteByte(path[i])
+			}
+		case '.':
+			return sb.String(), path[i+1:], plain && sb.Len() > 0
+		case '*', '?', '#', '@', '|', '(', ')', '=', '!', '<', '>', '%':
+			plain = false
+			sb.WriteByte(ch)
+		default:
+			sb.WriteByte(ch)
+		}
+	}
+	return sb.String(), "", plain && sb.Len() > 0
+}
@@ -0,0 +1,321 @@
+package coda
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tidwall/gjson"
+	"github.com/yosev/coda/internal/utils"
+)
+
+var testVariablesJSON = []byte(`{
+	"store": {
+		"name": "coda",
+		"count": 3,
+		"price": 2.5,
+		"enabled": true,
+		"empty": "",
+		"tags": ["a", "b", "c"],
+		"my-key": "dashed",
+		"a-1": 4,
+		"item-1": "first",
+		"build-2024": 7,
+		"nothing": null,
+		"two words": "spaced",
+		"user": {"first": "John", "last": "Doe"}
+	}
+}`)
+
+func TestResolveString(t *testing.T) {
+	tests := []struct {
+		input string
+		want  any
+	}{
+		{"${store.name}", "coda"},
+		{"${store.count}", float64(3)},
+		{"${store.missing}", ""},
+		{"${store.tags | join:/}", "a/b/c"},
+		{"${store.name | upper}", "CODA"},
+		{"${store.my-key}", "dashed"},
+		{"${store.count * store.price}", 7.5},
+		{"${store.count - 1}", float64(2)},
+		{"${(store.count + 1) % 3}", float64(1)},
+		{"${-store.count}", float64(-3)},
+		{"${store.count > 2 && store.enabled}", true},
+		{"${!store.enabled || store.count == 3}", true},
+		{"${store.count >= 3 ? 'many' : 'few'}", "many"},
+		{"${store.missing ?? 'fallback'}", "fallback"},
+		{"${store.empty ?? 'fallback'}", ""},
+		{"${store.user.first + ' ' + store.user.last}", "John Doe"},
+		{"${upper(store.user.first + store.user.last)}", "JOHNDOE"},
+		{"${join(store.tags, '-')}", "a-b-c"},
+		{"${substring(store.name, 1, 3)}", "od"},
+		{"${store.name == \"coda\"}", true},
+		{"${store.tags.#}", float64(3)},
+		{"${store.count | string}", "3"},
+		{"${store.tags | join::}", "a:b:c"},
+		{"${store.tags | join:\"|\"}", "a|b|c"},
+		{"${store.name | replace:\"o\":\"0:\"}", "c0:da"},
+		{"${store.name | replace:o}", "cda"},
+		{"${store.missing | default:none}", "none"},
+		{"${store.tags | length}", float64(3)},
+		{"${store.user | keys | join}", "first,last"},
+		{"${'2.5' | toNumber}", 2.5},
+		{"${'a b&c' | urlEncode}", "a+b%26c"},
+		{"${0 | date:2006-01-02}", "1970-01-01"},
+		{"${default(store.missing, 'x')}", "x"},
+		{"hello ${store.name}, you have ${store.count * 2} items", "hello coda, you have 6 items"},
+		{"no placeholder", "no placeholder"},
+		{"${`store.a-1`}", float64(4)},
+		{"${`store.a-1` - 1}", float64(3)},
+		{"${`store.two words` | upper}", "SPACED"},
+		{"${`store.us?r.first`}", "John"},
+		{"${`store.missing` ?? 'fallback'}", "fallback"},
+		{"$${store.name}", "${store.name}"},
+		{"$${store.name} ${store.count}", "${store.name} 3"},
+		{"${store.count} $${", "3 ${"},
+		{"echo $${HOME:-/tmp} ${store.name}", "echo ${HOME:-/tmp} coda"},
+		{"${store.item-1}", "first"},
+		{"${store.build-2024 - 1}", float64(6)},
+		{"${store.nothing}", nil},
+		{"${store.nothing ?? 'fallback'}", "fallback"},
+	}
+
+	r := jsonResolver(testVariablesJSON, false)
+	for _, tt := range tests {
+		got, err := r.resolveString(tt.input)
+		if err != nil {
+			t.Errorf("resolveString(%q) returned error: %v", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("resolveString(%q) = %#v, want %#v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestResolveStringErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		err   string
+	}{
+		{"${store.count +}", "unexpected end of expression"},
+		{"${store.count", "unterminated expression"},
+		{"${'open}", "unterminated expression"},
+		{"${store.name} ${store.count", "unterminated expression"},
+		{"echo ${HOME:-/tmp}", "unexpected \":\""},
+		{"${unknown(store.name)}", "unknown function \"unknown\""},
+		{"${store.count / 0}", "division by zero"},
+		{"${store.name - 1}", "operator \"-\" is not defined for string and number"},
+		{"${store.count > 'a'}", "cannot compare number with string"},
+		{"${store.enabled ? 1}", "expected \":\""},
+		{"${}", "empty expression"},
+		{"${`store.name}", "unterminated expression"},
+		{"${``}", "empty path"},
+	}
+
+	r := jsonResolver(testVariablesJSON, false)
+	for _, tt := range tests {
+		_, err := r.resolveString(tt.input)
+		if err == nil {
+			t.Errorf("resolveString(%q) expected an error", tt.input)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("resolveString(%q) error = %q, want it to contain %q", tt.input, err, tt.err)
+		}
+	}
+}
+
+func TestResolveStringStrict(t *testing.T) {
+	tests := []struct {
+		input string
+		err   string
+	}{
+		{"${store.usr.name}", "path 'store.usr.name' does not exist"},
+		{"prefix ${store.missing | upper}", "path 'store.missing' does not exist"},
+		{"${store.name | unknown}", "filter 'unknown': unknown filter"},
+		{"${store.count | upper}", "filter 'upper': expected string but got number"},
+		{"${store.name | substring:a:2}", "filter 'substring': argument 'start': expected integer but got 'a'"},
+		{"${store.name | join}", "filter 'join': expected array but got string"},
+		{"${store.name | replace}", "filter 'replace': missing argument 'old'"},
+		{"${store.name | upper:x}", "filter 'upper': expected no arguments"},
+	}
+
+	lenient := jsonResolver(testVariablesJSON, false)
+	strict := jsonResolver(testVariablesJSON, true)
+	for _, tt := range tests {
+		if _, err := lenient.resolveString(tt.input); err != nil {
+			t.Errorf("lenient resolveString(%q) returned error: %v", tt.input, err)
+		}
+		_, err := strict.resolveString(tt.input)
+		if err == nil {
+			t.Errorf("strict resolveString(%q) expected an error", tt.input)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("strict resolveString(%q) error = %q, want it to contain %q", tt.input, err, tt.err)
+		}
+	}
+
+	if got, err := strict.resolveString("${store.missing ?? 'fallback'}"); err != nil || got != "fallback" {
+		t.Errorf("strict null-coalescing = %#v, %v, want \"fallback\"", got, err)
+	}
+	if got, err := strict.resolveString("$${store.name}"); err != nil || got != "${store.name}" {
+		t.Errorf("strict escape = %#v, %v, want \"${store.name}\"", got, err)
+	}
+}
+
+func TestRegisterFilter(t *testing.T) {
+	err := RegisterFilter(FilterDefinition{
+		Name:        "testRepeat",
+		Description: "Repeats a string",
+		Arguments: []FilterArgument{
+			{Name: "count", Type: "integer", Mandatory: true},
+			{Name: "separator", Type: "string"},
+		},
+		Handler: func(val any, args []any) (any, error) {
+			s, ok := val.(string)
+			if !ok {
+				return nil, errUnsupportedType(val, "string")
+			}
+			parts := make([]string, args[0].(int))
+			for i := range parts {
+				parts[i] = s
+			}
+			return strings.Join(parts, args[1].(string)), nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to register filter: %v", err)
+	}
+	if err := RegisterFilter(FilterDefinition{Name: "testRepeat", Handler: func(val any, args []any) (any, error) { return val, nil }}); err == nil {
+		t.Errorf("expected an error when registering a filter twice")
+	}
+
+	r := jsonResolver(testVariablesJSON, true)
+	for input, want := range map[string]string{
+		"${store.name | testRepeat:2:\":\"}": "coda:coda",
+		"${testRepeat(store.name, 3, '-')}":  "coda-coda-coda",
+	} {
+		got, err := r.resolveString(input)
+		if err != nil || got != want {
+			t.Errorf("resolveString(%q) = %#v, %v, want %q", input, got, err, want)
+		}
+	}
+
+	if !strings.Contains(New().Schema(), `"name":"testRepeat"`) {
+		t.Errorf("expected the schema to list the registered filter")
+	}
+}
+
+func TestRegisterFilterWhileParsing(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := range 4 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			RegisterFilter(FilterDefinition{Name: fmt.Sprintf("testConcurrent%d", i), Handler: func(val any, args []any) (any, error) { return val, nil }})
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := New().FromJson(`{"operations": {"op": {"entrypoint": true, "action": "string", "params": {"value": "x"}}}}`); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if !strings.Contains(New().Schema(), `"name":"testConcurrent3"`) {
+		t.Errorf("expected the schema to list the concurrently registered filters")
+	}
+}
+
+func newLargeStoreCoda(tb testing.TB) *Coda {
+	c := New()
+	_, err := c.FromJson(`{
+		"store": {"name": "coda", "user": {"first": "John", "tags": ["a", "b"]}, "dotted.key": 1},
+		"secrets": {"token": "s3cr3t"},
+		"operations": {"op": {"entrypoint": true, "action": "string", "params": {"value": "${store.user.first | upper} ${secrets.token}"}}}
+	}`)
+	if err != nil {
+		tb.Fatalf("failed to load coda from JSON: %v", err)
+	}
+	c.Store["blob"] = utils.ReturnRaw(strings.Repeat("x", 4<<20))
+	return c
+}
+
+func TestQueryPath(t *testing.T) {
+	c := newLargeStoreCoda(t)
+	full, err := json.Marshal(c)
+	if err != nil {
+		t.Fatalf("failed to marshal coda: %v", err)
+	}
+
+	paths := []string{
+		"store.name", "store.user", "store.user.first", "store.user.tags.#", "store.user.tags.1",
+		"store.missing", "store.missing.deep", "store.dotted\\.key", "store.#", "store.u*",
+		"secrets.token", "coda.strict", "stats.variables_total", "operations.op.action", "logs.0",
+	}
+	for _, path := range paths {
+		got, want := c.queryPath(path), gjson.GetBytes(full, path)
+		if got.Exists() != want.Exists() || !reflect.DeepEqual(parseRaw(got), parseRaw(want)) {
+			t.Errorf("queryPath(%q) = %s (exists %v), want %s (exists %v)", path, got.Raw, got.Exists(), want.Raw, want.Exists())
+		}
+	}
+}
+
+func TestResolveVariablesConcurrently(t *testing.T) {
+	c := newLargeStoreCoda(t)
+	params := c.Operations["op"].Params
+	before := c.Stats.VariablesTotal
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 10 {
+				c.resolveVariables(params)
+			}
+		}()
+	}
+	wg.Wait()
+	if c.Stats.VariablesTotal-before != 80 {
+		t.Errorf("expected 80 counted resolutions, got %v", c.Stats.VariablesTotal-before)
+	}
+}
+
+func BenchmarkResolveVariables(b *testing.B) {
+	c := newLargeStoreCoda(b)
+	params := c.Operations["op"].Params
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.resolveVariables(params); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkResolveVariablesMarshalled resolves by marshalling the whole
+// instance for every operation, as a baseline for BenchmarkResolveVariables.
+func BenchmarkResolveVariablesMarshalled(b *testing.B) {
+	c := newLargeStoreCoda(b)
+	params := c.Operations["op"].Params
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		codaJSON, err := json.Marshal(c)
+		if err != nil {
+			b.Fatal(err)
+		}
+		var input any
+		if err := json.Unmarshal(params, &input); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := jsonResolver(codaJSON, false).resolveValue(input); err != nil {
+			b.Fatal(err)
+		}
+	}
+}